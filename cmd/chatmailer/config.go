@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/forwarder"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+	"github.com/hickar/chatmailer/internal/app/retriever"
+)
+
+const configUsage = `Usage: chatmailer config validate [--config FILE]
+
+Loads configuration file and checks it along with its filters,
+templates and quiet hours schedules, printing every found problem.`
+
+// runConfigCommand executes 'config' subcommand, which
+// checks configuration without starting the daemon.
+func runConfigCommand(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(stderr, configUsage)
+		return errors.New("unknown config subcommand")
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, configUsage)
+		fs.PrintDefaults()
+	}
+
+	configPath := fs.String("config", "./config.yaml", "Filepath to configuration file.")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.NewFromFile(*configPath)
+	if err != nil {
+		return fmt.Errorf("load configuration: %w", err)
+	}
+
+	problems := validationProblems(validateConfig(cfg))
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintf(stdout, "- %s\n", problem)
+		}
+
+		return fmt.Errorf("configuration has %d problems", len(problems))
+	}
+
+	fmt.Fprintf(stdout, "%s: configuration is valid\n", *configPath)
+
+	return nil
+}
+
+// validateConfig checks configuration, filters, templates and quiet hours
+// schedules, returning error joining every found problem.
+func validateConfig(cfg config.Config) error {
+	errs := []error{
+		cfg.Validate(),
+		retriever.ValidateFilters(cfg.Clients),
+		mailer.ValidateQuietHours(cfg.Clients),
+	}
+
+	templates, err := forwarder.NewTemplateLibrary(cfg.Templates)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("load templates: %w", err))
+	} else {
+		errs = append(errs, templates.Validate(cfg.Clients))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validationProblems returns separate problems of validation error,
+// walking errors joined by validators.
+func validationProblems(err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var problems []string
+		for _, err := range joined.Unwrap() {
+			problems = append(problems, validationProblems(err)...)
+		}
+
+		return problems
+	}
+
+	return []string{err.Error()}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationProblems(t *testing.T) {
+	tests := []struct {
+		err  error
+		want []string
+	}{
+		{err: nil, want: nil},
+		{err: errors.New("clients: no clients specified"), want: []string{"clients: no clients specified"}},
+		{
+			// Joined errors are walked, even when nested or empty.
+			err: errors.Join(
+				errors.Join(errors.New("first"), errors.New("second")),
+				errors.Join(),
+				errors.New("third"),
+			),
+			want: []string{"first", "second", "third"},
+		},
+		{
+			// Errors spanning several lines are kept as single problem.
+			err: errors.Join(
+				fmt.Errorf("template %q: %w", "alert", errors.New("parse:\n  {{ .Subject\n  ^")),
+				errors.New("last"),
+			),
+			want: []string{"template \"alert\": parse:\n  {{ .Subject\n  ^", "last"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			assert.Equal(t, tt.want, validationProblems(tt.err))
+		})
+	}
+}
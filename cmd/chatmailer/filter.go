@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/retriever"
+)
+
+const filterUsage = `Usage: chatmailer filter test --expr EXPRESSION [--trusted-authserv-id ID]... [--eml FILE]... [FILE]...
+
+Parses filter expression, prints IMAP SEARCH command and syntax tree
+it is compiled into and evaluates it against messages in '.eml' files.`
+
+// stringsFlag is flag which may be specified multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// runFilterCommand executes 'filter' subcommand, which helps
+// to debug filter expressions without polling mail server.
+func runFilterCommand(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(stderr, filterUsage)
+		return errors.New("unknown filter subcommand")
+	}
+
+	fs := flag.NewFlagSet("filter test", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, filterUsage)
+		fs.PrintDefaults()
+	}
+
+	expr := fs.String("expr", "", "Filter expression to test.")
+	var files stringsFlag
+	fs.Var(&files, "eml", "Path to '.eml' file to evaluate filter against, may be repeated.")
+	var trustedIDs stringsFlag
+	fs.Var(&trustedIDs, "trusted-authserv-id",
+		"Identifier of server which 'Authentication-Results' headers are trusted, may be repeated.")
+
+	// Files may be specified both before and after flags.
+	for rest := args[1:]; ; {
+		if err := fs.Parse(rest); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+
+		files = append(files, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+
+	if *expr == "" {
+		fs.Usage()
+		return errors.New("filter expression is not specified")
+	}
+
+	filter, err := retriever.CompileFilter(*expr)
+	if err != nil {
+		var filterErr *retriever.FilterError
+		if errors.As(err, &filterErr) {
+			fmt.Fprintf(stderr, "  %s\n  %s^\n", *expr, strings.Repeat(" ", filterErr.Column-1))
+		}
+
+		return fmt.Errorf("parse filter: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "IMAP: %s\n", retriever.FormatSearchCriteria(filter.Criteria()))
+	if !filter.Exact() {
+		fmt.Fprintln(stdout, "      (selects superset of matching messages, which are checked on client side)")
+	}
+	fmt.Fprintf(stdout, "AST:  %s\n", filter)
+
+	var failed int
+	for _, path := range files {
+		if err = testFilter(stdout, filter, path, trustedIDs); err != nil {
+			fmt.Fprintf(stdout, "\n%s: error: %v\n", path, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to evaluate filter against %d of %d messages", failed, len(files))
+	}
+
+	return nil
+}
+
+func testFilter(w io.Writer, filter *retriever.Filter, path string, trustedIDs []string) error {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	msg, err := retriever.ReadMessage(f, config.SpoolConfiguration{}, trustedIDs)
+	if err != nil {
+		return err
+	}
+	defer msg.Close()
+
+	matched, reasons, err := filter.Explain(msg)
+	if err != nil {
+		return fmt.Errorf("evaluate filter: %w", err)
+	}
+
+	result := "no match"
+	if matched {
+		result = "match"
+	}
+
+	fmt.Fprintf(w, "\n%s: %s\n", path, result)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %s\n", reason)
+	}
+
+	return nil
+}
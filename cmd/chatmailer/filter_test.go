@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const alertMessage = "From: alerts@example.com\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: Disk is full\r\n" +
+	"Date: Mon, 10 Mar 2025 10:42:00 +0000\r\n" +
+	"Authentication-Results: mx.example.org; spf=pass smtp.mailfrom=example.com\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"100%\r\n"
+
+func TestRunFilterCommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile("alert.eml", []byte(alertMessage), 0o600))
+
+	tests := []struct {
+		args       []string
+		wantStdout string
+		wantStderr string
+		wantErr    string
+	}{
+		{
+			args: []string{"test", "--expr", "FROM == 'alerts@example.com' && SUBJECT == 'Disk is full'", "alert.eml"},
+			wantStdout: `IMAP: SEARCH FROM "alerts@example.com" SUBJECT "Disk is full"
+AST:  (AND (FROM == "alerts@example.com") (SUBJECT == "Disk is full"))
+
+alert.eml: match
+  (FROM == "alerts@example.com") is true
+  (SUBJECT == "Disk is full") is true
+`,
+		},
+		{
+			// Files may be specified both by flag and after flags.
+			args: []string{"test", "--eml", "alert.eml", "--expr", "SUBJECT == 'Backup failed'", "missing.eml"},
+			wantStdout: `IMAP: SEARCH SUBJECT "Backup failed"
+AST:  (SUBJECT == "Backup failed")
+
+alert.eml: no match
+  (SUBJECT == "Backup failed") is false
+
+missing.eml: error: open file: open missing.eml: no such file or directory
+`,
+			wantErr: "failed to evaluate filter against 1 of 2 messages",
+		},
+		{
+			// Authentication-Results headers are ignored, unless their server is trusted.
+			args: []string{"test", "--expr", "SPF == 'pass'", "alert.eml"},
+			wantStdout: `IMAP: SEARCH ALL
+      (selects superset of matching messages, which are checked on client side)
+AST:  (SPF == "pass")
+
+alert.eml: no match
+  (SPF == "pass") is false
+`,
+		},
+		{
+			args: []string{"test", "--expr", "SPF == 'pass'", "--trusted-authserv-id", "mx.example.org", "alert.eml"},
+			wantStdout: `IMAP: SEARCH ALL
+      (selects superset of matching messages, which are checked on client side)
+AST:  (SPF == "pass")
+
+alert.eml: match
+  (SPF == "pass") is true
+`,
+		},
+		{
+			args:       []string{"test", "--expr", "SUBJECT == "},
+			wantStderr: "  SUBJECT == \n             ^\n",
+			wantErr:    "parse filter: col 12: expected quoted string, got end of expression",
+		},
+		{
+			args:       []string{"list"},
+			wantStderr: filterUsage + "\n",
+			wantErr:    "unknown filter subcommand",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			err := runFilterCommand(tt.args, &stdout, &stderr)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.wantStdout, stdout.String())
+			assert.Equal(t, tt.wantStderr, stderr.String())
+		})
+	}
+}
+
+func TestRunFilterCommandNoExpression(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	err := runFilterCommand([]string{"test", "alert.eml"}, &stdout, &stderr)
+	assert.EqualError(t, err, "filter expression is not specified")
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), filterUsage)
+	assert.Contains(t, stderr.String(), "-trusted-authserv-id")
+}
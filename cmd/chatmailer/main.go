@@ -23,6 +23,30 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "filter" {
+		if err := runFilterCommand(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+
+			log.Fatalf("filter: %v", err)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfigCommand(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+
+			log.Fatalf("config: %v", err)
+		}
+
+		return
+	}
+
 	configPath := flag.String("config", "./config.yaml", "Filepath to configuration file. Default is '.config.yaml'")
 	flag.Parse()
 
@@ -31,6 +55,10 @@ func main() {
 		log.Fatalf("load configuration: %v", err)
 	}
 
+	if err = validateConfig(cfg); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
 	// Create logger with custom handler able
 	// to store log attributes within context.Context.
 	logger := slog.New(xlogger.NewContextHandler(
@@ -40,18 +68,31 @@ func main() {
 		}),
 	))
 
+	templates, err := forwarder.NewTemplateLibrary(cfg.Templates)
+	if err != nil {
+		log.Fatalf("load templates: %v", err)
+	}
+
+	filters, err := retriever.NewFilterMatcher(cfg.Clients)
+	if err != nil {
+		log.Fatalf("compile contact points filters: %v", err)
+	}
+
 	runner := mailer.NewRunner(
 		cfg,
 		kvstore.New[string, config.ClientConfig](),
 		retriever.NewIMAPRetriever(
 			retriever.ImapDialerFunc(imapclient.DialTLS),
+			cfg.Spool,
 			logger,
 		),
 		forwarder.NewTelegramForwarder(
 			http.DefaultClient,
 			cfg.Forwarders.Telegram,
+			templates,
 			logger.With(slog.String("module", "telegram_forwarder")),
 		),
+		filters,
 		logger.With(slog.String("module", "runner")),
 	)
 
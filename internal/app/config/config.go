@@ -25,17 +25,50 @@ type Config struct {
 	RetryDelayMax int `yaml:"retry_delay_max"`
 	// Logging level
 	LogLevel slog.Level `yaml:"log_level"`
+	// Storage settings for content of retrieved messages.
+	Spool SpoolConfiguration `yaml:"spool"`
+	// Named notification templates shared between contact points.
+	Templates TemplatesConfiguration `yaml:"templates"`
+	// Deduplication of messages delivered to the same chat.
+	Dedupe DedupeConfiguration `yaml:"dedupe"`
 	// List of email client configurations.
 	Clients []ClientConfig `yaml:"clients"`
 }
 
+type SpoolConfiguration struct {
+	// Directory for temporary files holding large message parts.
+	// Defaults to OS temporary directory.
+	Directory string `yaml:"directory"`
+	// Maximum size of message part kept in memory, larger ones
+	// are stored in temporary files. Defaults to 1MiB.
+	MemoryLimit units.ByteSize `yaml:"memory_limit"`
+}
+
+type DedupeConfiguration struct {
+	// Period message is not delivered to the same chat again within, even if
+	// it is retrieved from another mailbox or by another client. Messages are
+	// identified by 'Message-ID' header or, if it is missing, by hash of sender,
+	// subject, date and body. Deduplication is disabled if not specified.
+	Window time.Duration `yaml:"window"`
+}
+
+type TemplatesConfiguration struct {
+	// Directory with '.tmpl' template files, named after file without extension.
+	Directory string `yaml:"directory"`
+	// Templates content by their names.
+	Definitions map[string]string `yaml:"definitions"`
+}
+
 type ForwarderConfiguration struct {
 	Telegram TelegramConfiguration `yaml:"telegram"`
 }
 
 type TelegramConfiguration struct {
-	BotToken  string `yaml:"bot_token"`
-	WebAppURL string `yaml:"web_app_url"`
+	BotToken Secret `yaml:"bot_token"`
+	// Path to file holding bot token, like Docker or Kubernetes secret.
+	// Mutually exclusive with bot token.
+	BotTokenFile string `yaml:"bot_token_file"`
+	WebAppURL    string `yaml:"web_app_url"`
 }
 
 type ClientConfig struct {
@@ -45,8 +78,11 @@ type ClientConfig struct {
 	Address string `yaml:"address"`
 	// Email account username.
 	Login string `yaml:"login"`
-	// Email account password (stored securely).
-	Password string `yaml:"password"`
+	// Email account password.
+	Password Secret `yaml:"password"`
+	// Path to file holding email account password, like Docker
+	// or Kubernetes secret. Mutually exclusive with password.
+	PasswordFile string `yaml:"password_file"`
 	// Whether to mark retrieved emails as seen on the server.
 	MarkAsSeen bool `yaml:"mark_as_seen"`
 	// Optional filters for selecting specific emails.
@@ -55,17 +91,28 @@ type ClientConfig struct {
 	LastUIDNext uint32 `yaml:"last_uid_next"`
 	// Internal state for tracking processed emails. (TODO: Explain usage)
 	LastUIDValidity uint32 `yaml:"last_uid_validity"`
+	// Internal state: time messages were delivered at by their dedupe
+	// keys, grouped by destinations of client's contact points.
+	Deliveries map[string]map[string]time.Time `yaml:"-"`
 	// Whether to include email attachments in notifications.
 	IncludeAttachments bool `yaml:"include_attachments"`
 	// Maximum size of attachments allowed to be processed and uploaded.
 	MaximumAttachmentsSize units.ByteSize `yaml:"maximum_attachments_size"`
+	// Whether to verify DKIM signatures of retrieved messages. Requires
+	// whole message to be downloaded, including omitted attachments.
+	VerifyDKIM bool `yaml:"verify_dkim"`
+	// Identifiers ('authserv-id') of servers which 'Authentication-Results'
+	// headers are trusted. If empty, these headers are ignored.
+	TrustedAuthServIDs []string `yaml:"trusted_authserv_ids"`
 	// List of notification destinations.
 	ContactPoints []ContactPointConfiguration `yaml:"contact_points"`
 }
 
 type ContactPointConfiguration struct {
+	// Optional name identifying contact point in logs.
+	Name string `yaml:"name"`
 	// Telegram bot token for sending notifications.
-	TGBotToken string `yaml:"tg_bot_token"`
+	TGBotToken Secret `yaml:"tg_bot_token"`
 	// Telegram chat ID for receiving notifications.
 	TGChatID int64 `yaml:"tg_chat_id"`
 	// Whether to send notifications silently (without notification sound).
@@ -74,26 +121,98 @@ type ContactPointConfiguration struct {
 	DisableForwarding bool `yaml:"disable_forwarding"`
 	// Optional template for customizing notification content.
 	Template string `yaml:"template"`
+	// Optional name of template from templates library.
+	// Takes precedence over inline template.
+	TemplateName string `yaml:"template_name"`
 	// Forwarding client type, for example telegram.
 	Type string `yaml:"type"`
 	// Mode for parsing entities in the message text.
 	// Possible values: 'HTML', 'MarkdownV2', 'Markdown'.
 	ParseMode *string `yaml:"parse_mode,omitempty"`
+	// Optional filters messages have to satisfy to be sent to contact point.
+	// Evaluated on retrieved messages, after client filters.
+	Filters []string `yaml:"filters"`
+	// Whether contact point receives only messages not matched
+	// by any other contact point with filters specified.
+	Fallback bool `yaml:"fallback"`
+	// Optional digest mode, in which messages are sent
+	// as single summary notification periodically.
+	Digest DigestConfiguration `yaml:"digest"`
+	// Optional quiet hours, during which messages are
+	// sent silently, delayed or dropped.
+	QuietHours QuietHoursConfiguration `yaml:"quiet_hours"`
+	// Optional grouping of messages with the same normalized subject
+	// into single notification, edited with counter of them.
+	Grouping GroupingConfiguration `yaml:"grouping"`
 }
 
+// DigestConfiguration describes digest mode of contact point, enabled
+// if either window or maximum number of messages is specified.
+// Buffered messages are kept in memory and lost on restart.
+type DigestConfiguration struct {
+	// Period messages are buffered for, starting from the first one.
+	Window time.Duration `yaml:"window"`
+	// Number of buffered messages digest is sent upon reaching.
+	MaxMessages int `yaml:"max_messages"`
+	// Filters any of which messages are sent immediately upon matching, bypassing digest.
+	UrgentFilters []string `yaml:"urgent_filters"`
+	// Optional template for customizing digest content.
+	Template string `yaml:"template"`
+	// Optional name of digest template from templates library.
+	// Takes precedence over inline template.
+	TemplateName string `yaml:"template_name"`
+}
+
+// QuietHoursConfiguration describes weekly recurring periods of contact point
+// quiet hours, enabled if schedule is specified. Delayed messages are kept
+// in memory and lost on restart.
+type QuietHoursConfiguration struct {
+	// Time ranges of quiet hours, like "Mon-Fri 19:00-09:00" or "Sat,Sun".
+	Schedule []string `yaml:"schedule"`
+	// IANA time zone of schedule, like "Europe/Berlin". Defaults to local one.
+	Timezone string `yaml:"timezone"`
+	// Action applied to messages during quiet hours.
+	// Possible values: 'silent', 'delay', 'drop'. Defaults to 'silent'.
+	Action string `yaml:"action"`
+}
+
+// GroupingConfiguration describes grouping of repeated messages of contact
+// point, enabled if window is specified. Open groups are kept in memory
+// and lost on restart.
+type GroupingConfiguration struct {
+	// Period group stays open for since its last message.
+	Window time.Duration `yaml:"window"`
+}
+
+// NewFromFile loads configuration from YAML file. References to environment
+// variables in values, like "${IMAP_PASSWORD}", are replaced with their values
+// and secrets specified by files, like 'password_file', are read from them.
 func NewFromFile(configPath string) (Config, error) {
 	var cfg Config
 
-	//TODO: Need to consider secure alternatives in production.
 	//nolint:gosec
-	file, err := os.Open(configPath)
+	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return cfg, fmt.Errorf("open file: %w", err)
+		return cfg, fmt.Errorf("read file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return cfg, fmt.Errorf("decode yaml: %w", err)
+	}
+
+	if err = expandEnv(&doc, os.LookupEnv); err != nil {
+		return cfg, fmt.Errorf("expand environment variables: %w", err)
 	}
 
-	if err = yaml.NewDecoder(file).Decode(&cfg); err != nil {
+	// Unknown fields are rejected, so misspelled options are not silently ignored.
+	if err = decodeStrict(&doc, &cfg); err != nil {
 		return cfg, fmt.Errorf("decode yaml: %w", err)
 	}
 
+	if err = cfg.resolveSecretFiles(); err != nil {
+		return cfg, fmt.Errorf("resolve secret files: %w", err)
+	}
+
 	return cfg, nil
 }
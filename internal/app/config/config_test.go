@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFromFileExample(t *testing.T) {
+	example, err := os.ReadFile("../../../config.example.yaml")
+	require.NoError(t, err)
+
+	// Chat IDs are placeholders in example.
+	content := strings.NewReplacer("your_chat_id", "1", "your_other_chat_id", "2").Replace(string(example))
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	cfg, err := NewFromFile(path)
+	require.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestNewFromFileUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("clients:\n  - login: user\n    maximum_attachment_size: 50M\n"), 0o600))
+
+	_, err := NewFromFile(path)
+	assert.ErrorContains(t, err, "field maximum_attachment_size not found")
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		return Config{
+			MailPollInterval:    30 * time.Second,
+			MailPollTaskTimeout: 30 * time.Second,
+			Forwarders:          ForwarderConfiguration{Telegram: TelegramConfiguration{BotToken: "token"}},
+			Clients: []ClientConfig{{
+				Proto:         "imap",
+				Address:       "imap.example.com:993",
+				Login:         "user@example.com",
+				ContactPoints: []ContactPointConfiguration{{Type: "telegram", TGChatID: 1}},
+			}},
+		}
+	}
+	markdown := "markdown"
+
+	tests := []struct {
+		modify  func(cfg *Config)
+		wantErr []string
+	}{
+		{modify: func(*Config) {}},
+		{
+			modify: func(cfg *Config) {
+				cfg.MailPollInterval = 0
+				cfg.RetryDelayMin = 10
+				cfg.RetryDelayMax = 5
+				cfg.Forwarders.Telegram.BotToken = ""
+			},
+			wantErr: []string{
+				"mail_poll_interval: has to be positive",
+				"retry_delay_max: must not be less than retry_delay_min",
+				"forwarders.telegram.bot_token: not specified",
+			},
+		},
+		{
+			modify: func(cfg *Config) {
+				cfg.Clients[0].Proto = "pop3"
+				cfg.Clients[0].Address = "imap.example.com"
+				cfg.Clients = append(cfg.Clients, cfg.Clients[0])
+			},
+			wantErr: []string{
+				`client "user@example.com": proto: unknown protocol "pop3", expected 'imap'`,
+				`client "user@example.com": address: address imap.example.com: missing port in address`,
+				`client "user@example.com": proto: unknown protocol "pop3", expected 'imap'`,
+				`client "user@example.com": address: address imap.example.com: missing port in address`,
+				`client "user@example.com": login is specified by several clients`,
+			},
+		},
+		{
+			modify: func(cfg *Config) {
+				cfg.Clients[0].Login = ""
+				cfg.Clients[0].ContactPoints = nil
+			},
+			wantErr: []string{
+				`client "#1": login: not specified`,
+				`client "#1": contact_points: no contact points specified`,
+			},
+		},
+		{
+			modify: func(cfg *Config) {
+				cfg.Clients[0].ContactPoints = []ContactPointConfiguration{
+					{Name: "on-call", Type: "telegram", TGChatID: 1, ParseMode: &markdown, Grouping: GroupingConfiguration{Window: -time.Hour}},
+					{Type: "slack", Digest: DigestConfiguration{MaxMessages: -1}},
+					{Type: "telegram"},
+				}
+			},
+			wantErr: []string{
+				`client "user@example.com": contact point on-call: parse_mode: unknown mode "markdown", expected one of 'HTML', 'MarkdownV2', 'Markdown'`,
+				`client "user@example.com": contact point on-call: grouping.window: must not be negative`,
+				`client "user@example.com": contact point #2 (slack): type: unknown contact point type "slack", expected 'telegram'`,
+				`client "user@example.com": contact point #2 (slack): digest.max_messages: must not be negative`,
+				`client "user@example.com": contact point #3 (telegram): tg_chat_id: not specified`,
+			},
+		},
+		{
+			modify:  func(cfg *Config) { cfg.Clients = nil },
+			wantErr: []string{"clients: no clients specified"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantErr) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.EqualError(t, err, strings.Join(tt.wantErr, "\n"))
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"cmp"
+	"encoding"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	yamlUnmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+)
+
+// decodeStrict decodes document node into v, rejecting unknown fields.
+// Node is decoded directly, unlike encoded document, so errors refer
+// to lines of original file even after its values are changed.
+func decodeStrict(doc *yaml.Node, v any) error {
+	// Node decoding has no option to reject unknown fields,
+	// so they are checked separately.
+	problems := unknownFields(doc, reflect.TypeOf(v))
+
+	if err := doc.Decode(v); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return err
+		}
+
+		problems = append(problems, typeErr.Errors...)
+	}
+
+	if len(problems) > 0 {
+		slices.SortStableFunc(problems, func(a, b string) int {
+			return cmp.Compare(problemLine(a), problemLine(b))
+		})
+
+		return &yaml.TypeError{Errors: problems}
+	}
+
+	return nil
+}
+
+// problemLine returns line number decoding problem starts with, like "line 5: ...".
+func problemLine(problem string) int {
+	var line int
+	_, _ = fmt.Sscanf(problem, "line %d:", &line)
+
+	return line
+}
+
+// unknownFields returns problems with mapping keys not
+// matching any field of structures node is decoded into.
+func unknownFields(node *yaml.Node, t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Values decoded by types themselves are not checked.
+	if reflect.PointerTo(t).Implements(yamlUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+
+	var problems []string
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			problems = append(problems, unknownFields(child, t)...)
+		}
+
+	case yaml.AliasNode:
+		problems = unknownFields(node.Alias, t)
+
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+
+		for _, child := range node.Content {
+			problems = append(problems, unknownFields(child, t.Elem())...)
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			switch {
+			case key.Tag == "!!merge":
+				problems = append(problems, unknownFields(value, t)...)
+
+			case t.Kind() == reflect.Map:
+				problems = append(problems, unknownFields(value, t.Elem())...)
+
+			case t.Kind() == reflect.Struct:
+				field, ok := structField(t, key.Value)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, t))
+					continue
+				}
+
+				problems = append(problems, unknownFields(value, field)...)
+			}
+		}
+	}
+
+	return problems
+}
+
+// structField returns type of structure field decoded from key, named
+// by yaml tag or, if there is none, lower-cased name of the field.
+func structField(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if strings.Contains(options, "inline") {
+			// Inlined maps hold all keys not matching other fields.
+			if field.Type.Kind() == reflect.Map {
+				return field.Type.Elem(), true
+			}
+			if inlined, ok := structField(field.Type, key); ok {
+				return inlined, true
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if name == key {
+			return field.Type, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret is configuration value, like password or token, which is
+// redacted whenever it is formatted, logged or marshaled.
+// Its actual value is accessible by conversion to string only.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Redact replaces occurrences of secret in s, for example
+// in errors containing URL with secret token.
+func (s Secret) Redact(text string) string {
+	if s == "" {
+		return text
+	}
+
+	return strings.ReplaceAll(text, string(s), redacted)
+}
+
+// envRefRe matches references to environment variables, like "${IMAP_PASSWORD}",
+// and escaped ones, like "$${IMAP_PASSWORD}", which are kept as is, only unescaped.
+var envRefRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces references to environment variables in scalar values
+// of document with their values. Keys are kept as is. Referenced variables
+// have to be set, even if empty. Nodes keep their original lines, so
+// decoding errors refer to lines of original file.
+func expandEnv(node *yaml.Node, lookup func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		return expandEnvNodes(node.Content, lookup)
+
+	case yaml.MappingNode:
+		values := make([]*yaml.Node, 0, len(node.Content)/2)
+		for i := 1; i < len(node.Content); i += 2 {
+			values = append(values, node.Content[i])
+		}
+
+		return expandEnvNodes(values, lookup)
+
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+
+		var err error
+		node.Value = envRefRe.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if strings.HasPrefix(ref, "$$") {
+				return ref[1:]
+			}
+
+			name := envRefRe.FindStringSubmatch(ref)[1]
+			value, ok := lookup(name)
+			if !ok && err == nil {
+				err = fmt.Errorf("line %d: environment variable %q is not set", node.Line, name)
+			}
+
+			return value
+		})
+
+		// Plain values are resolved once again, if they are numbers or booleans,
+		// so references may be used for chat IDs. Others are kept strings, so
+		// secrets like "null" or "~" are not turned into empty values.
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = "!!str"
+			if isIntOrBool(node.Value) {
+				node.Tag = ""
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func expandEnvNodes(nodes []*yaml.Node, lookup func(string) (string, bool)) error {
+	var errs []error
+
+	for _, node := range nodes {
+		if err := expandEnv(node, lookup); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isIntOrBool(s string) bool {
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return true
+	}
+
+	_, err := strconv.ParseBool(s)
+
+	return err == nil
+}
+
+// readSecretFile returns content of file holding secret, like Docker or
+// Kubernetes secret, without trailing line break.
+func readSecretFile(path string) (Secret, error) {
+	//nolint:gosec
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret file: %w", err)
+	}
+
+	return Secret(strings.TrimRight(string(content), "\r\n")), nil
+}
+
+// resolveSecretFiles reads secrets specified by their files.
+func (c *Config) resolveSecretFiles() error {
+	var errs []error
+
+	telegram := &c.Forwarders.Telegram
+	if err := resolveSecretFile(&telegram.BotToken, telegram.BotTokenFile); err != nil {
+		errs = append(errs, fmt.Errorf("forwarders.telegram.bot_token_file: %w", err))
+	}
+
+	for i := range c.Clients {
+		client := &c.Clients[i]
+		if err := resolveSecretFile(&client.Password, client.PasswordFile); err != nil {
+			errs = append(errs, fmt.Errorf("client %q: password_file: %w", client.Login, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func resolveSecretFile(secret *Secret, path string) error {
+	if path == "" {
+		return nil
+	}
+	if *secret != "" {
+		return errors.New("secret is specified both inline and by file")
+	}
+
+	var err error
+	*secret, err = readSecretFile(path)
+
+	return err
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestNewFromFileEnv(t *testing.T) {
+	t.Setenv("TG_BOT_TOKEN", "123:token")
+	t.Setenv("IMAP_PASSWORD", `pa"ss: #word`)
+	t.Setenv("TG_CHAT_ID", "-100500")
+	t.Setenv("EMPTY", "")
+
+	cfg, err := NewFromFile(writeConfig(t, `
+forwarders:
+  telegram:
+    bot_token: ${TG_BOT_TOKEN}
+clients:
+  - login: "user${EMPTY}@example.com"
+    password: ${IMAP_PASSWORD}
+    filters:
+      - "SUBJECT == '$${NOT_EXPANDED}'"
+    contact_points:
+      - type: telegram
+        tg_chat_id: ${TG_CHAT_ID}
+        template: "{{ .Subject }} $MAYBE"
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, Secret("123:token"), cfg.Forwarders.Telegram.BotToken)
+	require.Len(t, cfg.Clients, 1)
+	assert.Equal(t, "user@example.com", cfg.Clients[0].Login)
+	assert.Equal(t, Secret(`pa"ss: #word`), cfg.Clients[0].Password)
+	assert.Equal(t, []string{"SUBJECT == '${NOT_EXPANDED}'"}, cfg.Clients[0].Filters)
+	assert.Equal(t, int64(-100500), cfg.Clients[0].ContactPoints[0].TGChatID)
+	assert.Equal(t, "{{ .Subject }} $MAYBE", cfg.Clients[0].ContactPoints[0].Template)
+
+	_, err = NewFromFile(writeConfig(t, "clients:\n  - login: user\n    password: ${MISSING_PASSWORD}\n"))
+	assert.EqualError(t, err, `expand environment variables: line 3: environment variable "MISSING_PASSWORD" is not set`)
+
+	// Decoding errors refer to lines of original file, if nothing is expanded.
+	_, err = NewFromFile(writeConfig(t, "\n\nclients:\n  - login: user\n    passwrd: secret\n"))
+	assert.EqualError(t, err, "decode yaml: yaml: unmarshal errors:\n  line 5: field passwrd not found in type config.ClientConfig")
+}
+
+func TestNewFromFileEnvValues(t *testing.T) {
+	t.Setenv("NULL_PASSWORD", "null")
+	t.Setenv("TILDE_PASSWORD", "~")
+	t.Setenv("LOGIN", "true")
+	t.Setenv("CHAT_ID", "0x10")
+	t.Setenv("SILENT", "true")
+
+	// Values resembling null are kept strings, while numbers and booleans are resolved.
+	cfg, err := NewFromFile(writeConfig(t, `
+clients:
+  - login: ${LOGIN}
+    password: ${NULL_PASSWORD}
+    contact_points:
+      - type: telegram
+        tg_chat_id: ${CHAT_ID}
+        silent_mode: ${SILENT}
+  - login: another
+    password: ${TILDE_PASSWORD}
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Clients, 2)
+	assert.Equal(t, "true", cfg.Clients[0].Login)
+	assert.Equal(t, Secret("null"), cfg.Clients[0].Password)
+	assert.Equal(t, int64(16), cfg.Clients[0].ContactPoints[0].TGChatID)
+	assert.True(t, cfg.Clients[0].ContactPoints[0].SilentMode)
+	assert.Equal(t, Secret("~"), cfg.Clients[1].Password)
+
+	// Decoding errors refer to lines of original file after expansion as well.
+	t.Setenv("CHAT_ID", "chat")
+	_, err = NewFromFile(writeConfig(t, `
+clients:
+  - login: user
+    password: "${NULL_PASSWORD}"
+
+
+    contact_points:
+      - type: telegram
+        tg_chat_id: ${CHAT_ID}
+        silent: true
+`))
+	assert.EqualError(t, err, "decode yaml: yaml: unmarshal errors:\n"+
+		"  line 9: cannot unmarshal !!str `chat` into int64\n"+
+		"  line 10: field silent not found in type config.ContactPointConfiguration")
+}
+
+func TestNewFromFileSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "password"), []byte("secret\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("123:token"), 0o600))
+
+	cfg, err := NewFromFile(writeConfig(t, fmt.Sprintf(`
+forwarders:
+  telegram:
+    bot_token_file: %q
+clients:
+  - login: user
+    password_file: %q
+`, filepath.Join(dir, "token"), filepath.Join(dir, "password"))))
+	require.NoError(t, err)
+
+	assert.Equal(t, Secret("123:token"), cfg.Forwarders.Telegram.BotToken)
+	assert.Equal(t, Secret("secret"), cfg.Clients[0].Password)
+
+	_, err = NewFromFile(writeConfig(t, fmt.Sprintf(`
+clients:
+  - login: user
+    password: secret
+    password_file: %q
+  - login: another
+    password_file: %q
+`, filepath.Join(dir, "password"), filepath.Join(dir, "missing"))))
+	assert.ErrorContains(t, err, `client "user": password_file: secret is specified both inline and by file`)
+	assert.ErrorContains(t, err, `client "another": password_file: read secret file: open `)
+}
+
+func TestSecretRedacted(t *testing.T) {
+	client := ClientConfig{Login: "user", Password: "secret"}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	logger.Info("client", slog.Any("password", client.Password), slog.Any("client", client))
+	slog.New(slog.NewJSONHandler(&logs, nil)).Info("client", slog.Any("client", client))
+
+	encoded, err := json.Marshal(client)
+	require.NoError(t, err)
+
+	for _, formatted := range []string{
+		fmt.Sprintf("%v %+v %#v %s", client, client, client, client.Password),
+		logs.String(),
+		string(encoded),
+	} {
+		assert.NotContains(t, formatted, "secret")
+		assert.Contains(t, formatted, redacted)
+	}
+
+	assert.Equal(t, "secret", string(client.Password))
+	assert.Equal(t, "", Secret("").String())
+	assert.Equal(t, "https://api.example.com/bot[REDACTED]/getMe", Secret("123:token").Redact("https://api.example.com/bot123:token/getMe"))
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+const (
+	protoIMAP            = "imap"
+	contactPointTelegram = "telegram"
+)
+
+// parseModes are Telegram parse modes contact points may specify.
+var parseModes = []string{"HTML", "MarkdownV2", "Markdown"}
+
+// Validate checks configuration for problems, which would otherwise surface
+// only at runtime, such as missing contact points, malformed server addresses
+// or unknown contact point types. Filters, templates and schedules are checked
+// by packages evaluating them.
+//
+// Returned error joins every found problem.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.MailPollInterval <= 0 {
+		errs = append(errs, errors.New("mail_poll_interval: has to be positive"))
+	}
+	if c.MailPollTaskTimeout <= 0 {
+		errs = append(errs, errors.New("mail_poll_task_timeout: has to be positive"))
+	}
+	if c.RetryCount < 0 {
+		errs = append(errs, errors.New("retry_count: must not be negative"))
+	}
+	if c.RetryDelayMin < 0 || c.RetryDelayMax < 0 {
+		errs = append(errs, errors.New("retry_delay_min, retry_delay_max: must not be negative"))
+	} else if c.RetryDelayMax < c.RetryDelayMin {
+		errs = append(errs, errors.New("retry_delay_max: must not be less than retry_delay_min"))
+	}
+	if c.Spool.MemoryLimit < 0 {
+		errs = append(errs, errors.New("spool.memory_limit: must not be negative"))
+	}
+	if c.Dedupe.Window < 0 {
+		errs = append(errs, errors.New("dedupe.window: must not be negative"))
+	}
+
+	telegram := slices.ContainsFunc(c.Clients, func(client ClientConfig) bool {
+		return slices.ContainsFunc(client.ContactPoints, func(contact ContactPointConfiguration) bool {
+			return contact.Type == contactPointTelegram
+		})
+	})
+	if telegram && c.Forwarders.Telegram.BotToken == "" {
+		errs = append(errs, errors.New("forwarders.telegram.bot_token: not specified"))
+	}
+
+	if len(c.Clients) == 0 {
+		errs = append(errs, errors.New("clients: no clients specified"))
+	}
+
+	logins := make(map[string]struct{}, len(c.Clients))
+	for i, client := range c.Clients {
+		name := client.Login
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		for _, err := range client.validate() {
+			errs = append(errs, fmt.Errorf("client %q: %w", name, err))
+		}
+
+		if _, ok := logins[client.Login]; ok && client.Login != "" {
+			errs = append(errs, fmt.Errorf("client %q: login is specified by several clients", name))
+		}
+		logins[client.Login] = struct{}{}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c ClientConfig) validate() []error {
+	var errs []error
+
+	if c.Proto != "" && !strings.EqualFold(c.Proto, protoIMAP) {
+		errs = append(errs, fmt.Errorf("proto: unknown protocol %q, expected 'imap'", c.Proto))
+	}
+
+	if c.Address == "" {
+		errs = append(errs, errors.New("address: not specified"))
+	} else if host, port, err := net.SplitHostPort(c.Address); err != nil {
+		errs = append(errs, fmt.Errorf("address: %w", err))
+	} else if host == "" || port == "" {
+		errs = append(errs, fmt.Errorf("address %q: expected host and port", c.Address))
+	}
+
+	if c.Login == "" {
+		errs = append(errs, errors.New("login: not specified"))
+	}
+	if c.MaximumAttachmentsSize < 0 {
+		errs = append(errs, errors.New("maximum_attachments_size: must not be negative"))
+	}
+
+	if len(c.ContactPoints) == 0 {
+		errs = append(errs, errors.New("contact_points: no contact points specified"))
+	}
+
+	for i, contact := range c.ContactPoints {
+		for _, err := range contact.validate() {
+			errs = append(errs, fmt.Errorf("contact point %s: %w", contact.DisplayName(i), err))
+		}
+	}
+
+	return errs
+}
+
+func (c ContactPointConfiguration) validate() []error {
+	var errs []error
+
+	if c.Type != contactPointTelegram {
+		errs = append(errs, fmt.Errorf("type: unknown contact point type %q, expected 'telegram'", c.Type))
+	} else if c.TGChatID == 0 {
+		errs = append(errs, errors.New("tg_chat_id: not specified"))
+	}
+
+	if c.ParseMode != nil && *c.ParseMode != "" && !slices.Contains(parseModes, *c.ParseMode) {
+		errs = append(errs, fmt.Errorf("parse_mode: unknown mode %q, expected one of 'HTML', 'MarkdownV2', 'Markdown'", *c.ParseMode))
+	}
+
+	if c.Digest.Window < 0 {
+		errs = append(errs, errors.New("digest.window: must not be negative"))
+	}
+	if c.Digest.MaxMessages < 0 {
+		errs = append(errs, errors.New("digest.max_messages: must not be negative"))
+	}
+	if c.Grouping.Window < 0 {
+		errs = append(errs, errors.New("grouping.window: must not be negative"))
+	}
+
+	return errs
+}
+
+// DisplayName returns contact point name or, if it has none, its position i
+// among client contact points and type, like "#2 (telegram)", for use in
+// errors and logs.
+func (c ContactPointConfiguration) DisplayName(i int) string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	return fmt.Sprintf("#%d (%s)", i+1, c.Type)
+}
@@ -0,0 +1,276 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hickar/chatmailer/internal/app/mailer"
+	"github.com/hickar/chatmailer/internal/pkg/kvstore"
+	"github.com/hickar/chatmailer/internal/pkg/units"
+)
+
+const ellipsis = "…"
+
+// truncate shortens string to be at most n bytes long including trailing
+// ellipsis, never splitting multibyte characters. Useful to fit
+// Telegram message size limits, which are measured in bytes.
+func truncate(n int, s string) string {
+	if len(s) <= n {
+		return s
+	}
+
+	limit := n - len(ellipsis)
+	if limit <= 0 {
+		return ""
+	}
+
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+
+	return s[:limit] + ellipsis
+}
+
+// truncateRunes shortens string to be at most n characters
+// long including trailing ellipsis.
+func truncateRunes(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+
+	runes := []rune(s)
+	return string(runes[:n-1]) + ellipsis
+}
+
+// wordwrap wraps text in lines of at most width characters, breaking lines
+// on whitespaces only. Words longer than width are kept intact.
+func wordwrap(width int, s string) string {
+	if width <= 0 {
+		return s
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s))
+
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+
+		lineLen := 0
+		for _, word := range strings.FieldsFunc(line, unicode.IsSpace) {
+			wordLen := utf8.RuneCountInString(word)
+
+			switch {
+			case lineLen == 0:
+			case lineLen+1+wordLen > width:
+				sb.WriteByte('\n')
+				lineLen = 0
+			default:
+				sb.WriteByte(' ')
+				lineLen++
+			}
+
+			sb.WriteString(word)
+			lineLen += wordLen
+		}
+	}
+
+	return sb.String()
+}
+
+// firstLine returns first non-blank line of text.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+var regexpCache = kvstore.New[string, *regexp.Regexp]()
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexpCache.Get(pattern); ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("compile regexp: %w", err)
+	}
+
+	regexpCache.Set(pattern, re)
+	return re, nil
+}
+
+// match reports whether string contains any match of regular expression.
+func match(pattern, s string) (bool, error) {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	return re.MatchString(s), nil
+}
+
+// extract returns first match of regular expression in string.
+// If expression contains capturing groups, value of the first one is returned.
+func extract(pattern, s string) (string, error) {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	m := re.FindStringSubmatch(s)
+	switch {
+	case m == nil:
+		return "", nil
+	case len(m) > 1:
+		return m[1], nil
+	}
+
+	return m[0], nil
+}
+
+// humanizeBytes formats size in bytes in human-readable form, like "2.746MB".
+func humanizeBytes(size any) (string, error) {
+	v := reflect.ValueOf(size)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return units.HumanSize(float64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return units.HumanSize(float64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return units.HumanSize(v.Float()), nil
+	}
+
+	return "", fmt.Errorf("unsupported size type %T", size)
+}
+
+var locationCache = kvstore.New[string, *time.Location]()
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locationCache.Get(name); ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("load location: %w", err)
+	}
+
+	locationCache.Set(name, loc)
+	return loc, nil
+}
+
+// inTimezone converts time to specified IANA timezone, like "Europe/Berlin".
+// Empty name stands for UTC, "Local" for daemon's local timezone.
+func inTimezone(name string, t time.Time) (time.Time, error) {
+	loc, err := loadLocation(name)
+	if err != nil {
+		return t, err
+	}
+
+	return t.In(loc), nil
+}
+
+// formatDate formats time in specified timezone using Go layout, e.g.
+//
+//	{{ .Date | formatDate "Jan 02 2006 15:04 MST" "Asia/Tokyo" }}
+func formatDate(layout, timezone string, t time.Time) (string, error) {
+	t, err := inTimezone(timezone, t)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Format(layout), nil
+}
+
+// defaultValue returns fallback value if provided one is empty, e.g.
+//
+//	{{ .Subject | default "(no subject)" }}
+func defaultValue(fallback, v any) any {
+	if isEmptyValue(v) {
+		return fallback
+	}
+
+	return v
+}
+
+func isEmptyValue(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	}
+
+	return rv.IsZero()
+}
+
+// dict creates map from key-value pairs, allowing to pass
+// multiple values into templates invoked with 'template' action.
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("odd number of arguments")
+	}
+
+	m := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("key at position %d is %T, not string", i, pairs[i])
+		}
+
+		m[key] = pairs[i+1]
+	}
+
+	return m, nil
+}
+
+func list(items ...any) []any {
+	return items
+}
+
+// header returns first value of message header by its name.
+func header(name string, message *mailer.Message) string {
+	if message == nil {
+		return ""
+	}
+
+	return message.Header.Get(name)
+}
+
+// attachmentsByType returns attachments with matching MIME type.
+// Pattern may contain wildcards, like "image/*".
+func attachmentsByType(pattern string, attachments []mailer.Attachment) []mailer.Attachment {
+	var matched []mailer.Attachment
+
+	for _, attachment := range attachments {
+		ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(attachment.MIMEType))
+		if ok {
+			matched = append(matched, attachment)
+		}
+	}
+
+	return matched
+}
@@ -0,0 +1,126 @@
+package forwarder
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplateFuncs(t *testing.T) {
+	msg := &mailer.Message{
+		BodyParts: []mailer.BodySegment{{
+			MIMEType: "text/plain",
+			Body:     strings.NewReader("\n\n  Backup job failed  \nsecond line"),
+		}},
+		Subject: "[ALERT] prod: disk usage is 97% on db-1",
+		From:    []mailer.Address{{Address: "alerts@example.com"}},
+		Date:    time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC),
+		Header: textproto.MIMEHeader{
+			"X-Priority": {"1 (Highest)"},
+		},
+		Flags:        []string{mailer.FlagSeen, mailer.FlagFlagged, "$Label1"},
+		InternalDate: time.Date(2025, time.March, 10, 22, 31, 5, 0, time.UTC),
+		Size:         1536000,
+		Attachments: []mailer.Attachment{
+			{BodySegment: mailer.BodySegment{MIMEType: "image/png", Size: 2048}, Filename: "graph.png"},
+			{BodySegment: mailer.BodySegment{MIMEType: "application/pdf", Size: 1536000}, Filename: "report.pdf"},
+			{BodySegment: mailer.BodySegment{MIMEType: "image/jpeg", Size: 512}, Filename: "photo.jpg"},
+		},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{
+			template: `{{ .Subject | truncate 16 }}`,
+			want:     "[ALERT] prod:…",
+		},
+		{
+			template: `{{ "привет мир" | truncate 10 }}|{{ "привет мир" | truncateRunes 7 }}`,
+			want:     "при…|привет…",
+		},
+		{
+			template: `{{ "the quick brown fox jumps" | wordwrap 10 }}`,
+			want:     "the quick\nbrown fox\njumps",
+		},
+		{
+			template: `{{ range .BodyParts }}{{ bytestring .Body | firstLine }}{{ end }}`,
+			want:     "Backup job failed",
+		},
+		{
+			template: `{{ if match "^\\[ALERT\\] (prod|stage)" .Subject }}{{ extract "on ([a-z0-9-]+)" .Subject }}{{ end }}`,
+			want:     "db-1",
+		},
+		{
+			template: `{{ range .Attachments }}{{ humanizeBytes .Size }} {{ end }}`,
+			want:     "2.048kB 1.536MB 512B",
+		},
+		{
+			template: `{{ .Date | formatDate "Jan 02 15:04 MST" "Asia/Tokyo" }}, {{ (inTimezone "" .Date).Hour }}`,
+			want:     "Mar 11 07:30 JST, 22",
+		},
+		{
+			template: `{{ .ReplyTo | default "none" }} {{ .Subject | default "none" | truncate 8 }}`,
+			want:     "none [ALER…",
+		},
+		{
+			template: `{{ define "pair" }}{{ .key }}={{ .value }}{{ end }}{{ template "pair" dict "key" "env" "value" "prod" }}`,
+			want:     "env=prod",
+		},
+		{
+			template: `{{ range list "a" "b" }}{{ . }}{{ end }}`,
+			want:     "ab",
+		},
+		{
+			template: `{{ header "x-priority" . }}{{ . | header "X-Missing" }}`,
+			want:     "1 (Highest)",
+		},
+		{
+			template: `{{ if .Flagged }}⭐ {{ end }}{{ if .HasFlag "$label1" }}label {{ end }}{{ humanizeBytes .Size }} at {{ .InternalDate.Format "15:04:05" }}`,
+			want:     "⭐ label 1.536MB at 22:31:05",
+		},
+		{
+			template: `{{ range attachmentsByType "image/*" .Attachments }}{{ .Filename }} {{ end }}`,
+			want:     "graph.png photo.jpg",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			tmpl, err := resolveTemplate(nil, config.ContactPointConfiguration{Template: tt.template})
+			require.NoError(t, err)
+
+			got, err := executeTemplate(tmpl, msg)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRenderTemplateFuncsErrors(t *testing.T) {
+	tests := []string{
+		`{{ match "(" .Subject }}`,
+		`{{ formatDate "15:04" "Mars/Olympus" .Date }}`,
+		`{{ dict "key" }}`,
+		`{{ humanizeBytes .Subject }}`,
+	}
+
+	for i, tmpl := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			compiled, err := resolveTemplate(nil, config.ContactPointConfiguration{Template: tmpl})
+			require.NoError(t, err)
+
+			_, err = executeTemplate(compiled, &mailer.Message{})
+			assert.Error(t, err)
+		})
+	}
+}
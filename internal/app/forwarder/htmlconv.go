@@ -0,0 +1,801 @@
+package forwarder
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// markKind enumerates Telegram formatting entities
+// produced during HTML conversion.
+type markKind int
+
+const (
+	markBold markKind = iota
+	markItalic
+	markUnderline
+	markStrike
+	markSpoiler
+	markCode
+	markPre
+	markLink
+)
+
+type mark struct {
+	kind markKind
+	href string // Link target, only for markLink.
+	lang string // Code block language, only for markPre.
+}
+
+// markupDialect describes how formatting entities and
+// plain text are represented in specific Telegram parse mode.
+type markupDialect interface {
+	escape(s string) string
+	escapeCode(s string) string
+	open(m mark) string
+	close(m mark) string
+	// separator returns characters written between already written
+	// text and markup of entity, if they would be ambiguous otherwise.
+	separator(written []byte, markup string) string
+}
+
+// markdownV2Dialect renders entities using Telegram MarkdownV2 syntax.
+// See: https://core.telegram.org/bots/api#markdownv2-style
+type markdownV2Dialect struct{}
+
+func (markdownV2Dialect) escape(s string) string {
+	return escapeMarkdown(s)
+}
+
+func (markdownV2Dialect) escapeCode(s string) string {
+	return escapeCharacters(s, markdownCodeSpecialChars)
+}
+
+func (markdownV2Dialect) open(m mark) string {
+	switch m.kind {
+	case markBold:
+		return "*"
+	case markItalic:
+		return "_"
+	case markUnderline:
+		return "__"
+	case markStrike:
+		return "~"
+	case markSpoiler:
+		return "||"
+	case markCode:
+		return "`"
+	case markPre:
+		return "```" + m.lang + "\n"
+	case markLink:
+		return "["
+	}
+
+	return ""
+}
+
+func (d markdownV2Dialect) close(m mark) string {
+	switch m.kind {
+	case markPre:
+		return "\n```"
+	case markLink:
+		return "](" + escapeCharacters(m.href, markdownLinkSpecialChars) + ")"
+	}
+
+	return d.open(m)
+}
+
+// separator splits adjacent italic and underline markup, which is otherwise
+// parsed greedily, so "___" is always treated as underline followed by italic.
+// Character with code 13 is ignored by Telegram.
+func (markdownV2Dialect) separator(written []byte, markup string) string {
+	if !strings.HasPrefix(markup, "_") || !bytes.HasSuffix(written, []byte("_")) {
+		return ""
+	}
+
+	// Underscore is escaped, if preceded by odd number of backslashes.
+	var backslashes int
+	for i := len(written) - 2; i >= 0 && written[i] == '\\'; i-- {
+		backslashes++
+	}
+	if backslashes%2 == 1 {
+		return ""
+	}
+
+	return "\r"
+}
+
+// htmlDialect renders entities using Telegram HTML syntax.
+// See: https://core.telegram.org/bots/api#html-style
+type htmlDialect struct{}
+
+func (htmlDialect) escape(s string) string {
+	return html.EscapeString(s)
+}
+
+func (htmlDialect) escapeCode(s string) string {
+	return html.EscapeString(s)
+}
+
+func (htmlDialect) open(m mark) string {
+	switch m.kind {
+	case markBold:
+		return "<b>"
+	case markItalic:
+		return "<i>"
+	case markUnderline:
+		return "<u>"
+	case markStrike:
+		return "<s>"
+	case markSpoiler:
+		return "<tg-spoiler>"
+	case markCode:
+		return "<code>"
+	case markPre:
+		if m.lang != "" {
+			return fmt.Sprintf(`<pre><code class="language-%s">`, html.EscapeString(m.lang))
+		}
+		return "<pre>"
+	case markLink:
+		return fmt.Sprintf(`<a href="%s">`, html.EscapeString(m.href))
+	}
+
+	return ""
+}
+
+func (htmlDialect) close(m mark) string {
+	switch m.kind {
+	case markBold:
+		return "</b>"
+	case markItalic:
+		return "</i>"
+	case markUnderline:
+		return "</u>"
+	case markStrike:
+		return "</s>"
+	case markSpoiler:
+		return "</tg-spoiler>"
+	case markCode:
+		return "</code>"
+	case markPre:
+		if m.lang != "" {
+			return "</code></pre>"
+		}
+		return "</pre>"
+	case markLink:
+		return "</a>"
+	}
+
+	return ""
+}
+
+func (htmlDialect) separator([]byte, string) string {
+	return ""
+}
+
+// htmlConverter walks parsed HTML document and writes its content
+// as Telegram formatted text, keeping links, emphasis, code blocks,
+// lists and simplified tables. Elements without Telegram counterpart
+// are reduced to their text content, while invisible ones
+// (scripts, styles, tracking pixels, hidden blocks) are dropped.
+type htmlConverter struct {
+	dialect markupDialect
+	buf     bytes.Buffer
+
+	pendingNewlines int
+	pendingSpace    bool
+
+	// Depth counters of currently open elements
+	// affecting text output.
+	codeDepth int
+	linkDepth int
+	cellDepth int
+	// Kinds of currently open formatting entities. Entities are not nested
+	// into ones of the same kind, as Telegram can not parse such markup.
+	openMarks map[markKind]bool
+	tables    []bool // Whether each of currently open tables is a layout one.
+	lists     []listState
+}
+
+type listState struct {
+	ordered bool
+	index   int
+}
+
+func newHTMLConverter(dialect markupDialect) *htmlConverter {
+	return &htmlConverter{dialect: dialect, openMarks: make(map[markKind]bool)}
+}
+
+// convertHTML converts HTML document into Telegram formatted
+// text using provided dialect. If document can not be parsed,
+// its plain text representation is returned instead.
+func convertHTML(r io.Reader, dialect markupDialect) string {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return ""
+	}
+
+	doc, err := xhtml.Parse(bytes.NewReader(b))
+	if err != nil {
+		return dialect.escape(htmlToText(string(b)))
+	}
+
+	c := newHTMLConverter(dialect)
+	c.walk(doc)
+
+	return strings.TrimSpace(c.buf.String())
+}
+
+func htmlToMarkdown(payload any) string {
+	return convertHTML(payloadReader(payload), markdownV2Dialect{})
+}
+
+func htmlToTelegramHTML(payload any) string {
+	return convertHTML(payloadReader(payload), htmlDialect{})
+}
+
+func payloadReader(payload any) io.Reader {
+	switch v := payload.(type) {
+	case string:
+		return strings.NewReader(v)
+	case []byte:
+		return bytes.NewReader(v)
+	case io.Reader:
+		return v
+	}
+
+	return strings.NewReader("")
+}
+
+func (c *htmlConverter) walk(n *xhtml.Node) {
+	switch n.Type {
+	case xhtml.TextNode:
+		c.text(n.Data)
+		return
+
+	case xhtml.DocumentNode:
+		c.walkChildren(n)
+		return
+
+	case xhtml.ElementNode:
+		c.element(n)
+	}
+}
+
+func (c *htmlConverter) walkChildren(n *xhtml.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+func (c *htmlConverter) element(n *xhtml.Node) {
+	if isHiddenElement(n) {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Noscript, atom.Template,
+		atom.Title, atom.Meta, atom.Link, atom.Svg, atom.Object, atom.Iframe:
+		return
+
+	case atom.Br:
+		c.lineBreak()
+
+	case atom.Hr:
+		c.block(2)
+
+	case atom.Img:
+		c.image(n)
+
+	case atom.B, atom.Strong:
+		c.inline(n, mark{kind: markBold})
+
+	case atom.I, atom.Em, atom.Cite, atom.Dfn, atom.Var:
+		c.inline(n, mark{kind: markItalic})
+
+	case atom.U, atom.Ins:
+		c.inline(n, mark{kind: markUnderline})
+
+	case atom.S, atom.Strike, atom.Del:
+		c.inline(n, mark{kind: markStrike})
+
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		if c.codeDepth > 0 {
+			c.walkChildren(n)
+			return
+		}
+
+		c.codeDepth++
+		c.inline(n, mark{kind: markCode})
+		c.codeDepth--
+
+	case atom.Pre:
+		c.pre(n)
+
+	case atom.A:
+		c.link(n)
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.block(2)
+		c.inline(n, mark{kind: markBold})
+		c.block(2)
+
+	case atom.Blockquote:
+		c.block(2)
+		c.inline(n, mark{kind: markItalic})
+		c.block(2)
+
+	case atom.Ul, atom.Ol:
+		c.list(n)
+
+	case atom.Li:
+		c.listItem(n)
+
+	case atom.Table:
+		c.table(n)
+
+	case atom.Tr:
+		c.tableRow(n)
+
+	case atom.Td, atom.Th:
+		c.tableCell(n)
+
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer,
+		atom.Main, atom.Nav, atom.Aside, atom.Address, atom.Figure, atom.Figcaption,
+		atom.Dl, atom.Dt, atom.Dd, atom.Center, atom.Form, atom.Fieldset:
+		gap := 1
+		if n.DataAtom == atom.P {
+			gap = 2
+		}
+
+		c.block(gap)
+		c.walkChildren(n)
+		c.block(gap)
+
+	default:
+		c.walkChildren(n)
+	}
+}
+
+// inline wraps children output into formatting entity.
+// Entity is omitted completely if no content was written within it,
+// as Telegram rejects empty entities.
+func (c *htmlConverter) inline(n *xhtml.Node, m mark) {
+	// Code entities can not contain any other entities.
+	if c.codeDepth > 1 || (c.codeDepth > 0 && m.kind != markCode) || c.openMarks[m.kind] {
+		c.walkChildren(n)
+		return
+	}
+
+	c.openMarks[m.kind] = true
+	defer delete(c.openMarks, m.kind)
+
+	c.flush()
+	start := c.buf.Len()
+	c.writeMarkup(c.dialect.open(m))
+	contentStart := c.buf.Len()
+
+	c.walkChildren(n)
+
+	if c.buf.Len() == contentStart {
+		c.buf.Truncate(start)
+		return
+	}
+
+	c.writeMarkup(c.dialect.close(m))
+}
+
+// writeMarkup writes markup of entity, separating
+// it from preceding one, if they are ambiguous.
+func (c *htmlConverter) writeMarkup(markup string) {
+	c.buf.WriteString(c.dialect.separator(c.buf.Bytes(), markup))
+	c.buf.WriteString(markup)
+}
+
+func (c *htmlConverter) link(n *xhtml.Node) {
+	href := attr(n, "href")
+	if c.linkDepth > 0 || c.codeDepth > 0 || !isSupportedLink(href) {
+		c.walkChildren(n)
+		return
+	}
+
+	c.linkDepth++
+	defer func() { c.linkDepth-- }()
+
+	c.flush()
+	start := c.buf.Len()
+	c.buf.WriteString(c.dialect.open(mark{kind: markLink, href: href}))
+	contentStart := c.buf.Len()
+
+	c.walkChildren(n)
+
+	// Links without visible text are represented by their target.
+	if c.buf.Len() == contentStart {
+		if strings.HasPrefix(href, "mailto:") {
+			c.buf.Truncate(start)
+			c.text(strings.TrimPrefix(href, "mailto:"))
+			return
+		}
+
+		c.buf.WriteString(c.dialect.escape(href))
+	}
+
+	c.buf.WriteString(c.dialect.close(mark{kind: markLink, href: href}))
+}
+
+func (c *htmlConverter) pre(n *xhtml.Node) {
+	var content strings.Builder
+	collectText(n, &content)
+
+	code := strings.Trim(content.String(), "\n")
+	if strings.TrimSpace(code) == "" {
+		return
+	}
+
+	c.block(2)
+	c.flush()
+
+	m := mark{kind: markPre, lang: codeLanguage(n)}
+	c.buf.WriteString(c.dialect.open(m))
+	c.buf.WriteString(c.dialect.escapeCode(code))
+	c.buf.WriteString(c.dialect.close(m))
+
+	c.block(2)
+}
+
+func (c *htmlConverter) image(n *xhtml.Node) {
+	if isTrackingPixel(n) {
+		return
+	}
+
+	if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+		c.text(alt)
+	}
+}
+
+func (c *htmlConverter) list(n *xhtml.Node) {
+	c.lists = append(c.lists, listState{ordered: n.DataAtom == atom.Ol})
+
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil && start > 0 {
+		c.lists[len(c.lists)-1].index = start - 1
+	}
+
+	if len(c.lists) == 1 {
+		c.block(2)
+	} else {
+		c.block(1)
+	}
+
+	c.walkChildren(n)
+
+	c.lists = c.lists[:len(c.lists)-1]
+	if len(c.lists) == 0 {
+		c.block(2)
+	}
+}
+
+func (c *htmlConverter) listItem(n *xhtml.Node) {
+	c.block(1)
+	c.flush()
+
+	var prefix string
+	if len(c.lists) > 0 {
+		list := &c.lists[len(c.lists)-1]
+		list.index++
+
+		prefix = strings.Repeat("  ", len(c.lists)-1)
+		if list.ordered {
+			prefix += c.dialect.escape(strconv.Itoa(list.index) + ". ")
+		} else {
+			prefix += "• "
+		}
+	} else {
+		prefix = "• "
+	}
+
+	c.buf.WriteString(prefix)
+	c.walkChildren(n)
+	c.block(1)
+}
+
+func (c *htmlConverter) table(n *xhtml.Node) {
+	// Layout tables, widely used in marketing emails, wrap
+	// other tables and whole blocks of content. Only innermost
+	// tables are treated as data and rendered row by row.
+	c.tables = append(c.tables, hasDescendant(n, atom.Table))
+
+	c.block(2)
+	c.walkChildren(n)
+	c.block(2)
+
+	c.tables = c.tables[:len(c.tables)-1]
+}
+
+func (c *htmlConverter) isLayoutTable() bool {
+	return len(c.tables) == 0 || c.tables[len(c.tables)-1]
+}
+
+func (c *htmlConverter) tableRow(n *xhtml.Node) {
+	if c.isLayoutTable() {
+		c.block(1)
+		c.walkChildren(n)
+		c.block(1)
+		return
+	}
+
+	c.block(1)
+	c.flush()
+
+	c.cellDepth++
+	defer func() { c.cellDepth-- }()
+
+	first := true
+	for cell := n.FirstChild; cell != nil; cell = cell.NextSibling {
+		if cell.Type != xhtml.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+			continue
+		}
+
+		before := c.buf.Len()
+		if !first {
+			c.pendingSpace = false
+			c.buf.WriteString(c.dialect.escape(" | "))
+		}
+
+		contentStart := c.buf.Len()
+		if cell.DataAtom == atom.Th {
+			c.inline(cell, mark{kind: markBold})
+		} else {
+			c.walkChildren(cell)
+		}
+
+		// Skip empty cells, which are mostly used for spacing.
+		if c.buf.Len() == contentStart {
+			c.buf.Truncate(before)
+			continue
+		}
+
+		c.pendingSpace = false
+		first = false
+	}
+
+	c.block(1)
+}
+
+func (c *htmlConverter) tableCell(n *xhtml.Node) {
+	c.block(1)
+	c.walkChildren(n)
+	c.block(1)
+}
+
+// block requests at least n line breaks to be written
+// before the next piece of content.
+func (c *htmlConverter) block(n int) {
+	// Data table cells are written in single line.
+	if c.cellDepth > 0 {
+		c.pendingSpace = true
+		return
+	}
+
+	c.pendingNewlines = max(c.pendingNewlines, n)
+}
+
+func (c *htmlConverter) lineBreak() {
+	if c.cellDepth > 0 {
+		c.pendingSpace = true
+		return
+	}
+
+	c.flushSpace()
+	c.pendingNewlines = 0
+	c.buf.WriteByte('\n')
+}
+
+// text outputs escaped text, collapsing whitespaces.
+func (c *htmlConverter) text(s string) {
+	escape := c.dialect.escape
+	if c.codeDepth > 0 {
+		escape = c.dialect.escapeCode
+	}
+
+	for i, word := range strings.FieldsFunc(s, unicode.IsSpace) {
+		if first, _ := utf8.DecodeRuneInString(s); i > 0 || unicode.IsSpace(first) {
+			c.pendingSpace = true
+		}
+
+		c.flush()
+		c.buf.WriteString(escape(word))
+	}
+
+	if last, _ := utf8.DecodeLastRuneInString(s); unicode.IsSpace(last) {
+		c.pendingSpace = true
+	}
+}
+
+// flush writes pending line breaks or whitespace.
+// Leading whitespaces are never written.
+func (c *htmlConverter) flush() {
+	if c.pendingNewlines > 0 {
+		if c.buf.Len() > 0 {
+			existing := trailingNewlines(c.buf.Bytes())
+			for i := existing; i < c.pendingNewlines; i++ {
+				c.buf.WriteByte('\n')
+			}
+		}
+
+		c.pendingNewlines = 0
+		c.pendingSpace = false
+		return
+	}
+
+	c.flushSpace()
+}
+
+func (c *htmlConverter) flushSpace() {
+	if !c.pendingSpace {
+		return
+	}
+
+	c.pendingSpace = false
+	if b := c.buf.Bytes(); len(b) > 0 && b[len(b)-1] != '\n' && b[len(b)-1] != ' ' {
+		c.buf.WriteByte(' ')
+	}
+}
+
+func trailingNewlines(b []byte) int {
+	var n int
+	for i := len(b) - 1; i >= 0 && b[i] == '\n'; i-- {
+		n++
+	}
+
+	return n
+}
+
+func hasDescendant(n *xhtml.Node, a atom.Atom) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xhtml.ElementNode && child.DataAtom == a {
+			return true
+		}
+
+		if hasDescendant(child, a) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func collectText(n *xhtml.Node, sb *strings.Builder) {
+	switch {
+	case n.Type == xhtml.TextNode:
+		sb.WriteString(n.Data)
+	case n.Type == xhtml.ElementNode && n.DataAtom == atom.Br:
+		sb.WriteByte('\n')
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		collectText(child, sb)
+	}
+}
+
+func codeLanguage(n *xhtml.Node) string {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != xhtml.ElementNode || child.DataAtom != atom.Code {
+			continue
+		}
+
+		for _, class := range strings.Fields(attr(child, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok && isPlainWord(lang) {
+				return lang
+			}
+		}
+	}
+
+	return ""
+}
+
+func isPlainWord(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '+' && r != '-' {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+func isSupportedLink(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto", "tg":
+		return true
+	}
+
+	return false
+}
+
+// isTrackingPixel reports whether image is most likely used
+// only for tracking email opening: either it is sized as
+// single pixel or it is not displayed at all.
+func isTrackingPixel(n *xhtml.Node) bool {
+	width, height := attr(n, "width"), attr(n, "height")
+	if isPixelSize(width) || isPixelSize(height) {
+		return true
+	}
+
+	style := styleProperties(attr(n, "style"))
+	return isPixelSize(style["width"]) || isPixelSize(style["height"])
+}
+
+func isPixelSize(v string) bool {
+	v = strings.TrimSuffix(strings.TrimSpace(v), "px")
+	return v == "0" || v == "1"
+}
+
+func isHiddenElement(n *xhtml.Node) bool {
+	if _, ok := attrValue(n, "hidden"); ok {
+		return true
+	}
+
+	style := styleProperties(attr(n, "style"))
+	return style["display"] == "none" ||
+		style["visibility"] == "hidden" ||
+		strings.TrimSuffix(style["max-height"], "px") == "0" && style["overflow"] == "hidden"
+}
+
+// styleProperties returns lower-cased values of inline style declarations
+// by their property names, like "width" for "min-width: 0; width: 600px",
+// without "!important" annotations.
+func styleProperties(style string) map[string]string {
+	properties := make(map[string]string)
+
+	for _, declaration := range strings.Split(style, ";") {
+		property, value, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+
+		value = strings.TrimSpace(strings.ToLower(value))
+		value = strings.TrimSpace(strings.TrimSuffix(value, "!important"))
+		properties[strings.ToLower(strings.TrimSpace(property))] = value
+	}
+
+	return properties
+}
+
+func attr(n *xhtml.Node, key string) string {
+	v, _ := attrValue(n, key)
+	return v
+}
+
+func attrValue(n *xhtml.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+
+	return "", false
+}
+
+var markdownCodeSpecialChars = map[rune]struct{}{
+	'`':  {},
+	'\\': {},
+}
+
+var markdownLinkSpecialChars = map[rune]struct{}{
+	')':  {},
+	'\\': {},
+}
@@ -0,0 +1,96 @@
+package forwarder
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			input: "plain text.",
+			want:  `plain text\.`,
+		},
+		{
+			input: "multiple<br/>line<br/>text",
+			want:  "multiple\nline\ntext",
+		},
+		{
+			input: "<p>  first   paragraph </p><p>second <b>bold</b> and <i>italic</i></p>",
+			want:  "first paragraph\n\nsecond *bold* and _italic_",
+		},
+		{
+			input: `<a href="https://example.com/path_(1)">Example site</a>`,
+			want:  `[Example site](https://example.com/path_(1\))`,
+		},
+		{
+			input: `<a href="javascript:alert(1)">click</a> <a href="https://example.com"></a>`,
+			want:  `click [https://example\.com](https://example.com)`,
+		},
+		{
+			input: "<code>a_b`c</code> and <pre><code class=\"language-go\">x := `y`\n</code></pre>",
+			want:  "`a_b\\`c` and\n\n```go\nx := \\`y\\`\n```",
+		},
+		{
+			input: "<ul><li>one</li><li>two<ol><li>nested</li></ol></li></ul>",
+			want:  "• one\n• two\n  1\\. nested",
+		},
+		{
+			input: "<table><tr><th>Host</th><th>Status</th></tr><tr><td>db-1</td><td></td><td>DOWN</td></tr></table>",
+			want:  "*Host* \\| *Status*\ndb\\-1 \\| DOWN",
+		},
+		{
+			input: `<table><tr><td><p>Layout</p><table><tr><td>a</td><td>b</td></tr></table></td></tr></table>`,
+			want:  "Layout\n\na \\| b",
+		},
+		{
+			input: `<head><title>T</title><style>p{}</style></head><script>x()</script>` +
+				`<img src="https://t.example.com/p.gif" width="1" height="1">` +
+				`<div style="display: none">preheader</div><img alt="Logo" src="logo.png"><b></b> text`,
+			want: "Logo text",
+		},
+		{
+			input: `<img alt="Hero" style="min-width:0;width:600px"><img alt="Pixel" style="border: 0; WIDTH: 1px !important">` +
+				`<p style="max-height: 0px; overflow: hidden">preheader</p><p style="min-height:0">shown</p>`,
+			want: "Hero\n\nshown",
+		},
+		{
+			// Entities are not nested into ones of the same kind.
+			input: "<b>a <strong>b</strong> c</b>",
+			want:  "*a b c*",
+		},
+		{
+			input: "<i><u>x</u></i> <u><i>y</i></u>",
+			want:  "_\r__x__\r_ __\r_y_\r__",
+		},
+		{
+			input: "<blockquote><em>quoted</em> text</blockquote>",
+			want:  "_quoted text_",
+		},
+		{
+			// Escaped underscore is not separated from markup.
+			input: `<i>a_</i>`,
+			want:  `_a\__`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			assert.Equal(t, tt.want, htmlToMarkdown(tt.input))
+		})
+	}
+}
+
+func TestHTMLToTelegramHTML(t *testing.T) {
+	input := `<h1>Alert &amp; report</h1><p>See <a href="https://example.com/?a=1&amp;b=2"><b>details</b></a></p>` +
+		`<pre>if a < b {}</pre><marquee>unsupported</marquee>`
+	want := "<b>Alert &amp; report</b>\n\nSee <a href=\"https://example.com/?a=1&amp;b=2\"><b>details</b></a>\n\n" +
+		"<pre>if a &lt; b {}</pre>\n\nunsupported"
+
+	assert.Equal(t, want, htmlToTelegramHTML(input))
+}
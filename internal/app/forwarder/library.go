@@ -0,0 +1,244 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/pkg/kvstore"
+)
+
+const templateFileExt = ".tmpl"
+
+// TemplateError describes failure of parsing or rendering specific template.
+type TemplateError struct {
+	Name   string
+	Line   int // Line of template source where error occurred, if known.
+	Column int // Column (in bytes) of template source where error occurred, if known.
+	Err    error
+
+	reason string
+}
+
+// templateErrorLocation matches location prefix of text/template errors,
+// for example "template: name:3:14: executing ...".
+var templateErrorLocation = regexp.MustCompile(`^template: (?:.*?):(\d+):(?:(\d+):)? (.*)$`)
+
+func newTemplateError(name string, err error) *TemplateError {
+	tmplErr := &TemplateError{Name: name, Err: err, reason: err.Error()}
+
+	if m := templateErrorLocation.FindStringSubmatch(err.Error()); m != nil {
+		tmplErr.Line, _ = strconv.Atoi(m[1])
+		tmplErr.Column, _ = strconv.Atoi(m[2])
+		tmplErr.reason = m[3]
+	}
+
+	return tmplErr
+}
+
+func (e *TemplateError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("template %q: line %d, col %d: %s", e.Name, e.Line, e.Column, e.reason)
+	case e.Line > 0:
+		return fmt.Sprintf("template %q: line %d: %s", e.Name, e.Line, e.reason)
+	case e.reason != "":
+		return fmt.Sprintf("template %q: %s", e.Name, e.reason)
+	}
+
+	return fmt.Sprintf("template %q: %v", e.Name, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateLibrary is a set of named templates shared between contact points.
+//
+// All templates are parsed within single template set together with the default
+// template, so every one of them is able to reuse blocks declared by others
+// with 'define' and 'template' actions, including default "addresses",
+// "html-body" and "text-body" blocks.
+type TemplateLibrary struct {
+	set   *template.Template
+	names []string
+	// Compiled inline templates by their content.
+	inline *kvstore.KVStore[string, *template.Template]
+}
+
+// NewTemplateLibrary parses named templates specified in configuration
+// and ones stored in '.tmpl' files of configured directory, named after
+// files without extension.
+//
+// Templates are parsed independently, so returned error
+// joins [TemplateError] for every invalid template.
+func NewTemplateLibrary(cfg config.TemplatesConfiguration) (*TemplateLibrary, error) {
+	sources := maps.Clone(cfg.Definitions)
+	if sources == nil {
+		sources = make(map[string]string)
+	}
+
+	if cfg.Directory != "" {
+		if err := readTemplateDir(cfg.Directory, sources); err != nil {
+			return nil, fmt.Errorf("read templates directory: %w", err)
+		}
+	}
+
+	set := template.Must(defaultTemplate.Clone())
+	lib := &TemplateLibrary{
+		set:    set,
+		inline: kvstore.New[string, *template.Template](),
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(sources)) {
+		if _, err := set.New(name).Parse(sources[name]); err != nil {
+			errs = append(errs, newTemplateError(name, err))
+			continue
+		}
+
+		lib.names = append(lib.names, name)
+	}
+
+	// References to undefined templates are reported by
+	// standard engine only during execution, which is too late.
+	for _, name := range lib.names {
+		for _, ref := range templateRefs(set.Lookup(name).Tree) {
+			if set.Lookup(ref) == nil {
+				errs = append(errs, &TemplateError{
+					Name: name,
+					Err:  fmt.Errorf("no such template %q", ref),
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return lib, nil
+}
+
+// Lookup returns named template.
+func (l *TemplateLibrary) Lookup(name string) (*template.Template, bool) {
+	if l == nil {
+		return nil, false
+	}
+
+	tmpl := l.set.Lookup(name)
+	return tmpl, tmpl != nil
+}
+
+// Names returns names of templates specified by user.
+func (l *TemplateLibrary) Names() []string {
+	if l == nil {
+		return nil
+	}
+
+	return l.names
+}
+
+// compile parses inline template associating it with the library,
+// so it is able to invoke named templates. Compiled templates are
+// cached by content, hence every template is parsed only once.
+func (l *TemplateLibrary) compile(content string) (*template.Template, error) {
+	if l != nil {
+		if tmpl, ok := l.inline.Get(content); ok {
+			return tmpl, nil
+		}
+	}
+
+	base := defaultTemplate
+	if l != nil {
+		base = l.set
+	}
+
+	set, err := base.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("clone templates: %w", err)
+	}
+
+	name := templateHash(content)
+	tmpl, err := set.New(name).Parse(content)
+	if err != nil {
+		return nil, newTemplateError(name, err)
+	}
+
+	if l != nil {
+		l.inline.Set(content, tmpl)
+	}
+
+	return tmpl, nil
+}
+
+func readTemplateDir(dir string, sources map[string]string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != templateFileExt {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), templateFileExt)
+		if _, ok := sources[name]; ok {
+			return fmt.Errorf("template %q is defined both in configuration and in %q file", name, entry.Name())
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("read file: %w", err)
+		}
+
+		sources[name] = string(b)
+	}
+
+	return nil
+}
+
+// templateRefs returns names of templates invoked by provided template.
+func templateRefs(tree *parse.Tree) []string {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+
+	var refs []string
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.TemplateNode:
+			refs = append(refs, n.Name)
+		case *parse.IfNode:
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+	walk(tree.Root)
+
+	return refs
+}
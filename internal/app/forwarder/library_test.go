@@ -0,0 +1,158 @@
+package forwarder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateLibrary(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "header.tmpl"),
+		[]byte(`{{ define "subject" }}*{{ escapeMarkdown .Subject }}*{{ end }}`),
+		0o600,
+	))
+
+	lib, err := NewTemplateLibrary(config.TemplatesConfiguration{
+		Directory: dir,
+		Definitions: map[string]string{
+			"short": `{{ template "subject" . }} from {{ template "addresses" .From }}`,
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"header", "short"}, lib.Names())
+
+	msg := &mailer.Message{
+		Subject: "Disk usage 95%",
+		From:    []mailer.Address{{Address: "alerts@example.com"}},
+	}
+
+	tmpl, err := resolveTemplate(lib, config.ContactPointConfiguration{TemplateName: "short"})
+	require.NoError(t, err)
+
+	got, err := executeTemplate(tmpl, msg)
+	require.NoError(t, err)
+	assert.Equal(t, `*Disk usage 95%* from [alerts@example\.com](mailto://alerts@example.com)`, got)
+
+	// Inline templates are able to use library templates as well.
+	tmpl, err = resolveTemplate(lib, config.ContactPointConfiguration{Template: `Alert: {{ template "subject" . }}`})
+	require.NoError(t, err)
+
+	got, err = executeTemplate(tmpl, msg)
+	require.NoError(t, err)
+	assert.Equal(t, `Alert: *Disk usage 95%*`, got)
+
+	_, err = resolveTemplate(lib, config.ContactPointConfiguration{TemplateName: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestTemplateLibraryErrors(t *testing.T) {
+	_, err := NewTemplateLibrary(config.TemplatesConfiguration{
+		Definitions: map[string]string{
+			"valid":     `{{ .Subject }}`,
+			"unclosed":  `{{ if .Subject }}`,
+			"undefined": `{{ template "missing" . }}`,
+		},
+	})
+	require.Error(t, err)
+
+	var names []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var tmplErr *TemplateError
+		require.True(t, errors.As(e, &tmplErr))
+		names = append(names, tmplErr.Name)
+	}
+	assert.Equal(t, []string{"unclosed", "undefined"}, names)
+}
+
+func TestTemplateLibraryValidate(t *testing.T) {
+	lib, err := NewTemplateLibrary(config.TemplatesConfiguration{
+		Definitions: map[string]string{
+			"broken": "{{ .Subject }}\n{{ .Unknown }}",
+		},
+	})
+	require.NoError(t, err)
+
+	err = lib.Validate([]config.ClientConfig{{
+		Login: "user@example.com",
+		ContactPoints: []config.ContactPointConfiguration{
+			{Template: "{{ .Subject }}"},
+			{Template: "{{ .Subject }} {{ .Mailbox.Missing }}"},
+			{TemplateName: "missing"},
+		},
+	}})
+	require.Error(t, err)
+
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	require.Len(t, errs, 3)
+
+	var tmplErr *TemplateError
+	require.True(t, errors.As(errs[0], &tmplErr))
+	assert.Equal(t, "broken", tmplErr.Name)
+	assert.Equal(t, 2, tmplErr.Line)
+	assert.Equal(t, 3, tmplErr.Column)
+
+	require.True(t, errors.As(errs[1], &tmplErr))
+	assert.Equal(t, templateHash("{{ .Subject }} {{ .Mailbox.Missing }}"), tmplErr.Name)
+	assert.Equal(t, 1, tmplErr.Line)
+	assert.Contains(t, errs[1].Error(), `client "user@example.com" contact point #1`)
+
+	assert.Contains(t, errs[2].Error(), `template "missing" is not defined`)
+}
+
+func TestTemplateLibraryValidateDigest(t *testing.T) {
+	lib, err := NewTemplateLibrary(config.TemplatesConfiguration{
+		Definitions: map[string]string{
+			"compact-digest": "{{ len .Messages }} new messages",
+			"broken-digest":  "{{ .Subject }}",
+			// Unreferenced templates may be either message or digest ones.
+			"unused-digest": "{{ range .Senders }}{{ .Address }}{{ end }}",
+		},
+	})
+	require.NoError(t, err)
+
+	err = lib.Validate([]config.ClientConfig{{
+		Login: "user@example.com",
+		ContactPoints: []config.ContactPointConfiguration{
+			{Digest: config.DigestConfiguration{TemplateName: "compact-digest"}},
+			{Digest: config.DigestConfiguration{TemplateName: "broken-digest"}},
+			{Digest: config.DigestConfiguration{Template: "{{ range .Messages }}{{ .Subject }}{{ end }}"}},
+			{Digest: config.DigestConfiguration{Template: "{{ .From }}"}},
+		},
+	}})
+	require.Error(t, err)
+
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	require.Len(t, errs, 2)
+
+	var tmplErr *TemplateError
+	require.True(t, errors.As(errs[0], &tmplErr))
+	assert.Equal(t, "broken-digest", tmplErr.Name)
+
+	require.True(t, errors.As(errs[1], &tmplErr))
+	assert.Equal(t, templateHash("{{ .From }}"), tmplErr.Name)
+	assert.Contains(t, errs[1].Error(), `client "user@example.com" contact point #3 digest`)
+}
+
+func TestInlineTemplateCache(t *testing.T) {
+	lib, err := NewTemplateLibrary(config.TemplatesConfiguration{})
+	require.NoError(t, err)
+
+	cfg := config.ContactPointConfiguration{Template: "{{ .Subject }}"}
+
+	first, err := resolveTemplate(lib, cfg)
+	require.NoError(t, err)
+
+	second, err := resolveTemplate(lib, cfg)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Regexp(t, `^inline-[0-9a-f]{16}$`, first.Name())
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/hickar/chatmailer/internal/app/config"
 	"github.com/hickar/chatmailer/internal/app/mailer"
@@ -16,31 +19,43 @@ const (
 	tgMsgTextSizeLimit     = 4096
 	tgAPIURLTemplate       = "https://api.telegram.org/bot%s/%s"
 	tgAPISendMessageMethod = "sendMessage"
+	tgAPIEditMessageMethod = "editMessageText"
 
+	tgParseModeNone       = ""
 	tgParseModeHTML       = "HTML"
 	tgParseModeMarkdownV2 = "MarkdownV2"
 	tgParseModeMarkdown   = "Markdown"
 )
 
 type telegramForwarder struct {
-	client *http.Client
-	cfg    config.TelegramConfiguration
-	logger *slog.Logger
+	client    *http.Client
+	cfg       config.TelegramConfiguration
+	templates *TemplateLibrary
+	logger    *slog.Logger
 }
 
-func NewTelegramForwarder(client *http.Client, cfg config.TelegramConfiguration, logger *slog.Logger) *telegramForwarder {
+func NewTelegramForwarder(
+	client *http.Client,
+	cfg config.TelegramConfiguration,
+	templates *TemplateLibrary,
+	logger *slog.Logger,
+) *telegramForwarder {
 	return &telegramForwarder{
-		client: client,
-		cfg:    cfg,
-		logger: logger,
+		client:    client,
+		cfg:       cfg,
+		templates: templates,
+		logger:    logger,
 	}
 }
 
 func (tf *telegramForwarder) Forward(ctx context.Context, cfg config.ContactPointConfiguration, messages []*mailer.Message) error {
-	messages[0].BodyParts = messages[0].BodyParts[1:]
+	tmpl, err := resolveTemplate(tf.templates, cfg)
+	if err != nil {
+		return fmt.Errorf("resolve message template: %w", err)
+	}
 
 	for _, message := range messages {
-		content, err := renderTemplate(message, cfg.Template)
+		content, err := executeTemplate(tmpl, message)
 		if err != nil {
 			return fmt.Errorf("render message template: %w", err)
 		}
@@ -53,32 +68,169 @@ func (tf *telegramForwarder) Forward(ctx context.Context, cfg config.ContactPoin
 	return nil
 }
 
-func (tf *telegramForwarder) sendMessage(ctx context.Context, cfg config.ContactPointConfiguration, body *bytes.Buffer) error {
-	parseMode := tgParseModeMarkdownV2
+// ForwardDigest sends digest of buffered messages as single notification.
+func (tf *telegramForwarder) ForwardDigest(ctx context.Context, cfg config.ContactPointConfiguration, digest mailer.Digest) error {
+	tmpl, err := resolveDigestTemplate(tf.templates, cfg)
+	if err != nil {
+		return fmt.Errorf("resolve digest template: %w", err)
+	}
+
+	content, err := executeTemplate(tmpl, digest)
+	if err != nil {
+		return fmt.Errorf("render digest template: %w", err)
+	}
+
+	if err = tf.sendMessage(ctx, cfg, bytes.NewBufferString(content)); err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+
+	return nil
+}
+
+// ForwardGroup sends notification of the first message of group or, once it is
+// sent, edits it with counter of grouped messages. Notification is sent again,
+// if it can not be edited, for example after being deleted from chat.
+func (tf *telegramForwarder) ForwardGroup(
+	ctx context.Context,
+	cfg config.ContactPointConfiguration,
+	group mailer.Group,
+) (mailer.Notification, error) {
+	notification := group.Notification
+	if notification.ID == 0 {
+		tmpl, err := resolveTemplate(tf.templates, cfg)
+		if err != nil {
+			return notification, fmt.Errorf("resolve message template: %w", err)
+		}
+
+		if notification.Content, err = executeTemplate(tmpl, group.Message); err != nil {
+			return notification, fmt.Errorf("render message template: %w", err)
+		}
+	}
+
+	text, mode := groupText(notification.Content, group, parseMode(cfg))
+	cfg.ParseMode = &mode
+
+	if notification.ID != 0 {
+		err := tf.editText(ctx, cfg, notification.ID, text)
+		if err == nil {
+			return notification, nil
+		}
+
+		tf.logger.WarnContext(ctx, "failed to edit group notification, sending new one",
+			slog.Int64("message_id", notification.ID),
+			slog.Any("error", err),
+		)
+	}
+
+	id, err := tf.sendText(ctx, cfg, text)
+	if err != nil {
+		return notification, fmt.Errorf("send message: %w", err)
+	}
+	notification.ID = id
+
+	return notification, nil
+}
+
+// groupText returns notification content followed by counter of grouped
+// messages, like "×12, last at 10:42", if there are several of them, along
+// with parse mode to send it with. Content is truncated to fit into single
+// message along with counter. As it is already rendered, it is cut at line
+// break only, so entities of parse mode, like "<b>" or "*", are not left
+// unclosed. Content without line break to be cut at is sent as plain text.
+func groupText(content string, group mailer.Group, parseMode string) (string, string) {
+	counter := groupCounter(group, parseMode)
+
+	if limit := tgMsgTextSizeLimit - len(counter); len(content) > limit {
+		cut := strings.LastIndexByte(content[:limit+1], '\n')
+		if cut <= 0 {
+			counter = groupCounter(group, tgParseModeNone)
+			limit = tgMsgTextSizeLimit - len(counter)
+
+			return strings.ToValidUTF8(content[:limit], "") + counter, tgParseModeNone
+		}
+
+		content = strings.TrimRight(content[:cut], "\n")
+	}
+
+	return content + counter, parseMode
+}
+
+// groupCounter returns counter of grouped messages formatted in
+// parse mode, if there are several of them, or empty string otherwise.
+func groupCounter(group mailer.Group, parseMode string) string {
+	if group.Count <= 1 {
+		return ""
+	}
+
+	counter := fmt.Sprintf("×%d, last at %s", group.Count, group.Last.Format("15:04"))
+	switch parseMode {
+	case tgParseModeHTML:
+		counter = "<i>" + counter + "</i>"
+	case tgParseModeMarkdownV2, tgParseModeMarkdown:
+		counter = "_" + counter + "_"
+	}
+
+	return "\n\n" + counter
+}
+
+func parseMode(cfg config.ContactPointConfiguration) string {
 	if cfg.ParseMode != nil {
-		parseMode = *cfg.ParseMode
+		return *cfg.ParseMode
 	}
 
+	return tgParseModeMarkdownV2
+}
+
+func (tf *telegramForwarder) sendMessage(ctx context.Context, cfg config.ContactPointConfiguration, body *bytes.Buffer) error {
 	// Due to Telegram's limit on message text size,
 	// we proceed to split and send message in 4096-byte sized chunks.
 	for body.Len() > 0 {
 		payload := body.Next(tgMsgTextSizeLimit)
-		req := tgSendMsgRequest{
-			ChatID:              cfg.TGChatID,
-			ParseMode:           parseMode,
-			Text:                string(payload),
-			DisableNotification: cfg.SilentMode,
-			ProtectContent:      cfg.DisableForwarding,
-		}
-		if err := tf.makeRequest(ctx, tgAPISendMessageMethod, req); err != nil {
-			return fmt.Errorf("make request: %w", err)
+		if _, err := tf.sendText(ctx, cfg, string(payload)); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (tf *telegramForwarder) makeRequest(ctx context.Context, method string, payload any) error {
+// sendText sends text fitting into single message, returning its identifier.
+func (tf *telegramForwarder) sendText(ctx context.Context, cfg config.ContactPointConfiguration, text string) (int64, error) {
+	req := tgSendMsgRequest{
+		ChatID:              cfg.TGChatID,
+		ParseMode:           parseMode(cfg),
+		Text:                text,
+		DisableNotification: cfg.SilentMode,
+		ProtectContent:      cfg.DisableForwarding,
+	}
+
+	var sent tgMessage
+	if err := tf.makeRequest(ctx, tgAPISendMessageMethod, req, &sent); err != nil {
+		return 0, fmt.Errorf("make request: %w", err)
+	}
+
+	return sent.MessageID, nil
+}
+
+// editText replaces text of previously sent message.
+func (tf *telegramForwarder) editText(ctx context.Context, cfg config.ContactPointConfiguration, id int64, text string) error {
+	req := tgEditMsgRequest{
+		ChatID:    cfg.TGChatID,
+		MessageID: id,
+		ParseMode: parseMode(cfg),
+		Text:      text,
+	}
+
+	if err := tf.makeRequest(ctx, tgAPIEditMessageMethod, req, nil); err != nil {
+		return fmt.Errorf("make request: %w", err)
+	}
+
+	return nil
+}
+
+// makeRequest calls Telegram Bot API method, decoding its result
+// into provided value, unless it is nil.
+func (tf *telegramForwarder) makeRequest(ctx context.Context, method string, payload any, result any) error {
 	b, err := json.Marshal(&payload)
 	if err != nil {
 		return fmt.Errorf("encode json: %w", err)
@@ -87,19 +239,26 @@ func (tf *telegramForwarder) makeRequest(ctx context.Context, method string, pay
 	req, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
-		fmt.Sprintf(tgAPIURLTemplate, tf.cfg.BotToken, method),
+		fmt.Sprintf(tgAPIURLTemplate, string(tf.cfg.BotToken), method),
 		bytes.NewReader(b),
 	)
 	if err != nil {
-		return fmt.Errorf("build request: %w", err)
+		return fmt.Errorf("build request: %s", tf.cfg.BotToken.Redact(err.Error()))
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
+	// Errors contain request URL, which includes bot token.
 	resp, err := tf.client.Do(req)
 	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = tf.cfg.BotToken.Redact(urlErr.URL)
+		}
+
 		return fmt.Errorf("request: %w", err)
 	}
+	defer resp.Body.Close()
 
 	var respData tgResponse
 	if err = json.NewDecoder(resp.Body).Decode(&respData); err != nil {
@@ -110,6 +269,12 @@ func (tf *telegramForwarder) makeRequest(ctx context.Context, method string, pay
 		return fmt.Errorf("request failed with error_code '%d' and following description '%s'", respData.Code, respData.Description)
 	}
 
+	if result != nil {
+		if err = json.Unmarshal(respData.Result, result); err != nil {
+			return fmt.Errorf("decode result: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -122,6 +287,17 @@ type tgSendMsgRequest struct {
 	ReplyMarkup         tgInlineMarkup `json:"reply_markup,omitempty"`
 }
 
+type tgEditMsgRequest struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int64  `json:"message_id"`
+	ParseMode string `json:"parse_mode"`
+	Text      string `json:"text"`
+}
+
+type tgMessage struct {
+	MessageID int64 `json:"message_id"`
+}
+
 type tgInlineMarkup struct {
 	Keyboard [][]tgInlineButton `json:"inline_button"`
 }
@@ -138,7 +314,8 @@ type tgWebAppInfo struct {
 }
 
 type tgResponse struct {
-	Ok          bool   `json:"ok"`
-	Description string `json:"description"`
-	Code        int    `json:"error_code"`
+	Ok          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Code        int             `json:"error_code"`
+	Result      json.RawMessage `json:"result"`
 }
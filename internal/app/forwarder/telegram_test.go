@@ -0,0 +1,176 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTelegram serves Bot API requests, recording them
+// as method names followed by request text.
+type fakeTelegram struct {
+	requests []string
+	// Error description returned for editing messages, if any.
+	editError string
+}
+
+func (f *fakeTelegram) RoundTrip(req *http.Request) (*http.Response, error) {
+	var payload struct {
+		MessageID int64  `json:"message_id"`
+		Text      string `json:"text"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	method := path.Base(req.URL.Path)
+	f.requests = append(f.requests, method+": "+payload.Text)
+
+	response := `{"ok": true, "result": {"message_id": 42}}`
+	if method == tgAPIEditMessageMethod && f.editError != "" {
+		response = `{"ok": false, "error_code": 400, "description": "` + f.editError + `"}`
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+	}, nil
+}
+
+func TestTelegramForward(t *testing.T) {
+	telegram := &fakeTelegram{}
+	tf := NewTelegramForwarder(
+		&http.Client{Transport: telegram},
+		config.TelegramConfiguration{},
+		nil,
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	contact := config.ContactPointConfiguration{Template: "{{ .Subject }}{{ range .BodyParts }}: {{ bytestring .Body }}{{ end }}"}
+	messages := []*mailer.Message{
+		// Attachment only message has no body parts.
+		{Subject: "Report", Attachments: []mailer.Attachment{{Filename: "report.pdf"}}},
+		{Subject: "Disk is full", BodyParts: []mailer.BodySegment{{MIMEType: "text/plain", Body: strings.NewReader("100%")}}},
+	}
+
+	require.NoError(t, tf.Forward(context.Background(), contact, messages))
+	assert.Equal(t, []string{"sendMessage: Report", "sendMessage: Disk is full: 100%"}, telegram.requests)
+
+	// Messages are left intact, as they may be forwarded to several contact points.
+	assert.Len(t, messages[1].BodyParts, 1)
+}
+
+func TestTelegramForwardGroup(t *testing.T) {
+	telegram := &fakeTelegram{}
+	tf := NewTelegramForwarder(
+		&http.Client{Transport: telegram},
+		config.TelegramConfiguration{},
+		nil,
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	contact := config.ContactPointConfiguration{Template: "{{ .Subject }}"}
+	last := time.Date(2025, time.March, 10, 10, 42, 0, 0, time.UTC)
+	group := mailer.Group{Message: &mailer.Message{Subject: "Disk is full"}, Count: 1, Start: last, Last: last}
+
+	notification, err := tf.ForwardGroup(context.Background(), contact, group)
+	require.NoError(t, err)
+	assert.Equal(t, mailer.Notification{ID: 42, Content: "Disk is full"}, notification)
+
+	group.Message = &mailer.Message{}
+	group.Notification = notification
+	group.Count = 12
+
+	notification, err = tf.ForwardGroup(context.Background(), contact, group)
+	require.NoError(t, err)
+	assert.Equal(t, mailer.Notification{ID: 42, Content: "Disk is full"}, notification)
+
+	// Notification is sent again, if it can not be edited.
+	telegram.editError = "Bad Request: message to edit not found"
+	_, err = tf.ForwardGroup(context.Background(), contact, group)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"sendMessage: Disk is full",
+		"editMessageText: Disk is full\n\n_×12, last at 10:42_",
+		"editMessageText: Disk is full\n\n_×12, last at 10:42_",
+		"sendMessage: Disk is full\n\n_×12, last at 10:42_",
+	}, telegram.requests)
+
+	// Notification of single long line is not sent empty.
+	telegram.requests = nil
+	group = mailer.Group{Message: &mailer.Message{Subject: strings.Repeat("x", 2*tgMsgTextSizeLimit)}, Count: 1, Start: last, Last: last}
+	_, err = tf.ForwardGroup(context.Background(), contact, group)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sendMessage: " + strings.Repeat("x", tgMsgTextSizeLimit)}, telegram.requests)
+}
+
+func TestGroupText(t *testing.T) {
+	last := time.Date(2025, time.March, 10, 10, 42, 0, 0, time.UTC)
+
+	text, mode := groupText("Disk is full", mailer.Group{Count: 3, Last: last}, tgParseModeHTML)
+	assert.Equal(t, "Disk is full\n\n<i>×3, last at 10:42</i>", text)
+	assert.Equal(t, tgParseModeHTML, mode)
+
+	// Content exceeding message size limit is cut at line break, not inside entity.
+	line := "*Disk is full* on `db-1`\n"
+	content := strings.Repeat(line, tgMsgTextSizeLimit/len(line)+1)
+	require.Greater(t, len(content), tgMsgTextSizeLimit)
+
+	text, mode = groupText(content, mailer.Group{Count: 3, Last: last}, tgParseModeMarkdownV2)
+	assert.Equal(t, tgParseModeMarkdownV2, mode)
+	counter := "\n\n_×3, last at 10:42_"
+	assert.LessOrEqual(t, len(text), tgMsgTextSizeLimit)
+	kept, ok := strings.CutSuffix(text, counter)
+	require.True(t, ok)
+	assert.True(t, strings.HasPrefix(content, kept+"\n"))
+	assert.Greater(t, len(kept), tgMsgTextSizeLimit-len(counter)-len(line))
+
+	// Content without line breaks can not be cut inside entity, so it is sent as plain text.
+	line = "<b>" + strings.Repeat("ё", tgMsgTextSizeLimit) + "</b>"
+	for _, count := range []int{1, 3} {
+		text, mode = groupText(line, mailer.Group{Count: count, Last: last}, tgParseModeHTML)
+		assert.Equal(t, tgParseModeNone, mode)
+		assert.LessOrEqual(t, len(text), tgMsgTextSizeLimit)
+		assert.Greater(t, len(text), tgMsgTextSizeLimit-len("\n\n×3, last at 10:42")-utf8.UTFMax)
+		assert.True(t, strings.HasPrefix(text, "<b>ёё"))
+		assert.True(t, utf8.ValidString(text))
+	}
+	assert.True(t, strings.HasSuffix(text, "ё\n\n×3, last at 10:42"))
+}
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTelegramRequestErrorRedacted(t *testing.T) {
+	tf := NewTelegramForwarder(
+		&http.Client{Transport: failingTransport{}},
+		config.TelegramConfiguration{BotToken: "123:token"},
+		nil,
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	_, err := tf.sendText(context.Background(), config.ContactPointConfiguration{}, "text")
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "123:token")
+	assert.Contains(t, err.Error(), "/bot[REDACTED]/sendMessage")
+}
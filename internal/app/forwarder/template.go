@@ -9,6 +9,7 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/hickar/chatmailer/internal/app/config"
 	"github.com/hickar/chatmailer/internal/app/mailer"
 
 	"jaytaylor.com/html2text"
@@ -28,13 +29,29 @@ const defaultTemplateContent = `
 {{- end -}}
 
 {{ define "html-body" }}{{ range $part := . }}{{ if eq $part.MIMEType "text/html" }}
-{{ htmlstring $part.Body | escapeMarkdown | quoteMarkdown }}
+{{ htmlmarkdown $part.Body | quoteMarkdown }}
 {{ end }}{{ end }}{{ end }}
 
 {{ define "text-body" }}{{ range $part := . }}{{ if eq $part.MIMEType "text/plain" }}
 {{ htmlstring $part.Body | escapeMarkdown | quoteMarkdown }}
 {{ end }}{{ end }}{{ end }}
 
+{{ define "forwarded" -}}
+*Forwarded message*
+{{ if .From }}*From*: {{ template "addresses" .From }}
+{{ end }}
+{{- if .Subject }}*Subject*: {{ escapeMarkdown .Subject }}
+{{ end }}
+{{- if not .Date.IsZero }}*Date*: {{ .Date.Format "Jan 02 2006 15:04:05" }}
+{{ end }}
+{{- if containsMIMEType .BodyParts "text/html" }}{{ template "html-body" .BodyParts }}
+{{- else }}{{ template "text-body" .BodyParts }}{{ end }}
+{{- range .Embedded }}
+{{ template "forwarded" . }}{{ end }}
+{{- end }}
+
+{{- if .SenderUnverified }}⚠️ *Unverified sender*
+{{ end }}
 {{- if .From }}*From*: {{ template "addresses" .From }}
 {{ end }}
 {{- if .To }}*To*: {{ template "addresses" .To }}
@@ -45,7 +62,7 @@ const defaultTemplateContent = `
 {{ end }}
 {{- if .BCC }}*BCC*: {{ template "addresses" .BCC }}
 {{ end }}
-{{- if .Subject }}*Subject*: {{ escapeMarkdown .Subject }}
+{{- if .Subject }}*Subject*: {{ if .Flagged }}⭐ {{ end }}{{ escapeMarkdown .Subject }}
 {{ end }}
 {{- if .Date }}*Date*: {{ .Date.Format "Jan 02 2006 15:04:05" }}
 {{ end }}
@@ -56,8 +73,17 @@ const defaultTemplateContent = `
 
 {{ if and $hasParts $hasHTMLParts }}{{ template "html-body" .BodyParts }}
 {{ else if and $hasParts $hasTextParts }}{{ template "text-body" .BodyParts }}
-{{ else -}}TEXT MESSAGE CAN NOT BE REPRESENTED
-{{ end -}}`
+{{ else if not .Embedded -}}TEXT MESSAGE CAN NOT BE REPRESENTED
+{{ end -}}
+{{- range .Embedded }}{{ template "forwarded" . }}{{ end -}}`
+
+// defaultDigestTemplateContent is template of digest notification, which
+// lists subjects and senders of buffered messages.
+const defaultDigestTemplateContent = `*Digest*: {{ len .Messages }} {{ if eq (len .Messages) 1 }}message{{ else }}messages{{ end }}
+{{ with .Senders }}*From*: {{ template "addresses" . }}
+{{ end }}{{ range .Messages }}
+• {{ if .SenderUnverified }}⚠️ {{ end }}{{ if .Flagged }}⭐ {{ end }}{{ escapeMarkdown (default "(no subject)" .Subject) }}
+{{- end }}`
 
 var (
 	defaultTemplateFuncs = template.FuncMap{
@@ -73,38 +99,95 @@ var (
 		"trimSpace":        strings.TrimSpace,
 		"bytestring":       bytesToString,
 		"htmlstring":       htmlToText,
+		"htmlmarkdown":     htmlToMarkdown,
+		"htmltelegram":     htmlToTelegramHTML,
 		"containsMIMEType": containsMIMEType,
 		"quoteMarkdown":    quoteMarkdown,
+
+		"truncate":          truncate,
+		"truncateRunes":     truncateRunes,
+		"wordwrap":          wordwrap,
+		"firstLine":         firstLine,
+		"match":             match,
+		"extract":           extract,
+		"humanizeBytes":     humanizeBytes,
+		"inTimezone":        inTimezone,
+		"formatDate":        formatDate,
+		"default":           defaultValue,
+		"dict":              dict,
+		"list":              list,
+		"header":            header,
+		"attachmentsByType": attachmentsByType,
 	}
-	defaultTemplateName = "default"
-	defaultTemplate     = template.Must(
+	defaultTemplateName       = "default"
+	defaultDigestTemplateName = "digest"
+	defaultTemplate           = parseDefaultTemplates()
+)
+
+// parseDefaultTemplates returns default template
+// associated with default digest template.
+func parseDefaultTemplates() *template.Template {
+	tmpl := template.Must(
 		template.
 			New(defaultTemplateName).
 			Funcs(defaultTemplateFuncs).
 			Parse(defaultTemplateContent),
 	)
-)
+	template.Must(tmpl.New(defaultDigestTemplateName).Parse(defaultDigestTemplateContent))
 
-func renderTemplate(message *mailer.Message, templateContent string) (string, error) {
-	var buf bytes.Buffer
+	return tmpl
+}
+
+// resolveTemplate returns template configured for contact point:
+// either named one from templates library, inline one or default template.
+//
+// Inline templates are associated with templates library,
+// so they are able to invoke named templates as well.
+func resolveTemplate(lib *TemplateLibrary, cfg config.ContactPointConfiguration) (*template.Template, error) {
+	return lookupTemplate(lib, cfg.TemplateName, cfg.Template, defaultTemplateName)
+}
 
+// resolveDigestTemplate returns digest template configured for contact
+// point the same way as resolveTemplate does, default digest template
+// is used if none is configured.
+func resolveDigestTemplate(lib *TemplateLibrary, cfg config.ContactPointConfiguration) (*template.Template, error) {
+	return lookupTemplate(lib, cfg.Digest.TemplateName, cfg.Digest.Template, defaultDigestTemplateName)
+}
+
+// lookupTemplate returns named template from library or inline one,
+// falling back to default template, which may be overridden in library.
+func lookupTemplate(lib *TemplateLibrary, name, content, defaultName string) (*template.Template, error) {
 	switch {
-	case templateContent == "":
-		if err := defaultTemplate.Execute(&buf, message); err != nil {
-			return "", fmt.Errorf("default template rendering: %w", err)
+	case name != "":
+		tmpl, ok := lib.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("template %q is not defined", name)
 		}
-	default:
-		tmpl, err := template.
-			New(templateHash(templateContent)).
-			Funcs(defaultTemplateFuncs).
-			Parse(templateContent)
+
+		return tmpl, nil
+
+	case content != "":
+		tmpl, err := lib.compile(content)
 		if err != nil {
-			return "", fmt.Errorf("custom template parsing: %w", err)
+			return nil, fmt.Errorf("custom template parsing: %w", err)
 		}
 
-		if err = tmpl.Execute(&buf, message); err != nil {
-			return "", fmt.Errorf("custom template rendering: %w", err)
-		}
+		return tmpl, nil
+	}
+
+	if tmpl, ok := lib.Lookup(defaultName); ok {
+		return tmpl, nil
+	}
+
+	return defaultTemplate.Lookup(defaultName), nil
+}
+
+// executeTemplate renders template with either message or digest data.
+func executeTemplate(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("template %q rendering: %w", tmpl.Name(), err)
 	}
 
 	// I don't know who the fuck designed standard Go template engine syntax,
@@ -112,8 +195,12 @@ func renderTemplate(message *mailer.Message, templateContent string) (string, er
 	return strings.TrimSpace(buf.String()), nil
 }
 
+// templateHash returns name for inline template derived from its content.
 func templateHash(s string) string {
-	return string(fnv.New32().Sum([]byte(s)))
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+
+	return fmt.Sprintf("inline-%016x", h.Sum64())
 }
 
 func escapeMarkdown(s string) string {
@@ -204,23 +291,24 @@ func escapeCharacters(s string, charMap map[rune]struct{}) string {
 }
 
 var markdownSpecialChars = map[rune]struct{}{
-	'_': {},
-	'*': {},
-	'[': {},
-	']': {},
-	'(': {},
-	')': {},
-	'~': {},
-	'`': {},
-	'>': {},
-	'#': {},
-	'+': {},
-	'-': {},
-	'=': {},
-	'|': {},
-	'{': {},
-	'}': {},
-	'.': {},
-	'!': {},
-	'"': {},
+	'\\': {},
+	'_':  {},
+	'*':  {},
+	'[':  {},
+	']':  {},
+	'(':  {},
+	')':  {},
+	'~':  {},
+	'`':  {},
+	'>':  {},
+	'#':  {},
+	'+':  {},
+	'-':  {},
+	'=':  {},
+	'|':  {},
+	'{':  {},
+	'}':  {},
+	'.':  {},
+	'!':  {},
+	'"':  {},
 }
@@ -5,9 +5,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hickar/chatmailer/internal/app/config"
 	"github.com/hickar/chatmailer/internal/app/mailer"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRenderDefaultTemplate(t *testing.T) {
@@ -68,7 +70,124 @@ func TestRenderDefaultTemplate(t *testing.T) {
 
 >third part`
 
-	got, err := renderTemplate(msg, "")
+	got, err := executeTemplate(defaultTemplate, msg)
 	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
+
+func TestRenderDefaultTemplateForwarded(t *testing.T) {
+	msg := &mailer.Message{
+		BodyParts: []mailer.BodySegment{{
+			MIMEType: "text/plain",
+			Body:     strings.NewReader("See below"),
+		}},
+		Subject: "Fwd: Disk usage",
+		From:    []mailer.Address{{Address: "oncall@example.com"}},
+		Date:    time.Date(2025, time.March, 10, 22, 45, 0, 0, time.UTC),
+		Embedded: []*mailer.Message{{
+			BodyParts: []mailer.BodySegment{{
+				MIMEType: "text/html",
+				Body:     strings.NewReader("<p>Usage is 97%</p>"),
+			}},
+			Subject: "Disk usage",
+			From:    []mailer.Address{{Address: "alerts@example.com"}},
+			Date:    time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC),
+			Embedded: []*mailer.Message{{
+				BodyParts: []mailer.BodySegment{{
+					MIMEType: "text/plain",
+					Body:     strings.NewReader("Original alert"),
+				}},
+				Subject: "Disk alert",
+			}},
+		}},
+	}
+
+	want := `*From*: [oncall@example\.com](mailto://oncall@example.com)
+*Subject*: Fwd: Disk usage
+*Date*: Mar 10 2025 22:45:00
+
+>See below
+
+*Forwarded message*
+*From*: [alerts@example\.com](mailto://alerts@example.com)
+*Subject*: Disk usage
+*Date*: Mar 10 2025 22:30:00
+
+>Usage is 97%
+
+*Forwarded message*
+*Subject*: Disk alert
+
+>Original alert`
+
+	got, err := executeTemplate(defaultTemplate, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestRenderDefaultTemplateUnverifiedSender(t *testing.T) {
+	msg := &mailer.Message{
+		BodyParts: []mailer.BodySegment{{
+			MIMEType: "text/plain",
+			Body:     strings.NewReader("Disk is full"),
+		}},
+		Subject: "Disk usage",
+		From:    []mailer.Address{{Address: "alerts@example.com"}},
+		Date:    time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC),
+		Authentication: mailer.Authentication{
+			DKIM:  mailer.AuthFail,
+			SPF:   mailer.AuthPass,
+			DMARC: mailer.AuthFail,
+		},
+	}
+
+	want := `⚠️ *Unverified sender*
+*From*: [alerts@example\.com](mailto://alerts@example.com)
+*Subject*: Disk usage
+*Date*: Mar 10 2025 22:30:00
+
+>Disk is full`
+
+	got, err := executeTemplate(defaultTemplate, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	msg.Authentication.DMARC = mailer.AuthPass
+	_ = msg.Rewind()
+
+	got, err = executeTemplate(defaultTemplate, msg)
+	assert.NoError(t, err)
+	assert.NotContains(t, got, "Unverified sender")
+}
+
+func TestRenderDefaultDigestTemplate(t *testing.T) {
+	digest := mailer.Digest{Messages: []*mailer.Message{
+		{
+			Subject: "Disk usage 95%",
+			From:    []mailer.Address{{Address: "alerts@example.com"}},
+		},
+		{
+			Subject: "DB is down",
+			From:    []mailer.Address{{Address: "db@example.com"}},
+			Flags:   []string{mailer.FlagFlagged},
+		},
+		{
+			From:           []mailer.Address{{Address: "alerts@example.com"}},
+			Authentication: mailer.Authentication{DMARC: mailer.AuthFail},
+		},
+	}}
+
+	want := `*Digest*: 3 messages
+*From*: [alerts@example\.com](mailto://alerts@example.com), [db@example\.com](mailto://db@example.com)
+
+• Disk usage 95%
+• ⭐ DB is down
+• ⚠️ \(no subject\)`
+
+	tmpl, err := resolveDigestTemplate(nil, config.ContactPointConfiguration{})
+	require.NoError(t, err)
+
+	got, err := executeTemplate(tmpl, digest)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,190 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+)
+
+// Validate checks all library templates and templates configured for
+// contact points of provided clients by rendering them against synthetic
+// message, or synthetic digest for digest templates, so invalid templates
+// are reported on startup rather than on the first received email.
+//
+// Returned error joins errors of every invalid template.
+func (l *TemplateLibrary) Validate(clients []config.ClientConfig) error {
+	var errs []error
+
+	// Library templates are rendered with data of contact points referencing
+	// them, unreferenced ones have to be valid for either message or digest.
+	messageNames := map[string]struct{}{defaultTemplateName: {}}
+	digestNames := map[string]struct{}{defaultDigestTemplateName: {}}
+	for _, client := range clients {
+		for _, contact := range client.ContactPoints {
+			if contact.TemplateName != "" {
+				messageNames[contact.TemplateName] = struct{}{}
+			}
+			if contact.Digest.TemplateName != "" {
+				digestNames[contact.Digest.TemplateName] = struct{}{}
+			}
+		}
+	}
+
+	for _, name := range l.Names() {
+		tmpl, _ := l.Lookup(name)
+
+		_, isMessage := messageNames[name]
+		_, isDigest := digestNames[name]
+
+		var err error
+		switch {
+		case isMessage && isDigest:
+			if err = validateTemplate(tmpl, sampleMessage()); err == nil {
+				err = validateTemplate(tmpl, sampleDigest())
+			}
+		case isMessage:
+			err = validateTemplate(tmpl, sampleMessage())
+		case isDigest:
+			err = validateTemplate(tmpl, sampleDigest())
+		default:
+			if err = validateTemplate(tmpl, sampleMessage()); err != nil && validateTemplate(tmpl, sampleDigest()) == nil {
+				err = nil
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, newTemplateError(name, err))
+		}
+	}
+
+	for _, client := range clients {
+		for i, contact := range client.ContactPoints {
+			tmpl, err := resolveTemplate(l, contact)
+
+			// Library templates are already validated above.
+			if err == nil && contact.TemplateName == "" && contact.Template != "" {
+				if err = validateTemplate(tmpl, sampleMessage()); err != nil {
+					err = newTemplateError(tmpl.Name(), err)
+				}
+			}
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("client %q contact point #%d: %w", client.Login, i, err))
+			}
+
+			tmpl, err = resolveDigestTemplate(l, contact)
+			if err == nil && contact.Digest.TemplateName == "" && contact.Digest.Template != "" {
+				if err = validateTemplate(tmpl, sampleDigest()); err != nil {
+					err = newTemplateError(tmpl.Name(), err)
+				}
+			}
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("client %q contact point #%d digest: %w", client.Login, i, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateTemplate(tmpl *template.Template, data any) error {
+	return tmpl.Execute(io.Discard, data)
+}
+
+// sampleDigest returns digest of sample messages,
+// used as digest template data during validation.
+func sampleDigest() mailer.Digest {
+	message := sampleMessage()
+	message.BodyParts = nil
+	message.Embedded = nil
+
+	return mailer.Digest{
+		Messages: []*mailer.Message{message, {Subject: "Another subject"}},
+		Start:    message.Date,
+	}
+}
+
+// sampleMessage returns message with every field populated,
+// used as template data during validation.
+func sampleMessage() *mailer.Message {
+	date := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	return &mailer.Message{
+		BodyParts: []mailer.BodySegment{
+			{
+				MIMEType:       "text/plain",
+				MIMETypeParams: map[string]string{"charset": "utf-8"},
+				Body:           strings.NewReader("Sample message body"),
+				Size:           19,
+			},
+			{
+				MIMEType:       "text/html",
+				MIMETypeParams: map[string]string{"charset": "utf-8"},
+				Body:           strings.NewReader("<p>Sample <b>message</b> body</p>"),
+				Size:           33,
+			},
+		},
+		Subject: "Sample subject",
+		From:    []mailer.Address{{Name: "Sender", Address: "sender@example.com"}},
+		To:      []mailer.Address{{Name: "Recipient", Address: "recipient@example.com"}},
+		CC:      []mailer.Address{{Address: "cc@example.com"}},
+		BCC:     []mailer.Address{{Address: "bcc@example.com"}},
+		ReplyTo: []mailer.Address{{Address: "reply@example.com"}},
+		Date:    date,
+		Mailbox: "INBOX",
+		UID:     1,
+		Header: textproto.MIMEHeader{
+			"Subject":    {"Sample subject"},
+			"Message-Id": {"<sample@example.com>"},
+		},
+		MessageID:             "sample@example.com",
+		InReplyTo:             []string{"parent@example.com"},
+		References:            []string{"root@example.com", "parent@example.com"},
+		ListID:                "list.example.com",
+		Priority:              mailer.PriorityHigh,
+		AuthenticationResults: []string{"mx.example.com; dkim=pass header.d=example.com"},
+		Authentication: mailer.Authentication{
+			DKIM:        mailer.AuthPass,
+			DKIMDomains: []string{"example.com"},
+		},
+		Flags:        []string{mailer.FlagSeen, mailer.FlagFlagged},
+		InternalDate: date,
+		Size:         2048,
+		ModSeq:       1,
+		Attachments: []mailer.Attachment{{
+			BodySegment: mailer.BodySegment{
+				MIMEType: "application/pdf",
+				Body:     strings.NewReader(""),
+			},
+			Filename:         "report.pdf",
+			CreationDate:     date,
+			ModificationDate: date,
+			ReadDate:         date,
+		}},
+		Embedded: []*mailer.Message{{
+			BodyParts: []mailer.BodySegment{{
+				MIMEType: "text/plain",
+				Body:     strings.NewReader("Forwarded message body"),
+				Size:     22,
+			}},
+			Subject: "Forwarded subject",
+			From:    []mailer.Address{{Address: "origin@example.com"}},
+			Date:    date,
+		}},
+		OmittedAttachments: []mailer.Attachment{{
+			BodySegment: mailer.BodySegment{
+				MIMEType: "application/zip",
+				Size:     100 << 20,
+			},
+			Filename: "archive.zip",
+		}},
+	}
+}
@@ -0,0 +1,171 @@
+package mailer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+)
+
+// dedupe returns messages not delivered to contact point destination
+// within dedupe window yet, either by the same or another client.
+// Messages repeated within messages themselves are delivered once as well.
+func (r *TaskRunner) dedupe(
+	ctx context.Context,
+	contact config.ContactPointConfiguration,
+	messages []*Message,
+	keys map[*Message]string,
+) ([]*Message, error) {
+	if r.cfg.Dedupe.Window <= 0 {
+		return messages, nil
+	}
+
+	// Messages repeated within messages are added to delivered ones.
+	delivered := r.delivered(destination(contact))
+
+	var unique []*Message
+	for _, message := range messages {
+		key, err := dedupeKey(message, keys)
+		if err != nil {
+			return nil, fmt.Errorf("get dedupe key of message %d: %w", message.UID, err)
+		}
+
+		if _, ok := delivered[key]; ok {
+			r.logger.InfoContext(ctx, "duplicate message skipped",
+				slog.Any("uid", message.UID),
+				slog.String("subject", message.Subject),
+				slog.String("destination", destination(contact)),
+			)
+			continue
+		}
+
+		delivered[key] = struct{}{}
+		unique = append(unique, message)
+	}
+
+	return unique, nil
+}
+
+// markDelivered remembers messages as delivered to contact point
+// destination in state of client, which retrieved them.
+func (r *TaskRunner) markDelivered(
+	login string,
+	contact config.ContactPointConfiguration,
+	messages []*Message,
+	keys map[*Message]string,
+) {
+	if r.cfg.Dedupe.Window <= 0 {
+		return
+	}
+
+	client, _ := r.clientStore.Get(login)
+	if client.Deliveries == nil {
+		client.Deliveries = make(map[string]map[string]time.Time)
+	}
+
+	dest := destination(contact)
+	if client.Deliveries[dest] == nil {
+		client.Deliveries[dest] = make(map[string]time.Time)
+	}
+
+	now := r.now()
+	for _, message := range messages {
+		client.Deliveries[dest][keys[message]] = now
+	}
+
+	r.clientStore.Set(login, client)
+}
+
+// delivered returns dedupe keys of messages delivered to destination within
+// dedupe window by any client, as contact points of different clients
+// sending to the same chat share deliveries.
+func (r *TaskRunner) delivered(dest string) map[string]struct{} {
+	delivered := make(map[string]struct{})
+
+	expired := r.now().Add(-r.cfg.Dedupe.Window)
+	for _, client := range r.cfg.Clients {
+		stored, _ := r.clientStore.Get(client.Login)
+		for key, deliveredAt := range stored.Deliveries[dest] {
+			if deliveredAt.After(expired) {
+				delivered[key] = struct{}{}
+			}
+		}
+	}
+
+	return delivered
+}
+
+// recentDeliveries returns deliveries of client not older than dedupe window
+// to destinations of its contact points. Others are not needed for dedupe,
+// so state of client is kept bounded.
+func (r *TaskRunner) recentDeliveries(client config.ClientConfig) map[string]map[string]time.Time {
+	if r.cfg.Dedupe.Window <= 0 {
+		return nil
+	}
+
+	recent := make(map[string]map[string]time.Time)
+
+	expired := r.now().Add(-r.cfg.Dedupe.Window)
+	for _, contact := range client.ContactPoints {
+		dest := destination(contact)
+		for key, deliveredAt := range client.Deliveries[dest] {
+			if !deliveredAt.After(expired) {
+				continue
+			}
+
+			if recent[dest] == nil {
+				recent[dest] = make(map[string]time.Time)
+			}
+			recent[dest][key] = deliveredAt
+		}
+	}
+
+	return recent
+}
+
+// destination identifies chat contact point sends messages to, so contact
+// points of different clients sending to the same chat share deliveries.
+func destination(contact config.ContactPointConfiguration) string {
+	return fmt.Sprintf("telegram:%d", contact.TGChatID)
+}
+
+// dedupeKey returns key identifying message regardless of mailbox it was
+// retrieved from: its 'Message-ID' or, if it is missing, hash of sender,
+// subject, date and body text. Keys are cached, as hashing consumes body.
+func dedupeKey(message *Message, keys map[*Message]string) (string, error) {
+	if key, ok := keys[message]; ok {
+		return key, nil
+	}
+
+	if message.MessageID != "" {
+		keys[message] = "id:" + message.MessageID
+		return keys[message], nil
+	}
+
+	h := sha256.New()
+	for _, from := range message.From {
+		fmt.Fprintf(h, "%s\x00", from.Address)
+	}
+	fmt.Fprintf(h, "%s\x00%s\x00", message.Subject, message.Date.UTC().Format(time.RFC3339))
+
+	// Body may be already read by filters.
+	if err := message.Rewind(); err != nil {
+		return "", fmt.Errorf("rewind message: %w", err)
+	}
+	for _, part := range message.BodyParts {
+		if part.Body == nil {
+			continue
+		}
+		if _, err := io.Copy(h, part.Body); err != nil {
+			return "", fmt.Errorf("read body part: %w", err)
+		}
+	}
+
+	keys[message] = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	return keys[message], nil
+}
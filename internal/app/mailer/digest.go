@@ -0,0 +1,200 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+)
+
+// Digest is summary of messages buffered for contact point in digest mode.
+// Content of messages is not retained, only their header fields and metadata.
+type Digest struct {
+	Messages []*Message
+	// Time the first message was buffered at.
+	Start time.Time
+}
+
+// Senders returns unique addresses of messages senders in order of appearance.
+func (d Digest) Senders() []Address {
+	var senders []Address
+
+	seen := make(map[string]struct{})
+	for _, message := range d.Messages {
+		for _, from := range message.From {
+			key := strings.ToLower(from.Address)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+
+			seen[key] = struct{}{}
+			senders = append(senders, from)
+		}
+	}
+
+	return senders
+}
+
+// summary returns copy of message without content of its parts,
+// so it can be retained after message is closed.
+func (m *Message) summary() *Message {
+	summary := *m
+	summary.BodyParts = nil
+	summary.Embedded = nil
+
+	summary.Attachments = make([]Attachment, len(m.Attachments))
+	for i, attachment := range m.Attachments {
+		attachment.Body = nil
+		summary.Attachments[i] = attachment
+	}
+
+	return &summary
+}
+
+func digestEnabled(contact config.ContactPointConfiguration) bool {
+	return contact.Digest.Window > 0 || contact.Digest.MaxMessages > 0
+}
+
+// contactPointKey identifies client contact point
+// in its digest, delayed messages and groups stores.
+func contactPointKey(client config.ClientConfig, i int) string {
+	return fmt.Sprintf("%s#%d", client.Login, i)
+}
+
+// digest sends messages matching any of contact point urgent filters
+// immediately and buffers others, sending digest once it is full.
+func (r *TaskRunner) digest(
+	ctx context.Context,
+	client config.ClientConfig,
+	i int,
+	contact config.ContactPointConfiguration,
+	messages []*Message,
+) error {
+	var urgent []*Message
+
+	digest, _ := r.digests.Get(contactPointKey(client, i))
+	for _, message := range messages {
+		ok, err := r.matchAnyFilter(contact.Digest.UrgentFilters, message)
+		if err != nil {
+			return fmt.Errorf("match message %d against urgent filters of contact point %s: %w",
+				message.UID, contact.DisplayName(i), err)
+		}
+		if ok {
+			urgent = append(urgent, message)
+			continue
+		}
+
+		if len(digest.Messages) == 0 {
+			digest.Start = r.now()
+		}
+		digest.Messages = append(digest.Messages, message.summary())
+	}
+	r.digests.Set(contactPointKey(client, i), digest)
+
+	if buffered := len(messages) - len(urgent); buffered > 0 {
+		r.logger.InfoContext(ctx, "messages buffered for digest",
+			slog.String("contact_point", contact.DisplayName(i)),
+			slog.Int("count", buffered),
+			slog.Int("total", len(digest.Messages)),
+		)
+	}
+
+	if len(urgent) > 0 {
+		if err := r.deliver(ctx, client, i, contact, urgent); err != nil {
+			return err
+		}
+	}
+
+	if r.digestDue(contact, digest) {
+		return r.sendDigest(ctx, client, i, contact)
+	}
+
+	return nil
+}
+
+// digestDue reports whether digest is full or its messages
+// are buffered for longer than digest window.
+func (r *TaskRunner) digestDue(contact config.ContactPointConfiguration, digest Digest) bool {
+	if len(digest.Messages) == 0 {
+		return false
+	}
+
+	full := contact.Digest.MaxMessages > 0 && len(digest.Messages) >= contact.Digest.MaxMessages
+	expired := contact.Digest.Window > 0 && r.now().Sub(digest.Start) >= contact.Digest.Window
+
+	return full || expired
+}
+
+// flushDigests sends due digests of client contact points, including
+// ones which sending was delayed until quiet hours end.
+func (r *TaskRunner) flushDigests(ctx context.Context, client config.ClientConfig) error {
+	for i, contact := range client.ContactPoints {
+		if !digestEnabled(contact) {
+			continue
+		}
+
+		digest, ok := r.digests.Get(contactPointKey(client, i))
+		if !ok || !r.digestDue(contact, digest) {
+			continue
+		}
+
+		if err := r.sendDigest(ctx, client, i, contact); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendDigest forwards buffered messages digest to contact point, discarding
+// it afterwards. During contact point quiet hours digest is sent silently,
+// kept buffered until they end or discarded without sending.
+func (r *TaskRunner) sendDigest(ctx context.Context, client config.ClientConfig, i int, contact config.ContactPointConfiguration) error {
+	digest, _ := r.digests.Get(contactPointKey(client, i))
+
+	action, err := r.quietHours(i, contact)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case quietActionSilent:
+		contact.SilentMode = true
+	case quietActionDelay:
+		return nil
+	case quietActionDrop:
+		r.digests.Remove(contactPointKey(client, i))
+		r.logger.InfoContext(ctx, "digest dropped during quiet hours",
+			slog.String("contact_point", contact.DisplayName(i)),
+			slog.Int("count", len(digest.Messages)),
+		)
+		return nil
+	}
+
+	if err := r.forwarder.ForwardDigest(ctx, contact, digest); err != nil {
+		return fmt.Errorf("forward digest: %w", err)
+	}
+
+	r.digests.Remove(contactPointKey(client, i))
+	r.logger.InfoContext(ctx, "digest sent",
+		slog.String("contact_point", contact.DisplayName(i)),
+		slog.Int("count", len(digest.Messages)),
+	)
+
+	return nil
+}
+
+// matchAnyFilter reports whether message satisfies any of filters.
+func (r *TaskRunner) matchAnyFilter(filters []string, message *Message) (bool, error) {
+	for _, expr := range filters {
+		ok, err := r.filter.Match(expr, message)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
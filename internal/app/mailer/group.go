@@ -0,0 +1,190 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+)
+
+// Group is series of messages with the same normalized subject sent
+// to contact point as single notification, which is edited with
+// counter of them as further messages arrive.
+type Group struct {
+	// The first message of group. Its content is available only
+	// until notification is sent, like content of digest messages.
+	Message *Message
+	// Number of grouped messages.
+	Count int
+	// Time the first and the last messages were grouped at.
+	Start time.Time
+	Last  time.Time
+	// Notification sent for group, zero until the first message is forwarded.
+	Notification Notification
+}
+
+// Notification is chat message sent by forwarder, which may be edited later.
+type Notification struct {
+	// Identifier of chat message.
+	ID int64
+	// Rendered content of the first message of group,
+	// retained to be edited along with counter.
+	Content string
+}
+
+var (
+	// subjectPrefixRe matches reply and forward prefixes, like "Re: Fwd[2]: ".
+	subjectPrefixRe = regexp.MustCompile(`^(?:(?:re|fwd?|aw|wg|tr)(?:\[\d+\])?\s*:\s*)+`)
+	// timestampRe matches dates and times, like "2025-03-10T10:42:00Z", "10:42" or "10/03/2025".
+	timestampRe = regexp.MustCompile(`\d{4}-\d{2}-\d{2}(?:[t ]\d{1,2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:z|[+-]\d{2}:?\d{2})?)?` +
+		`|\d{1,2}:\d{2}(?::\d{2}(?:\.\d+)?)?|\d{1,2}[./]\d{1,2}[./]\d{2,4}`)
+	numberRe = regexp.MustCompile(`\d+`)
+)
+
+// groupKey returns subject normalized for grouping: lower-cased,
+// without reply and forward prefixes, timestamps and numbers, so
+// "Re: Disk usage 95% on db-1 at 10:42" and "disk usage 97% on db-2"
+// share the same key. Key is empty, if nothing but numbers and
+// punctuation is left, as such messages are not related anyhow.
+func groupKey(subject string) string {
+	key := strings.ToLower(strings.TrimSpace(subject))
+	key = subjectPrefixRe.ReplaceAllString(key, "")
+	key = timestampRe.ReplaceAllString(key, "")
+	key = numberRe.ReplaceAllString(key, "")
+
+	if !strings.ContainsFunc(key, unicode.IsLetter) {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(key), " ")
+}
+
+func groupingEnabled(contact config.ContactPointConfiguration) bool {
+	return contact.Grouping.Window > 0
+}
+
+// notify sends messages to contact point, grouping them if enabled.
+func (r *TaskRunner) notify(
+	ctx context.Context,
+	client config.ClientConfig,
+	i int,
+	contact config.ContactPointConfiguration,
+	messages []*Message,
+) error {
+	if !groupingEnabled(contact) {
+		return r.send(ctx, contact, messages)
+	}
+
+	return r.group(ctx, client, i, contact, messages)
+}
+
+// group sends the first message of every new group as notification
+// and edits notifications of open groups with updated counter.
+// Messages with empty group key are sent as usual, without grouping.
+func (r *TaskRunner) group(
+	ctx context.Context,
+	client config.ClientConfig,
+	i int,
+	contact config.ContactPointConfiguration,
+	messages []*Message,
+) error {
+	var (
+		keys      []string
+		ungrouped []*Message
+	)
+
+	batches := make(map[string][]*Message)
+	for _, message := range messages {
+		key := groupKey(message.Subject)
+		if key == "" {
+			ungrouped = append(ungrouped, message)
+			continue
+		}
+		if _, ok := batches[key]; !ok {
+			keys = append(keys, key)
+		}
+		batches[key] = append(batches[key], message)
+	}
+
+	if len(ungrouped) > 0 {
+		if err := r.send(ctx, contact, ungrouped); err != nil {
+			return err
+		}
+	}
+
+	groups := r.openGroups(client, i, contact)
+	now := r.now()
+
+	for _, key := range keys {
+		batch := batches[key]
+
+		group, ok := groups[key]
+		if !ok {
+			if err := batch[0].Rewind(); err != nil {
+				return fmt.Errorf("rewind message: %w", err)
+			}
+
+			group = Group{Message: batch[0], Count: 1, Start: now, Last: now}
+			notification, err := r.forwarder.ForwardGroup(ctx, contact, group)
+			if err != nil {
+				return fmt.Errorf("forward group: %w", err)
+			}
+
+			group.Message = batch[0].summary()
+			group.Notification = notification
+			batch = batch[1:]
+
+			// Group is stored right away, so it is not sent again if its update fails.
+			groups[key] = group
+			r.groups.Set(contactPointKey(client, i), groups)
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		group.Count += len(batch)
+		group.Last = now
+
+		notification, err := r.forwarder.ForwardGroup(ctx, contact, group)
+		if err != nil {
+			return fmt.Errorf("forward group: %w", err)
+		}
+
+		group.Notification = notification
+		groups[key] = group
+		r.groups.Set(contactPointKey(client, i), groups)
+
+		r.logger.InfoContext(ctx, "messages grouped",
+			slog.String("contact_point", contact.DisplayName(i)),
+			slog.String("subject", group.Message.Subject),
+			slog.Int("count", group.Count),
+		)
+	}
+
+	return nil
+}
+
+// openGroups returns copy of contact point groups by their keys,
+// which last message was grouped within grouping window.
+func (r *TaskRunner) openGroups(client config.ClientConfig, i int, contact config.ContactPointConfiguration) map[string]Group {
+	stored, _ := r.groups.Get(contactPointKey(client, i))
+
+	groups := maps.Clone(stored)
+	if groups == nil {
+		groups = make(map[string]Group)
+	}
+
+	expired := r.now().Add(-contact.Grouping.Window)
+	maps.DeleteFunc(groups, func(_ string, group Group) bool {
+		return !group.Last.After(expired)
+	})
+
+	return groups
+}
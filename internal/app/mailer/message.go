@@ -1,7 +1,10 @@
 package mailer
 
 import (
+	"errors"
 	"io"
+	"net/textproto"
+	"strings"
 	"time"
 )
 
@@ -17,6 +20,193 @@ type Message struct {
 	Mailbox     string
 	UID         uint32
 	Attachments []Attachment
+	// All message header fields by their canonical names.
+	Header textproto.MIMEHeader
+	// Message identifier without angle brackets, taken from 'Message-ID' header.
+	MessageID string
+	// Identifiers of messages current one is reply to ('In-Reply-To' header).
+	InReplyTo []string
+	// Identifiers of messages in the thread ('References' header).
+	References []string
+	// Mailing list identifier without angle brackets ('List-Id' header).
+	ListID string
+	// Message priority, derived from 'X-Priority', 'Priority' and 'Importance' headers.
+	Priority Priority
+	// Raw 'Authentication-Results' header values, added by receiving servers.
+	AuthenticationResults []string
+	// Sender authentication results, either trusted ones reported
+	// by receiving server or, for DKIM, verified on retrieval.
+	Authentication Authentication
+	// IMAP flags and keywords set on message, e.g. '\Seen', '\Flagged' or '$Label1'.
+	Flags []string
+	// Time message was received by server. Unlike Date, it can not be set by sender.
+	InternalDate time.Time
+	// Size of the whole message in bytes as reported by server.
+	Size int64
+	// Modification sequence of message (requires CONDSTORE support by server).
+	ModSeq uint64
+	// Messages embedded into 'message/rfc822' parts, e.g. forwarded ones.
+	Embedded []*Message
+	// Attachments not retrieved according to client attachments
+	// policy. Only their metadata is known, content is always nil.
+	OmittedAttachments []Attachment
+}
+
+// Flags and keywords commonly used by IMAP servers.
+const (
+	FlagSeen      = `\Seen`
+	FlagAnswered  = `\Answered`
+	FlagFlagged   = `\Flagged`
+	FlagDeleted   = `\Deleted`
+	FlagDraft     = `\Draft`
+	FlagForwarded = "$Forwarded"
+	FlagJunk      = "$Junk"
+	FlagImportant = "$Important"
+)
+
+// HasFlag reports whether message has flag or keyword set.
+// Flags are compared case-insensitively.
+func (m *Message) HasFlag(flag string) bool {
+	for _, f := range m.Flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Flagged reports whether message is marked as flagged ('starred').
+func (m *Message) Flagged() bool {
+	return m.HasFlag(FlagFlagged)
+}
+
+// Seen reports whether message was already read.
+func (m *Message) Seen() bool {
+	return m.HasFlag(FlagSeen)
+}
+
+// Priority of message as declared by sender.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityLow
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// AuthResult is result of sender authentication method as defined
+// by RFC 8601, like "pass" or "fail". Empty result means it is unknown.
+type AuthResult string
+
+const (
+	AuthNone      AuthResult = "none"
+	AuthPass      AuthResult = "pass"
+	AuthFail      AuthResult = "fail"
+	AuthPolicy    AuthResult = "policy"
+	AuthNeutral   AuthResult = "neutral"
+	AuthSoftFail  AuthResult = "softfail"
+	AuthTempError AuthResult = "temperror"
+	AuthPermError AuthResult = "permerror"
+)
+
+// Authentication contains results of DKIM, SPF and DMARC sender
+// authentication methods.
+type Authentication struct {
+	DKIM AuthResult
+	// Domains of valid DKIM signatures ('d=' tag).
+	DKIMDomains []string
+	SPF         AuthResult
+	DMARC       AuthResult
+}
+
+// Known reports whether result of any authentication method is known.
+func (a Authentication) Known() bool {
+	return a.DKIM != "" || a.SPF != "" || a.DMARC != ""
+}
+
+// SenderVerified reports whether domain of 'From' address is authenticated,
+// either by passed DMARC check or by valid DKIM signature of the same domain,
+// its subdomain or parent one. SPF alone authenticates envelope sender only,
+// which may differ from the one recipient sees.
+func (m *Message) SenderVerified() bool {
+	if m.Authentication.DMARC == AuthPass {
+		return true
+	}
+	if m.Authentication.DKIM != AuthPass || len(m.From) == 0 {
+		return false
+	}
+
+	_, fromDomain, ok := strings.Cut(m.From[0].Address, "@")
+	if !ok {
+		return false
+	}
+	fromDomain = strings.ToLower(strings.TrimSuffix(fromDomain, "."))
+
+	for _, domain := range m.Authentication.DKIMDomains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if domain == fromDomain ||
+			strings.HasSuffix(fromDomain, "."+domain) ||
+			strings.HasSuffix(domain, "."+fromDomain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SenderUnverified reports whether sender authentication results are
+// known, but none of them verifies sender domain, so 'From' address
+// may be spoofed. Messages without results are not considered unverified.
+func (m *Message) SenderUnverified() bool {
+	return m.Authentication.Known() && !m.SenderVerified()
+}
+
+// Close releases resources held by message parts content,
+// such as temporary files. Message content is not accessible after closing.
+func (m *Message) Close() error {
+	var errs []error
+
+	for _, part := range m.BodyParts {
+		errs = append(errs, part.Close())
+	}
+	for _, attachment := range m.Attachments {
+		errs = append(errs, attachment.Close())
+	}
+	for _, embedded := range m.Embedded {
+		errs = append(errs, embedded.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Rewind positions content of every message part at its start,
+// so message could be read again, for example by multiple forwarders.
+func (m *Message) Rewind() error {
+	var errs []error
+
+	for _, part := range m.BodyParts {
+		errs = append(errs, part.Rewind())
+	}
+	for _, attachment := range m.Attachments {
+		errs = append(errs, attachment.Rewind())
+	}
+	for _, embedded := range m.Embedded {
+		errs = append(errs, embedded.Rewind())
+	}
+
+	return errors.Join(errs...)
 }
 
 type BodySegment struct {
@@ -24,6 +214,29 @@ type BodySegment struct {
 	MIMETypeParams map[string]string
 	Body           io.Reader
 	Size           int64
+	// Charset text content was originally encoded with,
+	// either declared or detected one. Content itself is
+	// always converted to UTF-8 on retrieval.
+	Charset string
+}
+
+// Close closes segment content if it is closable.
+func (s BodySegment) Close() error {
+	if c, ok := s.Body.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Rewind positions segment content at its start if it is seekable.
+func (s BodySegment) Rewind() error {
+	if seeker, ok := s.Body.(io.Seeker); ok {
+		_, err := seeker.Seek(0, io.SeekStart)
+		return err
+	}
+
+	return nil
 }
 
 type Attachment struct {
@@ -40,6 +253,16 @@ type Mail struct {
 	Messages        []*Message
 }
 
+// Close closes all retrieved messages.
+func (m Mail) Close() error {
+	var errs []error
+	for _, message := range m.Messages {
+		errs = append(errs, message.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
 type Address struct {
 	Address string
 	Name    string
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSenderVerified(t *testing.T) {
+	from := []Address{{Address: "alerts@Monitoring.Example.com"}}
+
+	tests := []struct {
+		message        *Message
+		wantVerified   bool
+		wantUnverified bool
+	}{
+		{message: &Message{From: from}},
+		{
+			message:      &Message{From: from, Authentication: Authentication{DMARC: AuthPass, DKIM: AuthFail}},
+			wantVerified: true,
+		},
+		{
+			message: &Message{From: from, Authentication: Authentication{
+				DKIM:        AuthPass,
+				DKIMDomains: []string{"example.com"},
+			}},
+			wantVerified: true,
+		},
+		{
+			message: &Message{From: from, Authentication: Authentication{
+				DKIM:        AuthPass,
+				DKIMDomains: []string{"mail.monitoring.example.com."},
+			}},
+			wantVerified: true,
+		},
+		{
+			message: &Message{From: from, Authentication: Authentication{
+				DKIM:        AuthPass,
+				DKIMDomains: []string{"sendgrid.net"},
+			}},
+			wantUnverified: true,
+		},
+		{
+			message: &Message{From: from, Authentication: Authentication{
+				DKIM:        AuthPass,
+				DKIMDomains: []string{"ample.com"},
+			}},
+			wantUnverified: true,
+		},
+		{
+			message:        &Message{From: from, Authentication: Authentication{SPF: AuthPass}},
+			wantUnverified: true,
+		},
+		{
+			message:        &Message{Authentication: Authentication{DKIM: AuthPass, DKIMDomains: []string{"example.com"}}},
+			wantUnverified: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			assert.Equal(t, tt.wantVerified, tt.message.SenderVerified())
+			assert.Equal(t, tt.wantUnverified, tt.message.SenderUnverified())
+		})
+	}
+}
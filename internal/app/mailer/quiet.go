@@ -0,0 +1,176 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/pkg/schedule"
+)
+
+// Actions applied to messages during contact point quiet hours.
+const (
+	// Messages are sent without notification sound.
+	quietActionSilent = "silent"
+	// Messages are sent once quiet hours end.
+	quietActionDelay = "delay"
+	// Messages are not sent at all.
+	quietActionDrop = "drop"
+)
+
+// ValidateQuietHours checks schedules and actions
+// of quiet hours of clients contact points.
+func ValidateQuietHours(clients []config.ClientConfig) error {
+	var errs []error
+
+	for _, client := range clients {
+		for i, contact := range client.ContactPoints {
+			if _, _, err := quietAction(contact); err != nil {
+				errs = append(errs, fmt.Errorf("client %q contact point %s quiet hours: %w",
+					client.Login, contact.DisplayName(i), err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// quietAction returns schedule of contact point quiet hours, nil if it has
+// none, along with action applied to messages during them.
+func quietAction(contact config.ContactPointConfiguration) (*schedule.Schedule, string, error) {
+	quietHours := contact.QuietHours
+	if len(quietHours.Schedule) == 0 {
+		return nil, "", nil
+	}
+
+	action := quietHours.Action
+	if action == "" {
+		action = quietActionSilent
+	}
+	if !slices.Contains([]string{quietActionSilent, quietActionDelay, quietActionDrop}, action) {
+		return nil, "", fmt.Errorf("unknown action %q, expected one of 'silent', 'delay', 'drop'", action)
+	}
+
+	quiet, err := schedule.Parse(quietHours.Schedule, quietHours.Timezone)
+	if err != nil {
+		return nil, "", fmt.Errorf("parse schedule: %w", err)
+	}
+
+	return quiet, action, nil
+}
+
+// quietHours returns action applied to messages sent to contact point
+// right now, or empty string if its quiet hours are not in effect.
+func (r *TaskRunner) quietHours(i int, contact config.ContactPointConfiguration) (string, error) {
+	quiet, action, err := quietAction(contact)
+	if err != nil {
+		return "", fmt.Errorf("quiet hours of contact point %s: %w", contact.DisplayName(i), err)
+	}
+	if !quiet.Contains(r.now()) {
+		return "", nil
+	}
+
+	return action, nil
+}
+
+// deliver sends messages to contact point, unless its quiet hours are in effect.
+// During quiet hours messages are sent silently, delayed until they end or dropped.
+func (r *TaskRunner) deliver(
+	ctx context.Context,
+	client config.ClientConfig,
+	i int,
+	contact config.ContactPointConfiguration,
+	messages []*Message,
+) error {
+	action, err := r.quietHours(i, contact)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case quietActionSilent:
+		contact.SilentMode = true
+	case quietActionDelay:
+		delayed, _ := r.delayed.Get(contactPointKey(client, i))
+		r.delayed.Set(contactPointKey(client, i), append(delayed, messages...))
+
+		r.logger.InfoContext(ctx, "messages delayed until quiet hours end",
+			slog.String("contact_point", contact.DisplayName(i)),
+			slog.Int("count", len(messages)),
+			slog.Int("total", len(delayed)+len(messages)),
+		)
+		return nil
+	case quietActionDrop:
+		r.logger.InfoContext(ctx, "messages dropped during quiet hours",
+			slog.String("contact_point", contact.DisplayName(i)),
+			slog.Int("count", len(messages)),
+		)
+		return nil
+	}
+
+	return r.notify(ctx, client, i, contact, messages)
+}
+
+// flushDelayed sends messages delayed for client contact points,
+// which quiet hours have ended, and releases them afterwards.
+func (r *TaskRunner) flushDelayed(ctx context.Context, client config.ClientConfig) error {
+	for i, contact := range client.ContactPoints {
+		delayed, ok := r.delayed.Get(contactPointKey(client, i))
+		if !ok {
+			continue
+		}
+
+		action, err := r.quietHours(i, contact)
+		if err != nil {
+			return err
+		}
+		if action != "" {
+			continue
+		}
+
+		if err = r.notify(ctx, client, i, contact, delayed); err != nil {
+			return err
+		}
+
+		r.delayed.Remove(contactPointKey(client, i))
+		r.release(ctx, delayed)
+
+		r.logger.InfoContext(ctx, "delayed messages sent",
+			slog.String("contact_point", contact.DisplayName(i)),
+			slog.Int("count", len(delayed)),
+		)
+	}
+
+	return nil
+}
+
+// release closes messages not delayed for any contact point.
+func (r *TaskRunner) release(ctx context.Context, messages []*Message) {
+	var errs []error
+	for _, message := range messages {
+		if !r.held(message) {
+			errs = append(errs, message.Close())
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		r.logger.WarnContext(ctx, "failed to release messages", slog.Any("error", err))
+	}
+}
+
+// held reports whether message is delayed for any contact point.
+func (r *TaskRunner) held(message *Message) bool {
+	for _, client := range r.cfg.Clients {
+		for i := range client.ContactPoints {
+			delayed, _ := r.delayed.Get(contactPointKey(client, i))
+			if slices.Contains(delayed, message) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
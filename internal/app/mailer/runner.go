@@ -2,11 +2,14 @@ package mailer
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/pkg/kvstore"
 	"github.com/hickar/chatmailer/internal/pkg/logger"
 )
 
@@ -17,18 +20,35 @@ type ClientStore interface {
 
 type Forwarder interface {
 	Forward(context.Context, config.ContactPointConfiguration, []*Message) error
+	ForwardDigest(context.Context, config.ContactPointConfiguration, Digest) error
+	// ForwardGroup sends notification of group, if it was not sent yet,
+	// or edits it otherwise, returning sent or edited notification.
+	ForwardGroup(context.Context, config.ContactPointConfiguration, Group) (Notification, error)
 }
 
 type MailRetriever interface {
 	GetMail(context.Context, config.ClientConfig) (Mail, error)
 }
 
+// MessageFilter matches retrieved messages against filter expressions.
+type MessageFilter interface {
+	Match(expr string, message *Message) (bool, error)
+}
+
 type TaskRunner struct {
 	cfg           config.Config
 	clientStore   ClientStore
 	mailRetriever MailRetriever
 	forwarder     Forwarder
+	filter        MessageFilter
 	logger        *slog.Logger
+	now           func() time.Time
+	// Digests buffered for contact points in digest mode.
+	digests *kvstore.KVStore[string, Digest]
+	// Messages delayed for contact points during their quiet hours.
+	delayed *kvstore.KVStore[string, []*Message]
+	// Open groups of contact points by their keys.
+	groups *kvstore.KVStore[string, map[string]Group]
 }
 
 func NewRunner(
@@ -36,6 +56,7 @@ func NewRunner(
 	clientStore ClientStore,
 	mailRetriever MailRetriever,
 	forwarder Forwarder,
+	filter MessageFilter,
 	logger *slog.Logger,
 ) TaskRunner {
 	return TaskRunner{
@@ -43,7 +64,12 @@ func NewRunner(
 		clientStore:   clientStore,
 		mailRetriever: mailRetriever,
 		forwarder:     forwarder,
+		filter:        filter,
 		logger:        logger,
+		now:           time.Now,
+		digests:       kvstore.New[string, Digest](),
+		delayed:       kvstore.New[string, []*Message](),
+		groups:        kvstore.New[string, map[string]Group](),
 	}
 }
 
@@ -51,6 +77,12 @@ func NewRunner(
 //
 // Updates client state (LastUIDNext, LastUIDValidity) in the operational memory storage
 // to not re-execute parsing and forwarding for already handled emails next time.
+// Messages already delivered to the same chat, for example retrieved from another
+// mailbox, are not forwarded again within configured dedupe window. Digests of
+// contact points in digest mode are sent once their window elapses. Messages
+// delayed during contact points quiet hours are sent once they end. Repeated
+// messages of contact points with grouping are collapsed into single notification.
+// Clients are polled even if previous ones have no new messages.
 func (r *TaskRunner) Run(ctx context.Context) error {
 	for _, client := range r.cfg.Clients {
 		ctx := logger.WithAttrs(ctx, slog.String("client", client.Login))
@@ -60,10 +92,6 @@ func (r *TaskRunner) Run(ctx context.Context) error {
 			client = stored
 		}
 
-		if len(client.ContactPoints) == 0 {
-			return errors.New("client has no contact points specified")
-		}
-
 		// Retrieve messages from client's mailbox.
 		mail, err := r.mailRetriever.GetMail(ctx, client)
 		if err != nil {
@@ -74,23 +102,178 @@ func (r *TaskRunner) Run(ctx context.Context) error {
 		// Update client's last read mail UIDs.
 		client.LastUIDNext = mail.LastUID
 		client.LastUIDValidity = mail.LastUIDValidity
+		client.Deliveries = r.recentDeliveries(client)
 		r.clientStore.Set(client.Login, client)
 
-		if len(mail.Messages) == 0 {
-			return nil
+		if len(mail.Messages) > 0 {
+			r.logger.InfoContext(ctx, fmt.Sprintf("received %d new messages received", len(mail.Messages)))
+
+			if err = r.forward(ctx, client, mail); err != nil {
+				return err
+			}
 		}
 
-		r.logger.InfoContext(ctx, fmt.Sprintf("received %d new messages received", len(mail.Messages)))
+		if err = r.flushDigests(ctx, client); err != nil {
+			return err
+		}
 
-		// Forward mail to each contact point specified for
-		// current client.
-		for _, contact := range client.ContactPoints {
-			err = r.forwarder.Forward(ctx, contact, mail.Messages)
-			if err != nil {
-				return fmt.Errorf("forward message: %w", err)
-			}
+		if err = r.flushDelayed(ctx, client); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forward sends mail to contact points specified for client, routed by their
+// filters and deduplicated. Messages for contact points in digest mode are
+// buffered instead, unless urgent. Retrieved messages are closed afterwards,
+// unless delayed during quiet hours.
+func (r *TaskRunner) forward(ctx context.Context, client config.ClientConfig, mail Mail) error {
+	defer r.release(ctx, mail.Messages)
+
+	routes, err := r.route(ctx, client.ContactPoints, mail.Messages)
+	if err != nil {
+		return fmt.Errorf("route messages: %w", err)
+	}
+
+	keys := make(map[*Message]string, len(mail.Messages))
+	for i, contact := range client.ContactPoints {
+		messages, err := r.dedupe(ctx, contact, routes[i], keys)
+		if err != nil {
+			return fmt.Errorf("dedupe messages: %w", err)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		if digestEnabled(contact) {
+			err = r.digest(ctx, client, i, contact, messages)
+		} else {
+			err = r.deliver(ctx, client, i, contact, messages)
+		}
+		if err != nil {
+			return err
+		}
+
+		r.markDelivered(client.Login, contact, messages, keys)
+	}
+
+	return nil
+}
+
+// send forwards messages to contact point.
+func (r *TaskRunner) send(ctx context.Context, contact config.ContactPointConfiguration, messages []*Message) error {
+	// Messages content is read by every contact point.
+	for _, message := range messages {
+		if err := message.Rewind(); err != nil {
+			return fmt.Errorf("rewind message: %w", err)
 		}
 	}
 
+	if err := r.forwarder.Forward(ctx, contact, messages); err != nil {
+		return fmt.Errorf("forward message: %w", err)
+	}
+
 	return nil
 }
+
+// route distributes messages between contact points. Contact points with
+// filters receive messages satisfying all of them, fallback ones receive
+// messages not matched by any of the former and others receive all messages.
+//
+// Routes chosen for every message are logged, if any
+// contact point of client has filters or is fallback one.
+func (r *TaskRunner) route(
+	ctx context.Context,
+	contacts []config.ContactPointConfiguration,
+	messages []*Message,
+) ([][]*Message, error) {
+	routes := make([][]*Message, len(contacts))
+	routing := slices.ContainsFunc(contacts, func(contact config.ContactPointConfiguration) bool {
+		return contact.Fallback || len(contact.Filters) > 0
+	})
+
+	for _, message := range messages {
+		var (
+			matched []string
+			// Whether message is matched by any
+			// non-fallback contact point with filters.
+			filtered bool
+		)
+
+		// Fallback contact points are matched last,
+		// after all other routes are known.
+		for _, fallback := range []bool{false, true} {
+			if fallback && filtered {
+				break
+			}
+
+			for i, contact := range contacts {
+				if contact.Fallback != fallback {
+					continue
+				}
+
+				ok, err := r.matchFilters(contact.Filters, message)
+				if err != nil {
+					return nil, fmt.Errorf("match message %d against filters of contact point %s: %w",
+						message.UID, contact.DisplayName(i), err)
+				}
+				if !ok {
+					continue
+				}
+
+				routes[i] = append(routes[i], message)
+				matched = append(matched, describeRoute(i, contact))
+				filtered = filtered || len(contact.Filters) > 0
+			}
+		}
+
+		if !routing {
+			continue
+		}
+
+		if len(matched) == 0 {
+			r.logger.InfoContext(ctx, "message matched no route", slog.Any("uid", message.UID), slog.String("subject", message.Subject))
+			continue
+		}
+
+		r.logger.InfoContext(ctx, "message routed",
+			slog.Any("uid", message.UID),
+			slog.String("subject", message.Subject),
+			slog.String("routes", strings.Join(matched, "; ")),
+		)
+	}
+
+	return routes, nil
+}
+
+// matchFilters reports whether message satisfies all filters.
+func (r *TaskRunner) matchFilters(filters []string, message *Message) (bool, error) {
+	for _, expr := range filters {
+		ok, err := r.filter.Match(expr, message)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// describeRoute returns contact point name along with
+// reason of message being sent to it, like
+// "on-call: SUBJECT == 'prod'" or "low-priority: fallback".
+func describeRoute(i int, contact config.ContactPointConfiguration) string {
+	reason := "all messages"
+	switch {
+	case len(contact.Filters) > 0:
+		reason = strings.Join(contact.Filters, " && ")
+		if contact.Fallback {
+			reason = "fallback, " + reason
+		}
+	case contact.Fallback:
+		reason = "fallback"
+	}
+
+	return contact.DisplayName(i) + ": " + reason
+}
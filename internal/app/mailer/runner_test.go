@@ -0,0 +1,693 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClientStore map[string]config.ClientConfig
+
+func (s fakeClientStore) Get(id string) (config.ClientConfig, bool) {
+	client, ok := s[id]
+	return client, ok
+}
+
+func (s fakeClientStore) Set(id string, client config.ClientConfig) {
+	s[id] = client
+}
+
+type fakeRetriever struct {
+	mail Mail
+	// Mail of specific clients by their logins, if differs.
+	mails map[string]Mail
+}
+
+func (r *fakeRetriever) GetMail(_ context.Context, client config.ClientConfig) (Mail, error) {
+	if mail, ok := r.mails[client.Login]; ok {
+		return mail, nil
+	}
+
+	return r.mail, nil
+}
+
+// fakeForwarder records UIDs of messages forwarded to contact points by their
+// names, followed by "silent" for messages sent in silent mode.
+type fakeForwarder map[string][]uint32
+
+func (f fakeForwarder) Forward(_ context.Context, contact config.ContactPointConfiguration, messages []*Message) error {
+	name := contact.Name
+	if contact.SilentMode {
+		name += " silent"
+	}
+
+	for _, message := range messages {
+		f[name] = append(f[name], message.UID)
+	}
+
+	return nil
+}
+
+// ForwardDigest records UIDs of digest messages by contact point name followed by "digest".
+func (f fakeForwarder) ForwardDigest(_ context.Context, contact config.ContactPointConfiguration, digest Digest) error {
+	for _, message := range digest.Messages {
+		f[contact.Name+" digest"] = append(f[contact.Name+" digest"], message.UID)
+	}
+
+	return nil
+}
+
+// ForwardGroup records UIDs of the first messages of new groups by contact point
+// name followed by "group" and counters of edited groups followed by "edit".
+func (f fakeForwarder) ForwardGroup(_ context.Context, contact config.ContactPointConfiguration, group Group) (Notification, error) {
+	if group.Notification.ID != 0 {
+		f[contact.Name+" edit"] = append(f[contact.Name+" edit"], uint32(group.Count))
+		return group.Notification, nil
+	}
+
+	f[contact.Name+" group"] = append(f[contact.Name+" group"], group.Message.UID)
+	return Notification{ID: int64(group.Message.UID), Content: group.Message.Subject}, nil
+}
+
+// fakeFilter matches messages by subject substring
+// specified as filter expression, like "prod".
+type fakeFilter struct{}
+
+func (fakeFilter) Match(expr string, message *Message) (bool, error) {
+	if expr == "" {
+		return false, fmt.Errorf("empty filter")
+	}
+
+	return strings.Contains(message.Subject, expr), nil
+}
+
+func TestRunRouting(t *testing.T) {
+	tests := []struct {
+		contacts []config.ContactPointConfiguration
+		want     fakeForwarder
+		wantLogs []string
+	}{
+		{
+			contacts: []config.ContactPointConfiguration{
+				{Name: "all"},
+				{Name: "also-all"},
+			},
+			want: fakeForwarder{"all": {1, 2, 3}, "also-all": {1, 2, 3}},
+		},
+		{
+			contacts: []config.ContactPointConfiguration{
+				{Name: "on-call", Filters: []string{"prod"}},
+				{Name: "db", Filters: []string{"prod", "db"}},
+				{Name: "low-priority", Fallback: true},
+			},
+			want: fakeForwarder{"on-call": {1, 2}, "db": {2}, "low-priority": {3}},
+			wantLogs: []string{
+				`msg="message routed" uid=1 subject="prod: disk" routes="on-call: prod"`,
+				`msg="message routed" uid=2 subject="prod: db" routes="on-call: prod; db: prod && db"`,
+				`msg="message routed" uid=3 subject="stage: disk" routes="low-priority: fallback"`,
+			},
+		},
+		{
+			contacts: []config.ContactPointConfiguration{
+				{Filters: []string{"db"}, Type: "telegram"},
+				{Name: "archive"},
+				{Name: "stage", Fallback: true, Filters: []string{"stage"}},
+			},
+			want: fakeForwarder{"": {2}, "archive": {1, 2, 3}, "stage": {3}},
+			wantLogs: []string{
+				`msg="message routed" uid=1 subject="prod: disk" routes="archive: all messages"`,
+				`msg="message routed" uid=2 subject="prod: db" routes="#1 (telegram): db; archive: all messages"`,
+				`msg="message routed" uid=3 subject="stage: disk" routes="archive: all messages; stage: fallback, stage"`,
+			},
+		},
+		{
+			contacts: []config.ContactPointConfiguration{
+				{Name: "db", Filters: []string{"db"}},
+			},
+			want: fakeForwarder{"db": {2}},
+			wantLogs: []string{
+				`msg="message matched no route" uid=1 subject="prod: disk"`,
+				`msg="message routed" uid=2 subject="prod: db" routes="db: db"`,
+				`msg="message matched no route" uid=3 subject="stage: disk"`,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey || a.Key == slog.LevelKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}))
+
+			forwarder := fakeForwarder{}
+			runner := NewRunner(
+				config.Config{Clients: []config.ClientConfig{{Login: "user", ContactPoints: tt.contacts}}},
+				fakeClientStore{},
+				&fakeRetriever{mail: Mail{Messages: []*Message{
+					{UID: 1, Subject: "prod: disk"},
+					{UID: 2, Subject: "prod: db"},
+					{UID: 3, Subject: "stage: disk"},
+				}}},
+				forwarder,
+				fakeFilter{},
+				logger,
+			)
+
+			require.NoError(t, runner.Run(context.Background()))
+			assert.Equal(t, tt.want, forwarder)
+
+			var routeLogs []string
+			for _, line := range strings.Split(logs.String(), "\n") {
+				if strings.Contains(line, "route") {
+					routeLogs = append(routeLogs, line)
+				}
+			}
+			assert.Equal(t, tt.wantLogs, routeLogs)
+		})
+	}
+}
+
+func TestRunRoutingError(t *testing.T) {
+	runner := NewRunner(
+		config.Config{Clients: []config.ClientConfig{{
+			Login:         "user",
+			ContactPoints: []config.ContactPointConfiguration{{Name: "broken", Filters: []string{""}}},
+		}}},
+		fakeClientStore{},
+		&fakeRetriever{mail: Mail{Messages: []*Message{{UID: 1}}}},
+		fakeForwarder{},
+		fakeFilter{},
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	err := runner.Run(context.Background())
+	assert.EqualError(t, err, "route messages: match message 1 against filters of contact point broken: empty filter")
+}
+
+func TestRunEmptyMailbox(t *testing.T) {
+	forwarder := fakeForwarder{}
+	runner := NewRunner(
+		config.Config{Clients: []config.ClientConfig{
+			{Login: "empty", ContactPoints: []config.ContactPointConfiguration{{Name: "empty"}}},
+			{Login: "user", ContactPoints: []config.ContactPointConfiguration{{Name: "user"}}},
+		}},
+		fakeClientStore{},
+		&fakeRetriever{mails: map[string]Mail{
+			"empty": {LastUID: 10},
+			"user":  {LastUID: 3, Messages: []*Message{{UID: 1}, {UID: 2}}},
+		}},
+		forwarder,
+		fakeFilter{},
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	// Client without new messages does not prevent the following ones from being polled.
+	require.NoError(t, runner.Run(context.Background()))
+	assert.Equal(t, fakeForwarder{"user": {1, 2}}, forwarder)
+}
+
+func TestRunDedupe(t *testing.T) {
+	newMessages := func() []*Message {
+		return []*Message{
+			{UID: 1, MessageID: "alert-1@example.com", Subject: "prod: disk"},
+			{
+				UID:       2,
+				From:      []Address{{Address: "alerts@example.com"}},
+				Subject:   "prod: db",
+				BodyParts: []BodySegment{{MIMEType: "text/plain", Body: strings.NewReader("db is down")}},
+			},
+			{UID: 3, MessageID: "alert-1@example.com", Subject: "prod: disk"},
+		}
+	}
+
+	tests := []struct {
+		window  time.Duration
+		elapsed time.Duration
+		want    fakeForwarder
+	}{
+		{
+			window: time.Hour,
+			want:   fakeForwarder{"chat": {1, 2}, "other": {1, 2}},
+		},
+		{
+			window:  time.Hour,
+			elapsed: 2 * time.Hour,
+			want:    fakeForwarder{"chat": {1, 2, 1, 2}, "other": {1, 2, 1, 2}},
+		},
+		{
+			want: fakeForwarder{
+				"chat":  {1, 2, 3, 1, 2, 3, 1, 2, 3, 1, 2, 3},
+				"other": {1, 2, 3, 1, 2, 3},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			cfg := config.Config{
+				Dedupe: config.DedupeConfiguration{Window: tt.window},
+				Clients: []config.ClientConfig{
+					{Login: "first", ContactPoints: []config.ContactPointConfiguration{{Name: "chat", TGChatID: 1}}},
+					{Login: "second", ContactPoints: []config.ContactPointConfiguration{
+						{Name: "chat", TGChatID: 1},
+						{Name: "other", TGChatID: 2},
+					}},
+				},
+			}
+
+			forwarder := fakeForwarder{}
+			retriever := &fakeRetriever{}
+			runner := NewRunner(
+				cfg,
+				fakeClientStore{},
+				retriever,
+				forwarder,
+				fakeFilter{},
+				slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+			)
+
+			now := time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC)
+			runner.now = func() time.Time { return now }
+
+			// The same messages are retrieved by both clients on every run.
+			for range 2 {
+				retriever.mail = Mail{Messages: newMessages()}
+				require.NoError(t, runner.Run(context.Background()))
+				now = now.Add(tt.elapsed)
+			}
+
+			assert.Equal(t, tt.want, forwarder)
+		})
+	}
+}
+
+func TestRunDedupeClientState(t *testing.T) {
+	now := time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC)
+
+	client := config.ClientConfig{
+		Login:         "user",
+		ContactPoints: []config.ContactPointConfiguration{{Name: "chat", TGChatID: 1}},
+	}
+	cfg := config.Config{
+		Dedupe:  config.DedupeConfiguration{Window: time.Hour},
+		Clients: []config.ClientConfig{client},
+	}
+
+	// Deliveries outside of window and to destinations of removed contact points are dropped.
+	client.Deliveries = map[string]map[string]time.Time{
+		"telegram:1": {
+			"id:old@example.com":    now.Add(-2 * time.Hour),
+			"id:recent@example.com": now.Add(-time.Minute),
+		},
+		"telegram:2": {"id:removed@example.com": now.Add(-time.Minute)},
+	}
+	store := fakeClientStore{"user": client}
+
+	forwarder := fakeForwarder{}
+	runner := NewRunner(
+		cfg,
+		store,
+		&fakeRetriever{mail: Mail{Messages: []*Message{
+			{UID: 1, MessageID: "recent@example.com"},
+			{UID: 2, MessageID: "new@example.com"},
+		}}},
+		forwarder,
+		fakeFilter{},
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+	runner.now = func() time.Time { return now }
+
+	require.NoError(t, runner.Run(context.Background()))
+
+	assert.Equal(t, fakeForwarder{"chat": {2}}, forwarder)
+	assert.Equal(t, map[string]map[string]time.Time{
+		"telegram:1": {
+			"id:recent@example.com": now.Add(-time.Minute),
+			"id:new@example.com":    now,
+		},
+	}, store["user"].Deliveries)
+}
+
+func TestRunDigest(t *testing.T) {
+	cfg := config.Config{Clients: []config.ClientConfig{{
+		Login: "user",
+		ContactPoints: []config.ContactPointConfiguration{
+			{
+				Name: "noisy",
+				Digest: config.DigestConfiguration{
+					Window:        15 * time.Minute,
+					MaxMessages:   3,
+					UrgentFilters: []string{"urgent", "critical"},
+				},
+			},
+			{Name: "archive"},
+		},
+	}}}
+
+	forwarder := fakeForwarder{}
+	retriever := &fakeRetriever{}
+	runner := NewRunner(
+		cfg,
+		fakeClientStore{},
+		retriever,
+		forwarder,
+		fakeFilter{},
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	now := time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC)
+	runner.now = func() time.Time { return now }
+
+	steps := []struct {
+		elapsed  time.Duration
+		messages []*Message
+		want     fakeForwarder
+	}{
+		{
+			messages: []*Message{
+				{UID: 1, Subject: "prod: disk"},
+				{UID: 2, Subject: "urgent: db"},
+				{UID: 3, Subject: "stage: disk"},
+			},
+			want: fakeForwarder{"noisy": {2}, "archive": {1, 2, 3}},
+		},
+		{
+			// Digest is sent once it is full.
+			elapsed:  5 * time.Minute,
+			messages: []*Message{{UID: 4, Subject: "prod: cpu"}},
+			want:     fakeForwarder{"noisy": {2}, "noisy digest": {1, 3, 4}, "archive": {1, 2, 3, 4}},
+		},
+		{
+			elapsed:  5 * time.Minute,
+			messages: []*Message{{UID: 5, Subject: "prod: memory"}, {UID: 6, Subject: "critical: prod"}},
+			want:     fakeForwarder{"noisy": {2, 6}, "noisy digest": {1, 3, 4}, "archive": {1, 2, 3, 4, 5, 6}},
+		},
+		{
+			elapsed: 10 * time.Minute,
+			want:    fakeForwarder{"noisy": {2, 6}, "noisy digest": {1, 3, 4}, "archive": {1, 2, 3, 4, 5, 6}},
+		},
+		{
+			// Digest is sent once its window elapses, even without new messages.
+			elapsed: 5 * time.Minute,
+			want:    fakeForwarder{"noisy": {2, 6}, "noisy digest": {1, 3, 4, 5}, "archive": {1, 2, 3, 4, 5, 6}},
+		},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.elapsed)
+		retriever.mail = Mail{Messages: step.messages}
+
+		require.NoError(t, runner.Run(context.Background()), "step %d", i)
+		assert.Equal(t, step.want, forwarder, "step %d", i)
+	}
+}
+
+func TestDigestSenders(t *testing.T) {
+	digest := Digest{Messages: []*Message{
+		{From: []Address{{Address: "alerts@example.com", Name: "Alerts"}}},
+		{From: []Address{{Address: "db@example.com"}}},
+		{From: []Address{{Address: "Alerts@Example.com"}}},
+		{},
+	}}
+
+	assert.Equal(t, []Address{
+		{Address: "alerts@example.com", Name: "Alerts"},
+		{Address: "db@example.com"},
+	}, digest.Senders())
+}
+
+func TestRunQuietHours(t *testing.T) {
+	quietHours := func(action string) config.QuietHoursConfiguration {
+		return config.QuietHoursConfiguration{
+			Schedule: []string{"Mon-Fri 19:00-09:00"},
+			Timezone: "UTC",
+			Action:   action,
+		}
+	}
+
+	cfg := config.Config{Clients: []config.ClientConfig{{
+		Login: "user",
+		ContactPoints: []config.ContactPointConfiguration{
+			{Name: "pager", QuietHours: quietHours("")},
+			{Name: "team", QuietHours: quietHours("delay")},
+			{Name: "chat", QuietHours: quietHours("drop")},
+			{
+				Name:       "noisy",
+				QuietHours: quietHours("delay"),
+				Digest:     config.DigestConfiguration{MaxMessages: 2},
+			},
+		},
+	}}}
+
+	forwarder := fakeForwarder{}
+	retriever := &fakeRetriever{}
+	runner := NewRunner(
+		cfg,
+		fakeClientStore{},
+		retriever,
+		forwarder,
+		fakeFilter{},
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	// Monday evening.
+	now := time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC)
+	runner.now = func() time.Time { return now }
+
+	steps := []struct {
+		elapsed  time.Duration
+		messages []*Message
+		want     fakeForwarder
+	}{
+		{
+			messages: []*Message{{UID: 1, Subject: "prod: disk"}, {UID: 2, Subject: "prod: cpu"}},
+			want:     fakeForwarder{"pager silent": {1, 2}},
+		},
+		{
+			elapsed:  8 * time.Hour,
+			messages: []*Message{{UID: 3, Subject: "prod: memory"}},
+			want:     fakeForwarder{"pager silent": {1, 2, 3}},
+		},
+		{
+			// Delayed messages and digest are sent once quiet hours end.
+			elapsed: 3 * time.Hour,
+			want: fakeForwarder{
+				"pager silent": {1, 2, 3},
+				"team":         {1, 2, 3},
+				"noisy digest": {1, 2, 3},
+			},
+		},
+		{
+			elapsed:  time.Hour,
+			messages: []*Message{{UID: 4, Subject: "prod: network"}},
+			want: fakeForwarder{
+				"pager silent": {1, 2, 3},
+				"pager":        {4},
+				"team":         {1, 2, 3, 4},
+				"chat":         {4},
+				"noisy digest": {1, 2, 3},
+			},
+		},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.elapsed)
+		retriever.mail = Mail{Messages: step.messages}
+
+		require.NoError(t, runner.Run(context.Background()), "step %d", i)
+		assert.Equal(t, step.want, forwarder, "step %d", i)
+	}
+}
+
+// closeTracker is message part content recording whether it was closed.
+type closeTracker struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRunQuietHoursRelease(t *testing.T) {
+	cfg := config.Config{Clients: []config.ClientConfig{{
+		Login: "user",
+		ContactPoints: []config.ContactPointConfiguration{
+			{Name: "team", QuietHours: config.QuietHoursConfiguration{Schedule: []string{"Sat,Sun"}, Action: "delay"}},
+			{Name: "archive"},
+		},
+	}}}
+
+	forwarder := fakeForwarder{}
+	retriever := &fakeRetriever{}
+	runner := NewRunner(
+		cfg,
+		fakeClientStore{},
+		retriever,
+		forwarder,
+		fakeFilter{},
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	// Sunday.
+	now := time.Date(2025, time.March, 9, 12, 0, 0, 0, time.UTC)
+	runner.now = func() time.Time { return now }
+
+	body := &closeTracker{Reader: strings.NewReader("Disk is full")}
+	retriever.mail = Mail{Messages: []*Message{{UID: 1, BodyParts: []BodySegment{{Body: body}}}}}
+
+	require.NoError(t, runner.Run(context.Background()))
+	assert.Equal(t, fakeForwarder{"archive": {1}}, forwarder)
+	assert.False(t, body.closed, "delayed message has to be retained")
+
+	now = now.Add(24 * time.Hour)
+	retriever.mail = Mail{}
+
+	require.NoError(t, runner.Run(context.Background()))
+	assert.Equal(t, fakeForwarder{"archive": {1}, "team": {1}}, forwarder)
+	assert.True(t, body.closed, "sent message has to be released")
+}
+
+func TestValidateQuietHours(t *testing.T) {
+	clients := []config.ClientConfig{{
+		Login: "user",
+		ContactPoints: []config.ContactPointConfiguration{
+			{QuietHours: config.QuietHoursConfiguration{Schedule: []string{"Sat,Sun"}, Action: "delay"}},
+			{Name: "team", QuietHours: config.QuietHoursConfiguration{Schedule: []string{"Sat"}, Action: "mute"}},
+			{Type: "telegram", QuietHours: config.QuietHoursConfiguration{Schedule: []string{"Sat 25:00-07:00"}}},
+			{Name: "chat", QuietHours: config.QuietHoursConfiguration{Action: "mute"}},
+		},
+	}}
+
+	err := ValidateQuietHours(clients)
+	assert.EqualError(t, err, `client "user" contact point team quiet hours: unknown action "mute", expected one of 'silent', 'delay', 'drop'`+"\n"+
+		`client "user" contact point #3 (telegram) quiet hours: parse schedule: parse range "Sat 25:00-07:00": invalid time "25:00", expected HH:MM`)
+}
+
+func TestRunGrouping(t *testing.T) {
+	cfg := config.Config{Clients: []config.ClientConfig{{
+		Login: "user",
+		ContactPoints: []config.ContactPointConfiguration{
+			{Name: "alerts", Grouping: config.GroupingConfiguration{Window: time.Hour}},
+			{Name: "archive"},
+		},
+	}}}
+
+	forwarder := fakeForwarder{}
+	retriever := &fakeRetriever{}
+	runner := NewRunner(
+		cfg,
+		fakeClientStore{},
+		retriever,
+		forwarder,
+		fakeFilter{},
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	)
+
+	now := time.Date(2025, time.March, 10, 10, 0, 0, 0, time.UTC)
+	runner.now = func() time.Time { return now }
+
+	steps := []struct {
+		elapsed  time.Duration
+		messages []*Message
+		want     fakeForwarder
+	}{
+		{
+			messages: []*Message{
+				{UID: 1, Subject: "Disk usage 95% on db-1"},
+				{UID: 2, Subject: "CPU usage is high"},
+				{UID: 3, Subject: "Disk usage 97% on db-2"},
+			},
+			want: fakeForwarder{
+				"alerts group": {1, 2},
+				"alerts edit":  {2},
+				"archive":      {1, 2, 3},
+			},
+		},
+		{
+			elapsed: 50 * time.Minute,
+			messages: []*Message{
+				{UID: 4, Subject: "Re: disk usage 99% on db-1"},
+				{UID: 5, Subject: "Disk usage 98% on db-3"},
+			},
+			want: fakeForwarder{
+				"alerts group": {1, 2},
+				"alerts edit":  {2, 4},
+				"archive":      {1, 2, 3, 4, 5},
+			},
+		},
+		{
+			// Group is closed once window elapses since its last message.
+			elapsed:  time.Hour,
+			messages: []*Message{{UID: 6, Subject: "Disk usage 90% on db-1"}},
+			want: fakeForwarder{
+				"alerts group": {1, 2, 6},
+				"alerts edit":  {2, 4},
+				"archive":      {1, 2, 3, 4, 5, 6},
+			},
+		},
+		{
+			// Messages without subject or with numbers only are not grouped.
+			messages: []*Message{
+				{UID: 7, Subject: ""},
+				{UID: 8, Subject: "2025-03-10 12:00"},
+				{UID: 9},
+			},
+			want: fakeForwarder{
+				"alerts":       {7, 8, 9},
+				"alerts group": {1, 2, 6},
+				"alerts edit":  {2, 4},
+				"archive":      {1, 2, 3, 4, 5, 6, 7, 8, 9},
+			},
+		},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.elapsed)
+		retriever.mail = Mail{Messages: step.messages}
+
+		require.NoError(t, runner.Run(context.Background()), "step %d", i)
+		assert.Equal(t, step.want, forwarder, "step %d", i)
+	}
+}
+
+func TestGroupKey(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{subject: "Disk usage 95% on db-1", want: "disk usage % on db-"},
+		{subject: "RE: Fwd: Disk usage 97% on db-2", want: "disk usage % on db-"},
+		{subject: "Re[2]: Fw: Backup failed", want: "backup failed"},
+		{subject: "Backup failed at 2025-03-10T10:42:00Z", want: "backup failed at"},
+		{subject: "Backup failed at 2025-03-10 10:42", want: "backup failed at"},
+		{subject: "Backup failed on 10.03.2025 at 10:42:15", want: "backup failed on at"},
+		{subject: "  Job #1234   failed  ", want: "job # failed"},
+		{subject: "Reminder: renew certificate", want: "reminder: renew certificate"},
+		{subject: "", want: ""},
+		{subject: "Re: 2025-03-10 10:42", want: ""},
+		{subject: "#1234 - 95%", want: ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			assert.Equal(t, tt.want, groupKey(tt.subject))
+		})
+	}
+}
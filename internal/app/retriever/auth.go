@@ -0,0 +1,182 @@
+package retriever
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// Maximum number of DKIM signatures verified per message, as every
+// signature requires DNS lookup. The rest of signatures are ignored.
+const maxDKIMVerifications = 5
+
+// TXTResolver looks up DNS TXT records, like DKIM public keys.
+// It is implemented by *net.Resolver.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+var _ TXTResolver = net.DefaultResolver
+
+// verifyMessageDKIM fetches the whole message and verifies its DKIM
+// signatures. Verification result overrides one reported by server.
+func (r *imapRetriever) verifyMessageDKIM(ctx context.Context, c *imapclient.Client, message *mailer.Message) error {
+	section := &imap.FetchItemBodySection{Peek: true}
+
+	fetchCmd := c.Fetch(imap.UIDSetNum(imap.UID(message.UID)), &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{section},
+	})
+	defer func() {
+		_ = fetchCmd.Close()
+	}()
+
+	msg := fetchCmd.Next()
+	if msg == nil {
+		return errors.New("message not found")
+	}
+
+	for {
+		item := msg.Next()
+		if item == nil {
+			break
+		}
+
+		data, ok := item.(imapclient.FetchItemDataBodySection)
+		if !ok || data.Literal == nil {
+			continue
+		}
+
+		result, domains, err := verifyDKIM(ctx, data.Literal, r.resolver)
+		if err != nil {
+			return err
+		}
+
+		message.Authentication.DKIM = result
+		message.Authentication.DKIMDomains = domains
+	}
+
+	return fetchCmd.Close()
+}
+
+// verifyDKIM verifies DKIM signatures of message in RFC 5322 format.
+// Message passes verification if any of its signatures is valid,
+// domains of valid signatures are returned along with result.
+func verifyDKIM(ctx context.Context, r io.Reader, resolver TXTResolver) (mailer.AuthResult, []string, error) {
+	verifications, err := dkim.VerifyWithOptions(r, &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			return resolver.LookupTXT(ctx, domain)
+		},
+		MaxVerifications: maxDKIMVerifications,
+	})
+	if err != nil && !errors.Is(err, dkim.ErrTooManySignatures) {
+		return "", nil, fmt.Errorf("verify DKIM signatures: %w", err)
+	}
+
+	// Message read partially can not be fetched further.
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return "", nil, fmt.Errorf("read message: %w", err)
+	}
+
+	if len(verifications) == 0 {
+		return mailer.AuthNone, nil, nil
+	}
+
+	var (
+		domains []string
+		results []mailer.AuthResult
+	)
+	for _, v := range verifications {
+		switch {
+		case v.Err == nil:
+			domains = append(domains, v.Domain)
+			results = append(results, mailer.AuthPass)
+		case dkim.IsTempFail(v.Err):
+			results = append(results, mailer.AuthTempError)
+		case dkim.IsPermFail(v.Err):
+			results = append(results, mailer.AuthPermError)
+		default:
+			results = append(results, mailer.AuthFail)
+		}
+	}
+
+	// Transient error is reported rather than failure,
+	// as message may pass verification later.
+	for _, result := range []mailer.AuthResult{mailer.AuthPass, mailer.AuthTempError, mailer.AuthFail} {
+		if slices.Contains(results, result) {
+			return result, domains, nil
+		}
+	}
+
+	return mailer.AuthPermError, nil, nil
+}
+
+// parseAuthenticationResults returns sender authentication results from
+// 'Authentication-Results' header values (RFC 8601), topmost first.
+//
+// Such headers can be added by anyone on message path, including sender,
+// so only ones of servers with trusted identifiers are taken into account,
+// as server is expected to remove headers forged with its identifier
+// (RFC 8601, section 5). If no identifiers are trusted, all headers are
+// ignored, since receiving server may not add its own one at all.
+func parseAuthenticationResults(values []string, trustedIDs []string) mailer.Authentication {
+	var auth mailer.Authentication
+
+	for _, value := range values {
+		id, results, err := authres.Parse(value)
+		if err != nil && len(results) == 0 {
+			continue
+		}
+
+		if !slices.ContainsFunc(trustedIDs, func(trusted string) bool {
+			return strings.EqualFold(trusted, id)
+		}) {
+			continue
+		}
+
+		for _, result := range results {
+			switch result := result.(type) {
+			case *authres.DKIMResult:
+				value := mailer.AuthResult(result.Value)
+				if domain := dkimResultDomain(result); value == mailer.AuthPass && domain != "" {
+					auth.DKIMDomains = append(auth.DKIMDomains, domain)
+				}
+				if auth.DKIM == "" || value == mailer.AuthPass {
+					auth.DKIM = value
+				}
+			case *authres.SPFResult:
+				if auth.SPF == "" {
+					auth.SPF = mailer.AuthResult(result.Value)
+				}
+			case *authres.DMARCResult:
+				if auth.DMARC == "" {
+					auth.DMARC = mailer.AuthResult(result.Value)
+				}
+			}
+		}
+	}
+
+	return auth
+}
+
+// dkimResultDomain returns signing domain of DKIM result, either reported
+// explicitly or taken from agent identifier, like "@example.com".
+func dkimResultDomain(result *authres.DKIMResult) string {
+	if result.Domain != "" {
+		return result.Domain
+	}
+
+	_, domain, _ := strings.Cut(result.Identifier, "@")
+	return domain
+}
@@ -0,0 +1,188 @@
+package retriever
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver serves TXT records by domain name,
+// unknown names are reported as not found.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if name == "temp._domainkey.example.com" {
+		return nil, &net.DNSError{Err: "timeout", Name: name, IsTemporary: true}
+	}
+
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return records, nil
+}
+
+const unsignedMessage = "From: Alerts <alerts@example.com>\r\n" +
+	"To: on-call@example.org\r\n" +
+	"Subject: [ALERT] Disk is full\r\n" +
+	"\r\n" +
+	"Disk usage is 100%.\r\n"
+
+func signMessage(t *testing.T, message, domain, selector string, key ed25519.PrivateKey) string {
+	t.Helper()
+
+	var signed bytes.Buffer
+	err := dkim.Sign(&signed, strings.NewReader(message), &dkim.SignOptions{
+		Domain:     domain,
+		Selector:   selector,
+		Signer:     key,
+		HeaderKeys: []string{"From", "To", "Subject"},
+	})
+	require.NoError(t, err)
+
+	return signed.String()
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	resolver := fakeResolver{
+		"s1._domainkey.example.com": {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(publicKey)},
+	}
+	signed := signMessage(t, unsignedMessage, "example.com", "s1", privateKey)
+
+	tests := []struct {
+		message     string
+		wantResult  mailer.AuthResult
+		wantDomains []string
+	}{
+		{message: signed, wantResult: mailer.AuthPass, wantDomains: []string{"example.com"}},
+		{message: unsignedMessage, wantResult: mailer.AuthNone},
+		{
+			message:    strings.Replace(signed, "100%", "42%", 1),
+			wantResult: mailer.AuthFail,
+		},
+		{
+			message:    strings.Replace(signed, "Disk is full", "Disk is fine", 1),
+			wantResult: mailer.AuthFail,
+		},
+		{
+			message:    signMessage(t, unsignedMessage, "example.com", "unknown", privateKey),
+			wantResult: mailer.AuthPermError,
+		},
+		{
+			message:    signMessage(t, unsignedMessage, "example.com", "temp", privateKey),
+			wantResult: mailer.AuthTempError,
+		},
+		{
+			message:     signMessage(t, signed, "example.com", "unknown", privateKey),
+			wantResult:  mailer.AuthPass,
+			wantDomains: []string{"example.com"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			r := strings.NewReader(tt.message)
+
+			result, domains, err := verifyDKIM(context.Background(), r, resolver)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantResult, result)
+			assert.Equal(t, tt.wantDomains, domains)
+			assert.Zero(t, r.Len(), "message has to be read completely")
+		})
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	tests := []struct {
+		values     []string
+		trustedIDs []string
+		want       mailer.Authentication
+	}{
+		{
+			values: []string{
+				"mx.example.org; dkim=pass header.d=example.com; spf=pass smtp.mailfrom=example.com; dmarc=pass header.from=example.com",
+			},
+			trustedIDs: []string{"mx.example.org"},
+			want: mailer.Authentication{
+				DKIM:        mailer.AuthPass,
+				DKIMDomains: []string{"example.com"},
+				SPF:         mailer.AuthPass,
+				DMARC:       mailer.AuthPass,
+			},
+		},
+		{
+			// Forged topmost header is ignored, if receiving server does not add its own one.
+			values: []string{
+				"mx.example.org; dkim=pass header.d=example.com; spf=pass smtp.mailfrom=example.com; dmarc=pass",
+			},
+			want: mailer.Authentication{},
+		},
+		{
+			// Header forged by sender with identifier of another server is ignored.
+			values: []string{
+				"mx.example.org; dkim=fail header.d=example.com; spf=softfail smtp.mailfrom=example.com",
+				"evil.example.com; dkim=pass header.d=example.com; dmarc=pass",
+			},
+			trustedIDs: []string{"mx.example.org"},
+			want:       mailer.Authentication{DKIM: mailer.AuthFail, SPF: mailer.AuthSoftFail},
+		},
+		{
+			// Receiving server may add several headers.
+			values: []string{
+				"mx.example.org; spf=pass smtp.mailfrom=example.com",
+				"mx.example.org; dkim=fail header.d=evil.com; dkim=pass header.i=@example.com",
+				"relay.example.net; dmarc=fail",
+			},
+			trustedIDs: []string{"mx.example.org"},
+			want: mailer.Authentication{
+				DKIM:        mailer.AuthPass,
+				DKIMDomains: []string{"example.com"},
+				SPF:         mailer.AuthPass,
+			},
+		},
+		{
+			values: []string{
+				"relay.example.net; dmarc=pass",
+				"mx.example.org; dmarc=fail",
+			},
+			trustedIDs: []string{"MX.example.org"},
+			want:       mailer.Authentication{DMARC: mailer.AuthFail},
+		},
+		{
+			values:     []string{"relay.example.net; dmarc=pass"},
+			trustedIDs: []string{"mx.example.org"},
+			want:       mailer.Authentication{},
+		},
+		{
+			values:     []string{"mx.example.org; none"},
+			trustedIDs: []string{"mx.example.org"},
+			want:       mailer.Authentication{},
+		},
+		{
+			values:     []string{"mx.example.org 2; dkim=pass", "mx.example.org; dkim=pass"},
+			trustedIDs: []string{"mx.example.org"},
+			want:       mailer.Authentication{DKIM: mailer.AuthPass},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			assert.Equal(t, tt.want, parseAuthenticationResults(tt.values, tt.trustedIDs))
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package retriever
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message/charset"
+)
+
+// Size of leading chunk of content used to guess its charset.
+const charsetSampleSize = 4096
+
+// decodeText returns text part content converted to UTF-8
+// along with name of charset content was originally encoded with.
+//
+// Content in known declared charset is converted by go-message while
+// reading part already, so only content with missing or unknown charset,
+// as well as one declared as UTF-8 while not being valid UTF-8 (which
+// happens surprisingly often), is inspected here and its charset is guessed.
+func decodeText(body io.Reader, declared string) (io.Reader, string) {
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	if declared != "" && !isUnicodeCharset(declared) && isKnownCharset(declared) {
+		return body, declared
+	}
+
+	br := bufio.NewReaderSize(body, charsetSampleSize)
+	sample, _ := br.Peek(charsetSampleSize)
+
+	detected := detectCharset(sample)
+	if detected == "utf-8" {
+		if declared == "" || !isUnicodeCharset(declared) {
+			declared = detected
+		}
+
+		return br, declared
+	}
+
+	decoded, err := charset.Reader(detected, br)
+	if err != nil {
+		return br, declared
+	}
+
+	return decoded, detected
+}
+
+func isUnicodeCharset(name string) bool {
+	return name == "utf-8" || name == "utf8" || name == "us-ascii"
+}
+
+func isKnownCharset(name string) bool {
+	_, err := charset.Reader(name, strings.NewReader(""))
+	return err == nil
+}
+
+// detectCharset guesses charset of content by its leading chunk.
+//
+// Detection is based on simple heuristics covering the most common
+// cases of undeclared charsets, rather than on statistical models:
+// byte order marks, ISO-2022-JP escape sequences and distribution of
+// bytes specific for Cyrillic single-byte charsets. Content not matching
+// any of them is considered to be Windows-1252, the most common
+// charset of legacy Western European emails.
+func detectCharset(sample []byte) string {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}), bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return "utf-16"
+	case isISO2022JP(sample):
+		return "iso-2022-jp"
+	case isUTF8(sample):
+		return "utf-8"
+	}
+
+	return detectSingleByteCharset(sample)
+}
+
+// isUTF8 reports whether sample is valid UTF-8 text,
+// ignoring incomplete character at the end of it.
+func isUTF8(sample []byte) bool {
+	for len(sample) > 0 {
+		r, size := utf8.DecodeRune(sample)
+		if r == utf8.RuneError && size == 1 {
+			return !utf8.FullRune(sample)
+		}
+
+		sample = sample[size:]
+	}
+
+	return true
+}
+
+// isISO2022JP reports whether sample is 7-bit text
+// containing ISO-2022-JP charset switching sequences.
+func isISO2022JP(sample []byte) bool {
+	escapes := false
+
+	for i, b := range sample {
+		if b >= 0x80 {
+			return false
+		}
+		if b != 0x1B || i+2 >= len(sample) {
+			continue
+		}
+
+		switch string(sample[i+1 : i+3]) {
+		case "$B", "$@", "(J", "(B":
+			escapes = true
+		}
+	}
+
+	return escapes
+}
+
+func detectSingleByteCharset(sample []byte) string {
+	var latin, upperHalf, lowerHalf int
+
+	for _, b := range sample {
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z':
+			latin++
+		case b >= 0xE0:
+			upperHalf++
+		case b >= 0xC0:
+			lowerHalf++
+		}
+	}
+
+	// Accented letters are relatively rare in Western European languages,
+	// while in Cyrillic texts almost all letters are non-ASCII ones.
+	if upperHalf+lowerHalf <= latin {
+		return "windows-1252"
+	}
+
+	// Lowercase letters prevail in any text. Windows-1251 places them
+	// in the upper half of 0xC0-0xFF range, while KOI8-R in the lower one.
+	if upperHalf >= lowerHalf {
+		return "windows-1251"
+	}
+
+	return "koi8-r"
+}
@@ -0,0 +1,80 @@
+package retriever
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeCharsets(t *testing.T) {
+	const (
+		russian  = "Привет! Резервное копирование базы данных завершилось с ошибкой."
+		japanese = "バックアップに失敗しました。"
+		french   = "Le café est prêt, à bientôt."
+	)
+
+	tests := []struct {
+		fixture string
+		charset string
+		want    string
+	}{
+		{fixture: "windows-1251.eml", charset: "windows-1251", want: russian},
+		{fixture: "koi8-r-undeclared.eml", charset: "koi8-r", want: russian},
+		{fixture: "iso-2022-jp.eml", charset: "iso-2022-jp", want: japanese},
+		{fixture: "windows-1251-as-utf-8.eml", charset: "windows-1251", want: russian},
+		{fixture: "unknown-charset.eml", charset: "koi8-r", want: russian},
+		{fixture: "latin1-undeclared.eml", charset: "windows-1252", want: french},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "charset", tt.fixture))
+			require.NoError(t, err)
+			defer func() {
+				_ = f.Close()
+			}()
+
+			var msg mailer.Message
+			err = parseMessageBody(&msg, f, config.ClientConfig{}, newSpooler(config.SpoolConfiguration{}))
+			require.NoError(t, err)
+			require.Len(t, msg.BodyParts, 1)
+
+			b, err := io.ReadAll(msg.BodyParts[0].Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, strings.TrimSpace(string(b)))
+			assert.Equal(t, tt.charset, msg.BodyParts[0].Charset)
+		})
+	}
+}
+
+func TestDetectCharset(t *testing.T) {
+	tests := []struct {
+		sample []byte
+		want   string
+	}{
+		{sample: []byte("plain ASCII text"), want: "utf-8"},
+		{sample: []byte("валидный UTF-8"), want: "utf-8"},
+		// Sample cut in the middle of multibyte character.
+		{sample: []byte("текст")[:3], want: "utf-8"},
+		{sample: []byte{0xFF, 0xFE, 'h', 0x00, 'i', 0x00}, want: "utf-16"},
+		{sample: []byte("\x1b$B$3$s$K$A$O\x1b(B"), want: "iso-2022-jp"},
+		{sample: []byte{0xEF, 0xF0, 0xE8, 0xE2, 0xE5, 0xF2}, want: "windows-1251"},
+		{sample: []byte{0xD0, 0xD2, 0xC9, 0xD7, 0xC5, 0xD4}, want: "koi8-r"},
+		{sample: []byte("na\xefve r\xe9sum\xe9"), want: "windows-1252"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			assert.Equal(t, tt.want, detectCharset(tt.sample))
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package retriever
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+)
+
+// ReadMessage parses message in RFC 5322 format, like content of '.eml'
+// file, the same way retrieved ones are parsed. All attachments are
+// retained. As there is no server, message internal date is taken from
+// its 'Date' header. Only 'Authentication-Results' headers of servers with
+// trusted identifiers are taken into account. Message has to be closed after use.
+func ReadMessage(r io.Reader, spoolCfg config.SpoolConfiguration, trustedIDs []string) (*mailer.Message, error) {
+	counter := &countingReader{r: r}
+	msg := &mailer.Message{}
+
+	cfg := config.ClientConfig{IncludeAttachments: true, MaximumAttachmentsSize: -1}
+	if err := parseMessageBody(msg, counter, cfg, newSpooler(spoolCfg)); err != nil {
+		_ = msg.Close()
+		return nil, fmt.Errorf("parse message: %w", err)
+	}
+
+	// Trailing content, like epilogue, is counted as well.
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		_ = msg.Close()
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+
+	msg.Size = counter.n
+	msg.InternalDate = msg.Date
+	msg.Authentication = parseAuthenticationResults(msg.AuthenticationResults, trustedIDs)
+
+	return msg, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	return n, err
+}
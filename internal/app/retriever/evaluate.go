@@ -0,0 +1,260 @@
+package retriever
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// criteriaMatcher evaluates search criteria against single message on
+// client side, when server is not able to filter messages itself.
+//
+// It follows IMAP SEARCH semantics (RFC 9051, section 6.4.4) as close
+// as possible: string matching is case-insensitive substring one and
+// dates are compared ignoring time and timezone. Criteria which can
+// not be evaluated locally, like sequence numbers, are ignored.
+// Message body is read at most once.
+type criteriaMatcher struct {
+	message *mailer.Message
+	body    *string
+	// UIDs of messages found by Gmail extension searches.
+	gmail map[gmailQuery]imap.UIDSet
+}
+
+func (m *criteriaMatcher) match(criteria *imap.SearchCriteria) (bool, error) {
+	if criteria == nil {
+		return true, nil
+	}
+
+	msg := m.message
+
+	for _, uids := range criteria.UID {
+		if !uids.Contains(imap.UID(msg.UID)) {
+			return false, nil
+		}
+	}
+
+	if !matchDateRange(msg.InternalDate, criteria.Since, criteria.Before) ||
+		!matchDateRange(msg.Date, criteria.SentSince, criteria.SentBefore) {
+		return false, nil
+	}
+
+	for _, flag := range criteria.Flag {
+		if !msg.HasFlag(string(flag)) {
+			return false, nil
+		}
+	}
+	for _, flag := range criteria.NotFlag {
+		if msg.HasFlag(string(flag)) {
+			return false, nil
+		}
+	}
+
+	if criteria.Larger > 0 && msg.Size <= criteria.Larger {
+		return false, nil
+	}
+	if criteria.Smaller > 0 && msg.Size >= criteria.Smaller {
+		return false, nil
+	}
+	if criteria.ModSeq != nil && msg.ModSeq < criteria.ModSeq.ModSeq {
+		return false, nil
+	}
+
+	for _, field := range criteria.Header {
+		if !matchHeader(msg, field) {
+			return false, nil
+		}
+	}
+
+	if len(criteria.Body) > 0 || len(criteria.Text) > 0 {
+		body, err := m.bodyText()
+		if err != nil {
+			return false, err
+		}
+
+		for _, s := range criteria.Body {
+			if !containsFold(body, s) {
+				return false, nil
+			}
+		}
+		for _, s := range criteria.Text {
+			if !containsFold(body, s) && !matchAnyHeader(msg, s) {
+				return false, nil
+			}
+		}
+	}
+
+	for i := range criteria.Not {
+		ok, err := m.match(&criteria.Not[i])
+		if err != nil || ok {
+			return false, err
+		}
+	}
+
+	for i := range criteria.Or {
+		ok, err := m.match(&criteria.Or[i][0])
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			continue
+		}
+
+		ok, err = m.match(&criteria.Or[i][1])
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// bodyText returns content of all message text parts.
+// Parts are rewound afterwards to be consumed by forwarders.
+func (m *criteriaMatcher) bodyText() (string, error) {
+	if m.body != nil {
+		return *m.body, nil
+	}
+
+	parts := make([]string, 0, len(m.message.BodyParts))
+	for _, part := range m.message.BodyParts {
+		if part.Body == nil {
+			continue
+		}
+
+		b, err := io.ReadAll(part.Body)
+		if err != nil {
+			return "", fmt.Errorf("read body part: %w", err)
+		}
+		if err = part.Rewind(); err != nil {
+			return "", fmt.Errorf("rewind body part: %w", err)
+		}
+
+		parts = append(parts, string(b))
+	}
+
+	body := strings.Join(parts, "\n")
+	m.body = &body
+
+	return body, nil
+}
+
+// fieldValues returns values of message field compared by filter:
+// body text for BODY, body text along with all header
+// field values for TEXT and header field values otherwise.
+func (m *criteriaMatcher) fieldValues(field string) ([]string, error) {
+	switch strings.ToUpper(field) {
+	case "BODY", "TEXT":
+		body, err := m.bodyText()
+		if err != nil {
+			return nil, err
+		}
+
+		values := []string{body}
+		if strings.EqualFold(field, "TEXT") {
+			for key := range m.message.Header {
+				values = append(values, headerValues(m.message, key)...)
+			}
+		}
+
+		return values, nil
+	}
+
+	return headerValues(m.message, field), nil
+}
+
+// matchHeader reports whether message header field contains specified
+// string. Empty string matches any message having such field at all.
+func matchHeader(msg *mailer.Message, field imap.SearchCriteriaHeaderField) bool {
+	values := headerValues(msg, field.Key)
+	if len(values) == 0 {
+		return false
+	}
+
+	for _, v := range values {
+		if containsFold(v, field.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchAnyHeader(msg *mailer.Message, s string) bool {
+	for key := range msg.Header {
+		if matchHeader(msg, imap.SearchCriteriaHeaderField{Key: key, Value: s}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerValues returns values of message header field. Values of
+// fields parsed on retrieval are taken in decoded form, as
+// raw ones may be encoded with RFC 2047 encoded-words.
+// Addresses are returned both bare and along with display name.
+func headerValues(msg *mailer.Message, key string) []string {
+	switch strings.ToLower(key) {
+	case "subject":
+		if msg.Subject != "" {
+			return []string{msg.Subject}
+		}
+	case "from":
+		return formatAddresses(msg.From)
+	case "to":
+		return formatAddresses(msg.To)
+	case "cc":
+		return formatAddresses(msg.CC)
+	case "bcc":
+		return formatAddresses(msg.BCC)
+	case "reply-to":
+		return formatAddresses(msg.ReplyTo)
+	}
+
+	return msg.Header.Values(key)
+}
+
+// formatAddresses returns every address in bare form, like "alerts@example.com",
+// followed by one with display name, like "Alerts <alerts@example.com>", if any,
+// so anchored operators match either of them.
+func formatAddresses(addresses []mailer.Address) []string {
+	values := make([]string, 0, 2*len(addresses))
+	for _, address := range addresses {
+		values = append(values, address.Address)
+		if address.Name != "" {
+			values = append(values, fmt.Sprintf("%s <%s>", address.Name, address.Address))
+		}
+	}
+
+	return values
+}
+
+// matchDateRange reports whether date is within [since, before) range,
+// comparing dates only. Zero bounds are not checked.
+func matchDateRange(t, since, before time.Time) bool {
+	date := truncateDate(t)
+
+	if !since.IsZero() && date.Before(truncateDate(since)) {
+		return false
+	}
+	if !before.IsZero() && !date.Before(truncateDate(before)) {
+		return false
+	}
+
+	return true
+}
+
+func truncateDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
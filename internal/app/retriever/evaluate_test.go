@@ -0,0 +1,181 @@
+package retriever
+
+import (
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchCriteria(t *testing.T) {
+	newMessage := func() *mailer.Message {
+		return &mailer.Message{
+			UID: 42,
+			BodyParts: []mailer.BodySegment{{
+				MIMEType: "text/plain",
+				Body:     strings.NewReader("Backup job failed on db-1"),
+			}},
+			Subject: "[ALERT] Backup failed",
+			From:    []mailer.Address{{Name: "Monitoring", Address: "alerts@example.com"}},
+			To:      []mailer.Address{{Address: "oncall@example.com"}},
+			Date:    time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC),
+			Header: textproto.MIMEHeader{
+				"List-Id": {"<alerts.example.com>"},
+				"Date":    {"Mon, 10 Mar 2025 22:30:00 +0000"},
+			},
+			Flags:        []string{mailer.FlagSeen, mailer.FlagFlagged, "$Label1"},
+			InternalDate: time.Date(2025, time.March, 10, 22, 31, 0, 0, time.UTC),
+			Size:         2048,
+		}
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: "SEEN", want: true},
+		{filter: "UNSEEN", want: false},
+		{filter: "FLAGGED && !JUNK", want: true},
+		{filter: "SUBJECT == 'alert'", want: true},
+		{filter: "SUBJECT != 'alert'", want: false},
+		{filter: "FROM == 'monitoring <alerts@'", want: true},
+		{filter: "TO == 'someone@example.com' || LIST-ID == 'alerts.example.com'", want: true},
+		{filter: "X-MISSING == ''", want: false},
+		{filter: "BODY == 'db-1' && TEXT == 'backup'", want: true},
+		{filter: "BODY == 'db-2'", want: false},
+		{filter: "TEXT == 'alerts.example.com'", want: true},
+		{filter: `SUBJECT ~= '^\[ALERT\] (backup|disk)'`, want: true},
+		{filter: `SUBJECT ~= '^\[alert\]'s`, want: false},
+		{filter: `SUBJECT *= '*backup FAILED'`, want: true},
+		{filter: `SUBJECT *= '*backup'`, want: false},
+		{filter: `FROM ^= 'monitoring'`, want: true},
+		{filter: `FROM $= 'example.com>'`, want: true},
+		{filter: `FROM $= 'example.com'`, want: true},
+		{filter: `FROM ^= 'alerts@'`, want: true},
+		{filter: `FROM *= '*@example.com'`, want: true},
+		{filter: `FROM ~= '@example\.com$'`, want: true},
+		{filter: `FROM ~= '^Monitoring <'`, want: true},
+		{filter: `!(FROM ~= '^alerts@example\.com$')`, want: false},
+		{filter: `SUBJECT == 'alert's`, want: false},
+		{filter: `SUBJECT != 'alert's`, want: true},
+		{filter: `BODY ~= 'db-[0-9]+$' && TEXT ^= 'backup'`, want: true},
+		{filter: `!(LIST-ID *= '<*.example.org>')`, want: true},
+		{filter: "SIZE > 2kB && SIZE <= 3kB", want: true},
+		{filter: "LARGER 3kB", want: false},
+		{filter: "DATE == '2025-03-10' && SENTDATE < '2025-03-10T22:31:00Z'", want: true},
+		{filter: "SENTBEFORE '2025-03-10'", want: false},
+		{filter: "AGE < 2h", want: true},
+		{filter: "AGE >= 1d", want: false},
+		{filter: "KEYWORD == '$label1' && HASFLAG '\\Seen'", want: true},
+		{filter: "KEYWORD != '$Label1' || HASFLAG $MDNSent", want: false},
+		{filter: "HEADER 'Date' == 'mon' && HEADER 'Date' ~= '2025 22:'", want: true},
+		{filter: "HEADER 'Body' == 'backup'", want: false},
+	}
+
+	timeNow = func() time.Time {
+		return time.Date(2025, time.March, 11, 0, 0, 0, 0, time.UTC)
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filter)
+			require.NoError(t, err)
+
+			msg := newMessage()
+			got, err := filter.Match(msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got, "filter %q", tt.filter)
+
+			// Body must remain readable for forwarders.
+			b, err := io.ReadAll(msg.BodyParts[0].Body)
+			require.NoError(t, err)
+			assert.Equal(t, "Backup job failed on db-1", string(b))
+		})
+	}
+}
+
+func TestFilterExplain(t *testing.T) {
+	msg := &mailer.Message{
+		Subject: "[prod] Disk full",
+		Flags:   []string{mailer.FlagSeen},
+	}
+
+	tests := []struct {
+		filter  string
+		want    bool
+		reasons []string
+	}{
+		{
+			filter:  "SUBJECT == 'prod' && SEEN",
+			want:    true,
+			reasons: []string{`(SUBJECT == "prod") is true`, "(FLAG SEEN) is true"},
+		},
+		{
+			filter:  "SUBJECT == 'stage' && SEEN",
+			want:    false,
+			reasons: []string{`(SUBJECT == "stage") is false`},
+		},
+		{
+			filter:  "SUBJECT == 'stage' || !(SUBJECT $= 'full')",
+			want:    false,
+			reasons: []string{`(SUBJECT == "stage") is false`, `(SUBJECT $= "full") is true`},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filter)
+			require.NoError(t, err)
+
+			got, reasons, err := filter.Explain(msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.reasons, reasons)
+		})
+	}
+}
+
+func TestMatchCriteriaNonStringFields(t *testing.T) {
+	msg := &mailer.Message{
+		UID:          42,
+		Date:         time.Date(2025, time.March, 10, 23, 59, 0, 0, time.UTC),
+		InternalDate: time.Date(2025, time.March, 11, 0, 1, 0, 0, time.UTC),
+		Size:         2048,
+		ModSeq:       10,
+	}
+
+	tests := []struct {
+		criteria imap.SearchCriteria
+		want     bool
+	}{
+		{criteria: imap.SearchCriteria{UID: []imap.UIDSet{{imap.UIDRange{Start: 40}}}}, want: true},
+		{criteria: imap.SearchCriteria{UID: []imap.UIDSet{{imap.UIDRange{Start: 43}}}}, want: false},
+		{criteria: imap.SearchCriteria{Since: time.Date(2025, time.March, 11, 12, 0, 0, 0, time.UTC)}, want: true},
+		{criteria: imap.SearchCriteria{Before: time.Date(2025, time.March, 11, 0, 0, 0, 0, time.UTC)}, want: false},
+		{criteria: imap.SearchCriteria{SentBefore: time.Date(2025, time.March, 11, 0, 0, 0, 0, time.UTC)}, want: true},
+		{criteria: imap.SearchCriteria{SentSince: time.Date(2025, time.March, 11, 0, 0, 0, 0, time.UTC)}, want: false},
+		{criteria: imap.SearchCriteria{Larger: 1024, Smaller: 4096}, want: true},
+		{criteria: imap.SearchCriteria{Larger: 2048}, want: false},
+		{criteria: imap.SearchCriteria{ModSeq: &imap.SearchCriteriaModSeq{ModSeq: 11}}, want: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			m := criteriaMatcher{message: msg}
+			got, err := m.match(&tt.criteria)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
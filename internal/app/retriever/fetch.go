@@ -0,0 +1,409 @@
+package retriever
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	netmail "net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// Message header section, fetched along with message
+// structure prior to fetching any of message parts.
+var headerSection = &imap.FetchItemBodySection{
+	Specifier: imap.PartSpecifierHeader,
+	Peek:      true,
+}
+
+// metadataFetchOptions are used to retrieve everything except
+// message content, which is then fetched selectively by parts.
+var metadataFetchOptions = &imap.FetchOptions{
+	BodyStructure: &imap.FetchItemBodyStructure{Extended: true},
+	Flags:         true,
+	InternalDate:  true,
+	RFC822Size:    true,
+	UID:           true,
+	BodySection:   []*imap.FetchItemBodySection{headerSection},
+	ModSeq:        true,
+}
+
+// Maximum nesting level of embedded messages to be parsed,
+// deeper ones are ignored.
+const maxEmbeddedDepth = 3
+
+// partFetchPlan describes single message part to be fetched.
+type partFetchPlan struct {
+	path       []int
+	part       *imap.BodyStructureSinglePart
+	attachment bool
+	// Whether header of message embedded
+	// into 'message/rfc822' part is fetched.
+	embedded bool
+	// Path of embedded message part belongs to,
+	// nil for top-level message parts.
+	owner []int
+}
+
+// fetchMessage retrieves message content using metadata fetched previously.
+//
+// Only text parts required for notification rendering and attachments
+// matching client's attachments policy are downloaded, so large
+// attachments are never transferred at all. If server did not provide
+// message structure, the whole message is fetched instead.
+func (r *imapRetriever) fetchMessage(
+	c *imapclient.Client,
+	buf *imapclient.FetchMessageBuffer,
+	cfg config.ClientConfig,
+) (*mailer.Message, error) {
+	var message *mailer.Message
+
+	if buf.BodyStructure == nil {
+		var err error
+		message, err = r.fetchWholeMessage(c, buf.UID, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("fetch whole message: %w", err)
+		}
+	} else {
+		message = &mailer.Message{UID: uint32(buf.UID)}
+
+		header, err := readHeader(buf.FindBodySection(headerSection))
+		if err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		parseMessageHeader(message, header)
+		message.OmittedAttachments = omittedAttachments(buf.BodyStructure, cfg)
+
+		if err = r.fetchParts(c, message, selectParts(buf.BodyStructure, cfg), cfg); err != nil {
+			_ = message.Close()
+			return nil, fmt.Errorf("fetch parts: %w", err)
+		}
+	}
+
+	message.Flags = make([]string, 0, len(buf.Flags))
+	for _, flag := range buf.Flags {
+		message.Flags = append(message.Flags, string(flag))
+	}
+	message.InternalDate = buf.InternalDate
+	message.Size = buf.RFC822Size
+	message.ModSeq = buf.ModSeq
+	message.Authentication = parseAuthenticationResults(message.AuthenticationResults, cfg.TrustedAuthServIDs)
+
+	return message, nil
+}
+
+func (r *imapRetriever) fetchWholeMessage(c *imapclient.Client, uid imap.UID, cfg config.ClientConfig) (*mailer.Message, error) {
+	fetchCmd := c.Fetch(imap.UIDSetNum(uid), fetchOptions)
+	defer func() {
+		_ = fetchCmd.Close()
+	}()
+
+	msg := fetchCmd.Next()
+	if msg == nil {
+		return nil, errors.New("message not found")
+	}
+
+	return parseMessage(msg, cfg, r.spooler)
+}
+
+// fetchParts downloads planned message parts in a single command,
+// streaming every part content directly into spool.
+func (r *imapRetriever) fetchParts(
+	c *imapclient.Client,
+	message *mailer.Message,
+	plans []partFetchPlan,
+	cfg config.ClientConfig,
+) error {
+	if len(plans) == 0 {
+		return nil
+	}
+
+	// Embedded messages are created beforehand, as server
+	// may return their headers after their parts.
+	messages := map[string]*mailer.Message{"": message}
+
+	options := &imap.FetchOptions{UID: true}
+	for _, plan := range plans {
+		section := &imap.FetchItemBodySection{Part: plan.path, Peek: true}
+
+		if plan.embedded {
+			section.Specifier = imap.PartSpecifierHeader
+
+			embedded := &mailer.Message{}
+			parent := messages[partPath(plan.owner)]
+			parent.Embedded = append(parent.Embedded, embedded)
+			messages[partPath(plan.path)] = embedded
+		}
+
+		options.BodySection = append(options.BodySection, section)
+	}
+
+	fetchCmd := c.Fetch(imap.UIDSetNum(imap.UID(message.UID)), options)
+	defer func() {
+		_ = fetchCmd.Close()
+	}()
+
+	msg := fetchCmd.Next()
+	if msg == nil {
+		return errors.New("message not found")
+	}
+
+	for {
+		item := msg.Next()
+		if item == nil {
+			break
+		}
+
+		section, ok := item.(imapclient.FetchItemDataBodySection)
+		if !ok || section.Section == nil || section.Literal == nil {
+			continue
+		}
+
+		idx := slices.IndexFunc(plans, func(plan partFetchPlan) bool {
+			return slices.Equal(plan.path, section.Section.Part) &&
+				plan.embedded == (section.Section.Specifier == imap.PartSpecifierHeader)
+		})
+		if idx == -1 {
+			continue
+		}
+		plan := plans[idx]
+
+		if plan.embedded {
+			b, err := io.ReadAll(section.Literal)
+			if err != nil {
+				return fmt.Errorf("read part %s header: %w", partPath(plan.path), err)
+			}
+
+			header, err := readHeader(b)
+			if err != nil {
+				return fmt.Errorf("read part %s header: %w", partPath(plan.path), err)
+			}
+
+			parseMessageHeader(messages[partPath(plan.path)], header)
+			continue
+		}
+
+		if err := r.parsePart(messages[partPath(plan.owner)], plan, section.Literal, cfg); err != nil {
+			return fmt.Errorf("parse part %s: %w", partPath(plan.path), err)
+		}
+	}
+
+	return fetchCmd.Close()
+}
+
+// parsePart decodes fetched part content according to its
+// transfer encoding and charset and adds it to message.
+func (r *imapRetriever) parsePart(msg *mailer.Message, plan partFetchPlan, literal io.Reader, cfg config.ClientConfig) error {
+	header := partHeader(plan.part)
+
+	entity, err := message.New(header, literal)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return fmt.Errorf("create entity: %w", err)
+	}
+
+	maxSize := int64(-1)
+	if plan.attachment {
+		maxSize = int64(cfg.MaximumAttachmentsSize)
+	}
+
+	var segment mailer.BodySegment
+	segment.MIMEType = plan.part.MediaType()
+	segment.MIMETypeParams = plan.part.Params
+
+	body := entity.Body
+	if !plan.attachment && strings.HasPrefix(segment.MIMEType, "text/") {
+		body, segment.Charset = decodeText(body, plan.part.Params["charset"])
+	}
+
+	segment.Body, segment.Size, err = r.spooler.spool(body, maxSize)
+	if errors.Is(err, errPartTooLarge) {
+		// Declared size was not precise enough.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+
+	if !plan.attachment {
+		msg.BodyParts = append(msg.BodyParts, segment)
+		return nil
+	}
+
+	attachment := newAttachment(plan.part)
+	attachment.BodySegment = segment
+
+	msg.Attachments = append(msg.Attachments, attachment)
+	return nil
+}
+
+// newAttachment returns attachment with metadata taken from part structure.
+func newAttachment(part *imap.BodyStructureSinglePart) mailer.Attachment {
+	attachment := mailer.Attachment{
+		BodySegment: mailer.BodySegment{
+			MIMEType:       part.MediaType(),
+			MIMETypeParams: part.Params,
+			Size:           decodedSize(part),
+		},
+		Filename: part.Filename(),
+	}
+	if disposition := part.Disposition(); disposition != nil {
+		attachment.CreationDate = parseDispositionDate(disposition.Params["creation-date"])
+		attachment.ModificationDate = parseDispositionDate(disposition.Params["modification-date"])
+		attachment.ReadDate = parseDispositionDate(disposition.Params["read-date"])
+	}
+
+	return attachment
+}
+
+// selectParts chooses message parts to be fetched: text parts used for
+// notification rendering and, if enabled, attachments whose size reported
+// by server does not exceed configured maximum. Messages embedded into
+// 'message/rfc822' parts (forwarded ones, for example) are selected
+// recursively along with their own parts.
+func selectParts(bs imap.BodyStructure, cfg config.ClientConfig) []partFetchPlan {
+	var plans []partFetchPlan
+	selectEmbeddedParts(bs, nil, 0, cfg, &plans)
+
+	return plans
+}
+
+func selectEmbeddedParts(bs imap.BodyStructure, owner []int, depth int, cfg config.ClientConfig, plans *[]partFetchPlan) {
+	bs.Walk(func(path []int, part imap.BodyStructure) bool {
+		single, ok := part.(*imap.BodyStructureSinglePart)
+		if !ok {
+			return true
+		}
+
+		// Part paths of embedded message are
+		// prefixed with path of its own part.
+		path = append(slices.Clone(owner), path...)
+
+		if single.MessageRFC822 != nil && single.MessageRFC822.BodyStructure != nil {
+			if depth < maxEmbeddedDepth {
+				*plans = append(*plans, partFetchPlan{path: path, part: single, embedded: true, owner: owner})
+				selectEmbeddedParts(single.MessageRFC822.BodyStructure, path, depth+1, cfg, plans)
+			}
+
+			return true
+		}
+
+		if !isAttachmentPart(single) {
+			mediaType := single.MediaType()
+			if mediaType == "text/plain" || mediaType == "text/html" {
+				*plans = append(*plans, partFetchPlan{path: path, part: single, owner: owner})
+			}
+
+			return true
+		}
+
+		if isRetrievedAttachment(single, cfg) {
+			*plans = append(*plans, partFetchPlan{path: path, part: single, attachment: true, owner: owner})
+		}
+
+		return true
+	})
+}
+
+// omittedAttachments returns metadata of message attachments
+// not retrieved according to client attachments policy.
+// Attachments of embedded messages are not included.
+func omittedAttachments(bs imap.BodyStructure, cfg config.ClientConfig) []mailer.Attachment {
+	var attachments []mailer.Attachment
+
+	bs.Walk(func(_ []int, part imap.BodyStructure) bool {
+		single, ok := part.(*imap.BodyStructureSinglePart)
+		if !ok || single.MessageRFC822 != nil {
+			return true
+		}
+
+		if isAttachmentPart(single) && !isRetrievedAttachment(single, cfg) {
+			attachments = append(attachments, newAttachment(single))
+		}
+
+		return true
+	})
+
+	return attachments
+}
+
+func isRetrievedAttachment(part *imap.BodyStructureSinglePart, cfg config.ClientConfig) bool {
+	return cfg.IncludeAttachments && decodedSize(part) <= int64(cfg.MaximumAttachmentsSize)
+}
+
+func isAttachmentPart(part *imap.BodyStructureSinglePart) bool {
+	if disposition := part.Disposition(); disposition != nil {
+		return strings.EqualFold(disposition.Value, "attachment")
+	}
+
+	// Parts without disposition, but with file name,
+	// are treated as attachments by most email clients.
+	return part.Filename() != "" && part.Text == nil
+}
+
+// decodedSize estimates part content size after decoding
+// its transfer encoding from size of encoded content.
+func decodedSize(part *imap.BodyStructureSinglePart) int64 {
+	size := int64(part.Size)
+	if strings.EqualFold(part.Encoding, "base64") {
+		return size * 3 / 4
+	}
+
+	return size
+}
+
+// partHeader restores part MIME header from its structure,
+// which is enough to decode fetched part content.
+func partHeader(part *imap.BodyStructureSinglePart) message.Header {
+	var h message.Header
+
+	h.SetContentType(part.MediaType(), part.Params)
+	if part.Encoding != "" {
+		h.Set("Content-Transfer-Encoding", part.Encoding)
+	}
+	if disposition := part.Disposition(); disposition != nil {
+		h.SetContentDisposition(disposition.Value, disposition.Params)
+	}
+
+	return h
+}
+
+func readHeader(b []byte) (mail.Header, error) {
+	entity, err := message.Read(strings.NewReader(string(b)))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return mail.Header{}, err
+	}
+
+	return mail.Header{Header: entity.Header}, nil
+}
+
+func parseDispositionDate(v string) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+
+	t, err := netmail.ParseDate(v)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+func partPath(path []int) string {
+	parts := make([]string, 0, len(path))
+	for _, p := range path {
+		parts = append(parts, strconv.Itoa(p))
+	}
+
+	return strings.Join(parts, ".")
+}
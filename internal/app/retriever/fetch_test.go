@@ -0,0 +1,172 @@
+package retriever
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+	"github.com/hickar/chatmailer/internal/pkg/units"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectParts(t *testing.T) {
+	bs := &imap.BodyStructureMultiPart{
+		Subtype: "mixed",
+		Children: []imap.BodyStructure{
+			&imap.BodyStructureMultiPart{
+				Subtype: "alternative",
+				Children: []imap.BodyStructure{
+					&imap.BodyStructureSinglePart{Type: "text", Subtype: "plain", Text: &imap.BodyStructureText{}},
+					&imap.BodyStructureSinglePart{Type: "text", Subtype: "html", Text: &imap.BodyStructureText{}},
+				},
+			},
+			&imap.BodyStructureSinglePart{
+				Type: "image", Subtype: "png",
+				Params: map[string]string{"name": "logo.png"},
+				Extended: &imap.BodyStructureSinglePartExt{
+					Disposition: &imap.BodyStructureDisposition{Value: "inline"},
+				},
+			},
+			&imap.BodyStructureSinglePart{
+				Type: "application", Subtype: "pdf",
+				Encoding: "base64",
+				Size:     1000,
+				Extended: &imap.BodyStructureSinglePartExt{
+					Disposition: &imap.BodyStructureDisposition{
+						Value:  "attachment",
+						Params: map[string]string{"filename": "small.pdf"},
+					},
+				},
+			},
+			&imap.BodyStructureSinglePart{
+				Type: "application", Subtype: "zip",
+				Params:   map[string]string{"name": "huge.zip"},
+				Encoding: "base64",
+				Size:     200 * units.MB,
+			},
+		},
+	}
+
+	paths := func(plans []partFetchPlan) [][]int {
+		var result [][]int
+		for _, plan := range plans {
+			result = append(result, plan.path)
+		}
+		return result
+	}
+
+	plans := selectParts(bs, config.ClientConfig{})
+	assert.Equal(t, [][]int{{1, 1}, {1, 2}}, paths(plans))
+
+	plans = selectParts(bs, config.ClientConfig{
+		IncludeAttachments:     true,
+		MaximumAttachmentsSize: 50 * units.MB,
+	})
+	require.Equal(t, [][]int{{1, 1}, {1, 2}, {3}}, paths(plans))
+	assert.True(t, plans[2].attachment)
+
+	names := func(attachments []mailer.Attachment) []string {
+		var result []string
+		for _, attachment := range attachments {
+			result = append(result, attachment.Filename)
+		}
+		return result
+	}
+
+	omitted := omittedAttachments(bs, config.ClientConfig{})
+	assert.Equal(t, []string{"small.pdf", "huge.zip"}, names(omitted))
+	assert.Equal(t, "application/zip", omitted[1].MIMEType)
+	assert.Equal(t, int64(150*units.MB), omitted[1].Size)
+
+	omitted = omittedAttachments(bs, config.ClientConfig{
+		IncludeAttachments:     true,
+		MaximumAttachmentsSize: 50 * units.MB,
+	})
+	assert.Equal(t, []string{"huge.zip"}, names(omitted))
+}
+
+func TestParsePart(t *testing.T) {
+	r := NewIMAPRetriever(nil, config.SpoolConfiguration{}, nil)
+	cfg := config.ClientConfig{IncludeAttachments: true, MaximumAttachmentsSize: 1 * units.KB}
+
+	var msg mailer.Message
+
+	err := r.parsePart(&msg, partFetchPlan{
+		path: []int{1},
+		part: &imap.BodyStructureSinglePart{
+			Type: "text", Subtype: "plain",
+			Params:   map[string]string{"charset": "iso-8859-1"},
+			Encoding: "quoted-printable",
+		},
+	}, strings.NewReader("caf=E9 cr=E8me"), cfg)
+	require.NoError(t, err)
+
+	err = r.parsePart(&msg, partFetchPlan{
+		path: []int{2},
+		part: &imap.BodyStructureSinglePart{
+			Type: "application", Subtype: "pdf",
+			Encoding: "base64",
+			Extended: &imap.BodyStructureSinglePartExt{
+				Disposition: &imap.BodyStructureDisposition{
+					Value: "attachment",
+					Params: map[string]string{
+						"filename":      "report.pdf",
+						"creation-date": "Mon, 10 Mar 2025 22:30:00 +0000",
+					},
+				},
+			},
+		},
+		attachment: true,
+	}, strings.NewReader("JVBERi0xLjQ="), cfg)
+	require.NoError(t, err)
+
+	require.Len(t, msg.BodyParts, 1)
+	b, err := io.ReadAll(msg.BodyParts[0].Body)
+	require.NoError(t, err)
+	assert.Equal(t, "café crème", string(b))
+
+	require.Len(t, msg.Attachments, 1)
+	attachment := msg.Attachments[0]
+	assert.Equal(t, "report.pdf", attachment.Filename)
+	assert.Equal(t, int64(8), attachment.Size)
+	assert.Equal(t, 2025, attachment.CreationDate.Year())
+}
+
+func TestSelectEmbeddedParts(t *testing.T) {
+	forwarded := &imap.BodyStructureMultiPart{
+		Subtype: "alternative",
+		Children: []imap.BodyStructure{
+			&imap.BodyStructureSinglePart{Type: "text", Subtype: "plain", Text: &imap.BodyStructureText{}},
+			&imap.BodyStructureSinglePart{Type: "text", Subtype: "html", Text: &imap.BodyStructureText{}},
+		},
+	}
+	bs := &imap.BodyStructureMultiPart{
+		Subtype: "mixed",
+		Children: []imap.BodyStructure{
+			&imap.BodyStructureSinglePart{Type: "text", Subtype: "plain", Text: &imap.BodyStructureText{}},
+			&imap.BodyStructureSinglePart{
+				Type: "message", Subtype: "rfc822",
+				MessageRFC822: &imap.BodyStructureMessageRFC822{BodyStructure: forwarded},
+			},
+		},
+	}
+
+	plans := selectParts(bs, config.ClientConfig{})
+	require.Len(t, plans, 4)
+
+	assert.Equal(t, []int{1}, plans[0].path)
+	assert.Nil(t, plans[0].owner)
+
+	assert.Equal(t, []int{2}, plans[1].path)
+	assert.True(t, plans[1].embedded)
+
+	assert.Equal(t, []int{2, 1}, plans[2].path)
+	assert.Equal(t, []int{2}, plans[2].owner)
+	assert.Equal(t, []int{2, 2}, plans[3].path)
+	assert.Equal(t, []int{2}, plans[3].owner)
+}
@@ -3,324 +3,218 @@ package retriever
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
-	"unicode"
+	"sync"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
 
 	"github.com/emersion/go-imap/v2"
 )
 
-// ParseFilter creates *imap.SearchCriteria for filtering messages by parsing provided logical expression.
-// Parameters:
+// Filter is parsed filter expression, which can be either sent
+// to IMAP server as search criteria or evaluated on client side.
+type Filter struct {
+	root filterNode
+}
+
+// CompileFilter parses filter expression.
+//
+// Expression consists of:
 //
-// - filterExpr (string): The filter expression to parse. It supports a subset of IMAP SEARCH syntax, allowing users to specify criteria based on:
 //   - Flags: JUNK, SEEN, UNSEEN, DRAFT, UNDRAFT, DELETED, UNDELETED, FLAGGED, UNFLAGGED, PHISHING, WILDCARD, FORWARDED, IMPORTANT, ANSWERED, UNANSWERED
-//   - Header fields: FROM, TO, SUBJECT, and others
-//   - Message body text: BODY, TEXT
-//   - Comparisons: ==, !=
-//   - Logical operators: && (AND), || (OR), ! (NOT)
+//   - Header fields comparisons: FROM, TO, SUBJECT and any other header field names
+//   - Message body text comparisons: BODY, TEXT
+//   - Comparison operators: == and != (substring match), ~= (regular expression match),
+//     *= (glob pattern match), ^= (prefix match), $= (suffix match)
+//   - Case sensitivity modifiers following compared string: 's' for case-sensitive
+//     and 'i' for case-insensitive (default) comparison, like 'ALERT's
+//   - Size and date comparisons: SIZE, DATE (internal date), SENTDATE ('Date' header)
+//     and AGE (time elapsed since message was received) compared with ==, <, <=, >, >=
+//     operators, like "SIZE > 1M", "DATE >= '2025-01-01'" or "AGE < 2h"
+//   - Size and date predicates: LARGER, SMALLER, SINCE, BEFORE, SENTSINCE, SENTBEFORE,
+//     like "SENTBEFORE '2025-01-01'"
+//   - Attachment predicates: HAS ATTACHMENT, ATTACHMENT.NAME (file name) and ATTACHMENT.TYPE
+//     (media type) compared with the same operators as header fields and ATTACHMENT.SIZE compared
+//     as SIZE, like "ATTACHMENT.NAME ~= '\.pdf$'" or "ATTACHMENT.SIZE > 5M". Attachment fields are
+//     matched by messages having any attachment satisfying comparison
+//   - Flags and keywords comparisons: KEYWORD compared with == and != operators, like
+//     "KEYWORD == '$Label1'", and HASFLAG predicate, like "HASFLAG '$MDNSent'"
+//   - Gmail extensions comparisons, for servers advertising X-GM-EXT-1 capability: X-GM-LABELS
+//     (message label) and X-GM-RAW (Gmail search query) compared with == and != operators,
+//     like "X-GM-LABELS == 'Alerts'" or "X-GM-RAW == 'has:attachment larger:1M'"
+//   - Sender authentication comparisons: DKIM, SPF and DMARC results (RFC 8601), like 'pass',
+//     'fail' or 'none', compared with == and != operators, like "DKIM == 'pass'" or
+//     "DMARC != 'fail'". Results are taken from trusted 'Authentication-Results' headers or,
+//     for DKIM, from verification on retrieval. Unknown results are compared as 'none'
+//   - Logical operators: ! (NOT), && (AND), || (OR), in order of decreasing precedence
 //   - Grouping with parentheses: ( )
 //
-// Returns:
-//
-// - *imap.SearchCriteria: A pointer to an `imap.SearchCriteria` struct representing the parsed filter, or nil if the expression is invalid.
-// - error: An error if the filter expression is malformed or could not be parsed.
-//
 // Examples:
 //
-// - Find unread messages from a specific sender: "FROM <email address removed> UNSEEN"
-// - Find flagged messages with "important" in the subject: "FLAGGED SUBJECT == 'important'"
-// - Find messages containing the word "urgent" in the body: "BODY CONTAINS 'urgent'"
-// - Combine multiple criteria: "(FROM <email address removed> OR TO 'someone@example.com') AND SEEN"
+//   - Find unread messages from a specific sender: "FROM == 'alerts@example.com' && UNSEEN"
+//   - Find flagged messages with "important" in the subject: "FLAGGED && SUBJECT == 'important'"
+//   - Find messages containing the word "urgent" in the body: "BODY == 'urgent'"
+//   - Combine multiple criteria: "(FROM == 'alerts@example.com' || TO == 'someone@example.com') && SEEN"
+//   - Find production alerts: "SUBJECT ~= '^\[ALERT\] (prod|stage)'"
+//   - Skip huge newsletters and stale messages: "SIZE < 5M && AGE < 1d"
 //
-// Supported Syntax:
+// Substring and flag comparisons are performed by IMAP server, others are evaluated on
+// client side, with server narrowing messages down by their literal parts. Gmail
+// extensions are searched for separately, as they can not be combined with others.
 //
-// - Flags: See list under "Parameters".
-// - Header fields: Any valid IMAP header field name.
-// - Message body keywords: BODY or TEXT.
-// - Comparison operators: ==, !=.
-// - Logical operators: && (AND), || (OR), ! (NOT).
-// - Grouping with parentheses: ( ).
-//
-// Errors:
-//
-// - Returns an error if the filter expression is malformed or uses unsupported syntax.
+// Syntax errors are reported as [*FilterError] with position of offending token.
+func CompileFilter(expr string) (*Filter, error) {
+	root, err := parseFilterExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{root: root}, nil
+}
+
+// ParseFilter creates *imap.SearchCriteria for filtering messages by parsing provided
+// filter expression. See [CompileFilter] for expression syntax description.
 //
 // See Also:
 //
 // - imap.SearchCriteria: https://pkg.go.dev/github.com/emersion/go-imap/v2#SearchCriteria
-func ParseFilter(filterExpr string) (*imap.SearchCriteria, error) {
-	criteria, _, err := parseFilterExpression([]rune(filterExpr), 0)
-	return criteria, err
-}
-
-/*
-	Parser syntax
-
-	Expression:
-		Expression || Term
-		Term
-
-	Term:
-		Term && Expression
-		Primary
-
-	Primary:
-		FlagToken
-		HeaderToken == String
-		HeaderToken != String
-		MsgToken == String
-		MsgToken != String
-		!Primary
-		( Expression )
-*/
-
-func parseFilterExpression(filterExpr []rune, i int) (*imap.SearchCriteria, int, error) {
-	var criteria *imap.SearchCriteria
-	var err error
-
-	criteria, i, err = parseFilterTerm(filterExpr, i)
+func ParseFilter(expr string) (*imap.SearchCriteria, error) {
+	filter, err := CompileFilter(expr)
 	if err != nil {
-		return nil, i, err
+		return nil, err
 	}
 
-	var (
-		t      *imap.SearchCriteria
-		opFunc filterBoolFunc
-	)
-
-	for i < len(filterExpr) {
-		c := filterExpr[i]
-
-		switch {
-		case unicode.IsSpace(c):
-			i++
-
-		case c == '|':
-			opFunc, i, err = parseFilterBoolOp(filterExpr, i+1, '|')
-			if err != nil {
-				return nil, i, err
-			}
-
-			t, i, err = parseFilterTerm(filterExpr, i)
-			if err != nil {
-				return nil, i, err
-			}
-
-			criteria = opFunc(criteria, t)
-
-		default:
-			return criteria, i + 1, nil
-		}
-	}
+	return filter.Criteria(), nil
+}
 
-	return criteria, i, nil
+// Criteria returns IMAP search criteria equivalent to filter. If filter
+// uses conditions IMAP SEARCH is not able to express, criteria select
+// superset of matching messages, see [Filter.Exact].
+func (f *Filter) Criteria() *imap.SearchCriteria {
+	criteria, _ := f.root.criteria()
+	return criteria
 }
 
-func parseFilterTerm(filterExpr []rune, i int) (*imap.SearchCriteria, int, error) {
-	var criteria *imap.SearchCriteria
-	var err error
+// Exact reports whether messages selected by criteria returned from
+// [Filter.Criteria] match filter exactly. Otherwise, they have to
+// be checked with [Filter.Match] after retrieval.
+func (f *Filter) Exact() bool {
+	_, exact := f.root.criteria()
+	return exact
+}
 
-	criteria, i, err = parseFilterPrimary(filterExpr, i)
-	if err != nil {
-		return nil, i, err
-	}
+// Match evaluates filter against retrieved message. Message body
+// parts, if read, are rewound afterwards.
+func (f *Filter) Match(message *mailer.Message) (bool, error) {
+	return f.root.eval(&criteriaMatcher{message: message})
+}
 
-	var (
-		t      *imap.SearchCriteria
-		opFunc filterBoolFunc
-	)
+// Explain evaluates filter against retrieved message like [Filter.Match] does,
+// additionally returning results of conditions determining the outcome,
+// like `(SUBJECT == "prod") is true`.
+func (f *Filter) Explain(message *mailer.Message) (bool, []string, error) {
+	return explainNode(f.root, &criteriaMatcher{message: message})
+}
 
-	for i < len(filterExpr) {
-		c := filterExpr[i]
+// gmailQueries returns Gmail extension searches, results of
+// which are required to match messages against filter.
+func (f *Filter) gmailQueries() []gmailQuery {
+	return gmailQueries(f.root)
+}
 
-		switch {
-		case unicode.IsSpace(c):
-			i++
+// String returns filter syntax tree in S-expression form, like
+// "(AND (FLAG SEEN) (SUBJECT == "alert"))".
+func (f *Filter) String() string {
+	return f.root.String()
+}
 
-		case c == '&':
-			opFunc, i, err = parseFilterBoolOp(filterExpr, i+1, '&')
-			if err != nil {
-				return nil, i, err
-			}
+// FilterMatcher matches retrieved messages against filter
+// expressions, like ones of contact points, compiling each once.
+type FilterMatcher struct {
+	mu      sync.Mutex
+	filters map[string]*Filter
+}
 
-			t, i, err = parseFilterExpression(filterExpr, i)
-			if err != nil {
-				return nil, i, err
+// NewFilterMatcher creates FilterMatcher with filters of clients
+// contact points, including digest urgent filters, compiled,
+// reporting invalid ones.
+func NewFilterMatcher(clients []config.ClientConfig) (*FilterMatcher, error) {
+	m := &FilterMatcher{filters: make(map[string]*Filter)}
+
+	for _, client := range clients {
+		for _, contact := range client.ContactPoints {
+			for _, expr := range slices.Concat(contact.Filters, contact.Digest.UrgentFilters) {
+				if _, err := m.compile(expr); err != nil {
+					return nil, fmt.Errorf("client %q: %w", client.Login, err)
+				}
 			}
-
-			criteria = opFunc(criteria, t)
-			i++
-
-		default:
-			return criteria, i, nil
 		}
 	}
 
-	return criteria, i, nil
+	return m, nil
 }
 
-func parseFilterPrimary(filterExpr []rune, i int) (*imap.SearchCriteria, int, error) {
-	var criteria *imap.SearchCriteria
-	var t *imap.SearchCriteria
-	var err error
-
-parseLoop:
-	for i < len(filterExpr) {
-		c := filterExpr[i]
-
-		switch {
-		case unicode.IsSpace(c):
-			i++
-
-		case c == '!':
-			criteria = &imap.SearchCriteria{}
-			t, i, err = parseFilterPrimary(filterExpr, i+1)
-			if err != nil {
-				return nil, i, err
+// ValidateFilters checks filters of clients and their contact points,
+// including digest urgent filters. Returned error joins errors of every
+// invalid filter, unlike NewFilterMatcher, which reports the first one.
+func ValidateFilters(clients []config.ClientConfig) error {
+	var errs []error
+
+	m := &FilterMatcher{filters: make(map[string]*Filter)}
+	for _, client := range clients {
+		for _, expr := range client.Filters {
+			if _, err := compileFilters([]string{expr}); err != nil {
+				errs = append(errs, fmt.Errorf("client %q: %w", client.Login, err))
 			}
-
-			criteria.Not = append(criteria.Not, *t)
-			return criteria, i, nil
-
-		case c == '(':
-			return parseFilterExpression(filterExpr, i+1)
-
-		default:
-			break parseLoop
 		}
-	}
-
-	var (
-		opFunc filterCmpFunc
-		t1, t2 string
-	)
-
-	t1, i = parseFilterToken(filterExpr, i)
-	if _, ok := flagTokens[strings.ToUpper(t1)]; ok {
-		criteria = &imap.SearchCriteria{}
-		return assignFlag(criteria, strings.ToUpper(t1)), i, nil
-	}
-
-	for i < len(filterExpr) {
-		c := filterExpr[i]
-
-		switch {
-		case unicode.IsSpace(c):
-			i++
-
-		case c == '=' || c == '!':
-			opFunc, i, err = parseFilterCmpOp(filterExpr, i+1, c)
-			if err != nil {
-				return nil, i, err
-			}
-			i++
 
-			t2, i, err = parseFilterQuotedToken(filterExpr, i)
-			if err != nil {
-				return nil, i, err
+		for _, contact := range client.ContactPoints {
+			for _, expr := range slices.Concat(contact.Filters, contact.Digest.UrgentFilters) {
+				if _, err := m.compile(expr); err != nil {
+					errs = append(errs, fmt.Errorf("client %q: %w", client.Login, err))
+				}
 			}
-			criteria = &imap.SearchCriteria{}
-
-			return opFunc(criteria, t1, t2), i, nil
 		}
 	}
 
-	return criteria, i, nil
+	return errors.Join(errs...)
 }
 
-func parseFilterToken(filterExpr []rune, i int) (string, int) {
-	var sb strings.Builder
-
-	for i < len(filterExpr) {
-		c := filterExpr[i]
-
-		switch {
-		case unicode.IsLetter(c) || c == '-':
-			sb.WriteRune(c)
-			i++
-
-		default:
-			return sb.String(), i
-		}
+// Match reports whether message satisfies filter expression.
+func (m *FilterMatcher) Match(expr string, message *mailer.Message) (bool, error) {
+	filter, err := m.compile(expr)
+	if err != nil {
+		return false, err
 	}
 
-	return sb.String(), i
+	return filter.Match(message)
 }
 
-func parseFilterQuotedToken(filterExpr []rune, i int) (string, int, error) {
-	var sb strings.Builder
-	var startQuote rune
-
-	for i < len(filterExpr) {
-		c := filterExpr[i]
-
-		switch {
-		case startQuote == 0 && (c == '\'' || c == '"'):
-			startQuote = c
-			i++
-
-		case startQuote == 0 && unicode.IsSpace(c):
-			i++
-
-		case startQuote == 0:
-			return "", i, fmt.Errorf("expected starting quote but got '%c'", c)
+func (m *FilterMatcher) compile(expr string) (*Filter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		case startQuote != 0 && c != startQuote:
-			sb.WriteRune(c)
-			i++
-
-		case startQuote != 0 && c == startQuote:
-			return sb.String(), i + 1, nil
-		}
+	if filter, ok := m.filters[expr]; ok {
+		return filter, nil
 	}
 
-	if startQuote != 0 {
-		return "", i, errors.New("missing closing quote")
+	filter, err := CompileFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parse filter expression %q: %w", expr, err)
 	}
 
-	return sb.String(), i, nil
-}
-
-func parseFilterBoolOp(filterExpr []rune, i int, opChar rune) (filterBoolFunc, int, error) {
-	for i < len(filterExpr) {
-		c := filterExpr[i]
-
-		switch {
-		case c == opChar && opChar == '|':
-			return addOrCriteria, i + 1, nil
-
-		case c == opChar && opChar == '&':
-			return addAndCriteria, i + 1, nil
-
-		default:
-			return nil, i, fmt.Errorf("unexpected '%c' token while parsing '%c' bool function", c, opChar)
-		}
+	// Gmail extensions are searched for on server only.
+	if len(filter.gmailQueries()) > 0 {
+		return nil, fmt.Errorf("filter expression %q: Gmail extensions can not be evaluated on retrieved messages", expr)
 	}
 
-	return nil, i, errors.New("bool operation parsing stopped unexpectedly")
-}
-
-func parseFilterCmpOp(filterExpr []rune, i int, opChar rune) (filterCmpFunc, int, error) {
-	for i < len(filterExpr) {
-		c := filterExpr[i]
+	m.filters[expr] = filter
 
-		switch {
-		case opChar == '=' && c == '=':
-			return addEqCmpCriteriaOp, i, nil
-
-		case opChar == '!' && c == '=':
-			return addNotEqCmpCriteriaOp, i, nil
-
-		default:
-			return nil, i, fmt.Errorf("unexpected token '%c'", c)
-		}
-	}
-
-	return nil, i, errors.New("compare operation parsing stopped unexpectedly")
+	return filter, nil
 }
 
-type filterCmpFunc func(*imap.SearchCriteria, string, string) *imap.SearchCriteria
-
 func addEqCmpCriteriaOp(c *imap.SearchCriteria, k, v string) *imap.SearchCriteria {
 	if _, ok := msgTokens[k]; ok {
 		if k == "BODY" {
@@ -369,8 +263,6 @@ var msgTokens = map[string]struct{}{
 	"BODY": {},
 }
 
-type filterBoolFunc func(c1, c2 *imap.SearchCriteria) *imap.SearchCriteria
-
 func addAndCriteria(c1, c2 *imap.SearchCriteria) *imap.SearchCriteria {
 	if c1 == nil {
 		return c2
@@ -379,7 +271,15 @@ func addAndCriteria(c1, c2 *imap.SearchCriteria) *imap.SearchCriteria {
 		return c1
 	}
 
+	smaller := c1.Smaller
 	c1.And(c2)
+
+	// imap.SearchCriteria.And drops SMALLER key
+	// specified for the first criteria only.
+	if c2.Smaller == 0 {
+		c1.Smaller = smaller
+	}
+
 	return c1
 }
 
@@ -0,0 +1,447 @@
+package retriever
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// filterSpan is a range of filter expression characters [start, end).
+type filterSpan struct {
+	start, end int
+}
+
+// filterNode is a node of filter expression syntax tree.
+//
+// Every node can be compiled into IMAP search criteria sent to server,
+// as well as be evaluated against already retrieved message on client side.
+type filterNode interface {
+	span() filterSpan
+	// criteria compiles node into IMAP search criteria. Conditions which
+	// IMAP SEARCH is not able to express are compiled into criteria
+	// selecting superset of matching messages, in which case
+	// exact is false and messages must be checked with eval.
+	criteria() (criteria *imap.SearchCriteria, exact bool)
+	// eval reports whether message satisfies node condition.
+	eval(m *criteriaMatcher) (bool, error)
+	fmt.Stringer
+}
+
+// explainNode evaluates node like eval does, but without short-circuiting,
+// additionally returning results of conditions determining result.
+func explainNode(node filterNode, m *criteriaMatcher) (bool, []string, error) {
+	var (
+		children []filterNode
+		combine  func(results []bool) bool
+	)
+
+	switch n := node.(type) {
+	case *orNode:
+		children = []filterNode{n.left, n.right}
+		combine = func(results []bool) bool { return results[0] || results[1] }
+	case *andNode:
+		children = []filterNode{n.left, n.right}
+		combine = func(results []bool) bool { return results[0] && results[1] }
+	case *notNode:
+		ok, reasons, err := explainNode(n.expr, m)
+		return !ok, reasons, err
+	default:
+		ok, err := node.eval(m)
+		if err != nil {
+			return false, nil, err
+		}
+
+		return ok, []string{fmt.Sprintf("%s is %t", node, ok)}, nil
+	}
+
+	results := make([]bool, len(children))
+	reasons := make([][]string, len(children))
+	for i, child := range children {
+		var err error
+		results[i], reasons[i], err = explainNode(child, m)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	// Result is determined by children evaluated to the same
+	// value as whole node, like false ones for failed AND.
+	ok := combine(results)
+
+	var determining []string
+	for i := range children {
+		if results[i] == ok {
+			determining = append(determining, reasons[i]...)
+		}
+	}
+
+	return ok, determining, nil
+}
+
+type orNode struct {
+	left, right filterNode
+}
+
+func (n *orNode) span() filterSpan {
+	return filterSpan{n.left.span().start, n.right.span().end}
+}
+
+func (n *orNode) criteria() (*imap.SearchCriteria, bool) {
+	left, leftExact := n.left.criteria()
+	right, rightExact := n.right.criteria()
+
+	return addOrCriteria(left, right), leftExact && rightExact
+}
+
+func (n *orNode) eval(m *criteriaMatcher) (bool, error) {
+	ok, err := n.left.eval(m)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return n.right.eval(m)
+}
+
+func (n *orNode) String() string {
+	return fmt.Sprintf("(OR %s %s)", n.left, n.right)
+}
+
+type andNode struct {
+	left, right filterNode
+}
+
+func (n *andNode) span() filterSpan {
+	return filterSpan{n.left.span().start, n.right.span().end}
+}
+
+func (n *andNode) criteria() (*imap.SearchCriteria, bool) {
+	left, leftExact := n.left.criteria()
+	right, rightExact := n.right.criteria()
+
+	return addAndCriteria(left, right), leftExact && rightExact
+}
+
+func (n *andNode) eval(m *criteriaMatcher) (bool, error) {
+	ok, err := n.left.eval(m)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return n.right.eval(m)
+}
+
+func (n *andNode) String() string {
+	return fmt.Sprintf("(AND %s %s)", n.left, n.right)
+}
+
+type notNode struct {
+	pos  int
+	expr filterNode
+}
+
+func (n *notNode) span() filterSpan {
+	return filterSpan{n.pos, n.expr.span().end}
+}
+
+func (n *notNode) criteria() (*imap.SearchCriteria, bool) {
+	c, exact := n.expr.criteria()
+	if !exact {
+		// Negation of superset is not a superset of negation,
+		// so every message has to be checked on client side.
+		return &imap.SearchCriteria{}, false
+	}
+
+	return addNotCriteria(c), true
+}
+
+func (n *notNode) eval(m *criteriaMatcher) (bool, error) {
+	ok, err := n.expr.eval(m)
+	return !ok, err
+}
+
+func (n *notNode) String() string {
+	return fmt.Sprintf("(NOT %s)", n.expr)
+}
+
+// flagNode matches messages having (or not having, for "UN"-prefixed names) system flag.
+type flagNode struct {
+	name     string
+	nameSpan filterSpan
+}
+
+func (n *flagNode) span() filterSpan {
+	return n.nameSpan
+}
+
+func (n *flagNode) criteria() (*imap.SearchCriteria, bool) {
+	return assignFlag(&imap.SearchCriteria{}, n.name), true
+}
+
+func (n *flagNode) eval(m *criteriaMatcher) (bool, error) {
+	c, _ := n.criteria()
+	return m.match(c)
+}
+
+func (n *flagNode) String() string {
+	return fmt.Sprintf("(FLAG %s)", n.name)
+}
+
+type compareOp int
+
+const (
+	compareContains compareOp = iota
+	compareNotContains
+	compareRegexp
+	compareGlob
+	comparePrefix
+	compareSuffix
+)
+
+func (op compareOp) String() string {
+	switch op {
+	case compareContains:
+		return "=="
+	case compareNotContains:
+		return "!="
+	case compareRegexp:
+		return "~="
+	case compareGlob:
+		return "*="
+	case comparePrefix:
+		return "^="
+	case compareSuffix:
+		return "$="
+	}
+
+	return "?"
+}
+
+// headerKeyword introduces comparison of header field named by quoted
+// string, like HEADER 'Date' == 'Mon', for fields sharing their names
+// with keywords or special fields, like DATE or SIZE.
+const headerKeyword = "HEADER"
+
+// compareNode matches messages by header field or body content.
+type compareNode struct {
+	field         string
+	fieldSpan     filterSpan
+	op            compareOp
+	value         string
+	valueSpan     filterSpan
+	caseSensitive bool
+	// Whether field is header one regardless of its name,
+	// as it is specified after HEADER keyword.
+	header bool
+	// Compiled pattern of regular expression and glob operators.
+	re *regexp.Regexp
+}
+
+func newCompareNode(field filterToken, op compareOp, value filterToken) (*compareNode, error) {
+	n := &compareNode{
+		field:     strings.ToUpper(field.value),
+		fieldSpan: field.span,
+		op:        op,
+		value:     value.value,
+		valueSpan: value.span,
+	}
+
+	for _, modifier := range value.modifiers {
+		switch modifier {
+		case 's':
+			n.caseSensitive = true
+		case 'i':
+			n.caseSensitive = false
+		default:
+			return nil, newFilterError(value.span.start, "unknown string modifier '%c'", modifier)
+		}
+	}
+
+	var pattern string
+	switch op {
+	case compareRegexp:
+		pattern = n.value
+	case compareGlob:
+		pattern = globToRegexp(n.value)
+	default:
+		return n, nil
+	}
+
+	if !n.caseSensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, newFilterError(value.span.start, "invalid pattern: %v", err)
+	}
+	n.re = re
+
+	return n, nil
+}
+
+func (n *compareNode) span() filterSpan {
+	return filterSpan{n.fieldSpan.start, n.valueSpan.end}
+}
+
+func (n *compareNode) criteria() (*imap.SearchCriteria, bool) {
+	switch {
+	case n.op == compareContains:
+		return n.containsCriteria(n.value), !n.caseSensitive
+	case n.op == compareNotContains && !n.caseSensitive:
+		return &imap.SearchCriteria{Not: []imap.SearchCriteria{*n.containsCriteria(n.value)}}, true
+	}
+
+	// Other comparisons are narrowed down by server with
+	// case-insensitive substring search of their literal part.
+	literal := n.literal()
+	if _, ok := msgTokens[n.field]; (ok && !n.header && literal == "") || n.op == compareNotContains {
+		return &imap.SearchCriteria{}, false
+	}
+
+	return n.containsCriteria(literal), false
+}
+
+// containsCriteria returns criteria of field containing substring.
+func (n *compareNode) containsCriteria(v string) *imap.SearchCriteria {
+	if n.header {
+		return &imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: n.field, Value: v}}}
+	}
+
+	return addEqCmpCriteriaOp(&imap.SearchCriteria{}, n.field, v)
+}
+
+// literal returns substring contained in every matching value.
+func (n *compareNode) literal() string {
+	switch n.op {
+	case compareRegexp:
+		return regexpLiteral(n.value)
+	case compareGlob:
+		return longestString(strings.FieldsFunc(n.value, isGlobWildcard))
+	}
+
+	return n.value
+}
+
+func (n *compareNode) eval(m *criteriaMatcher) (bool, error) {
+	if c, exact := n.criteria(); exact {
+		return m.match(c)
+	}
+
+	values := headerValues(m.message, n.field)
+	if !n.header {
+		var err error
+		if values, err = m.fieldValues(n.field); err != nil {
+			return false, err
+		}
+	}
+
+	matched := slices.ContainsFunc(values, n.matchValue)
+	if n.op == compareNotContains {
+		return !matched, nil
+	}
+
+	return matched, nil
+}
+
+func (n *compareNode) matchValue(v string) bool {
+	if n.re != nil {
+		return n.re.MatchString(v)
+	}
+
+	value := n.value
+	if !n.caseSensitive {
+		v, value = strings.ToLower(v), strings.ToLower(value)
+	}
+
+	switch n.op {
+	case comparePrefix:
+		return strings.HasPrefix(v, value)
+	case compareSuffix:
+		return strings.HasSuffix(v, value)
+	}
+
+	return strings.Contains(v, value)
+}
+
+func (n *compareNode) String() string {
+	modifier := ""
+	if n.caseSensitive {
+		modifier = "s"
+	}
+
+	field := n.field
+	if n.header {
+		field = fmt.Sprintf("%s %q", headerKeyword, n.field)
+	}
+
+	return fmt.Sprintf("(%s %s %q%s)", field, n.op, n.value, modifier)
+}
+
+// regexpLiteral returns the longest literal string
+// which must be contained in every regular expression match.
+func regexpLiteral(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	return syntaxLiteral(re.Simplify())
+}
+
+func syntaxLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return syntaxLiteral(re.Sub[0])
+	case syntax.OpConcat:
+		literals := make([]string, 0, len(re.Sub))
+		for _, sub := range re.Sub {
+			literals = append(literals, syntaxLiteral(sub))
+		}
+
+		return longestString(literals)
+	}
+
+	return ""
+}
+
+func longestString(ss []string) string {
+	var longest string
+	for _, s := range ss {
+		if len(s) > len(longest) {
+			longest = s
+		}
+	}
+
+	return longest
+}
+
+func isGlobWildcard(c rune) bool {
+	return c == '*' || c == '?'
+}
+
+// globToRegexp converts glob pattern matching whole
+// value into equivalent regular expression.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+
+	sb.WriteString(`\A`)
+	for _, c := range glob {
+		switch c {
+		case '*':
+			sb.WriteString(`(?s:.*)`)
+		case '?':
+			sb.WriteString(`(?s:.)`)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString(`\z`)
+
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+package retriever
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Attachment predicate and fields. Fields are matched by message
+// having at least one attachment satisfying comparison.
+const (
+	hasKeyword          = "HAS"
+	attachmentKeyword   = "ATTACHMENT"
+	attachmentFieldName = "ATTACHMENT.NAME"
+	attachmentFieldType = "ATTACHMENT.TYPE"
+	attachmentFieldSize = "ATTACHMENT.SIZE"
+)
+
+// attachments returns both retrieved and omitted message attachments.
+func (m *criteriaMatcher) attachments() []mailer.Attachment {
+	return slices.Concat(m.message.Attachments, m.message.OmittedAttachments)
+}
+
+// hasAttachmentNode matches messages having any attachments.
+type hasAttachmentNode struct {
+	nodeSpan filterSpan
+}
+
+func (n *hasAttachmentNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *hasAttachmentNode) criteria() (*imap.SearchCriteria, bool) {
+	return &imap.SearchCriteria{}, false
+}
+
+func (n *hasAttachmentNode) eval(m *criteriaMatcher) (bool, error) {
+	return len(m.attachments()) > 0, nil
+}
+
+func (n *hasAttachmentNode) String() string {
+	return "(HAS ATTACHMENT)"
+}
+
+// attachmentCompareNode matches messages by attachment file name or media type.
+// Negative comparison is matched by messages with no attachments satisfying
+// positive one, the same way as header fields with multiple values are.
+type attachmentCompareNode struct {
+	*compareNode
+}
+
+func (n *attachmentCompareNode) criteria() (*imap.SearchCriteria, bool) {
+	return &imap.SearchCriteria{}, false
+}
+
+func (n *attachmentCompareNode) eval(m *criteriaMatcher) (bool, error) {
+	attachments := m.attachments()
+
+	values := make([]string, 0, len(attachments))
+	for _, attachment := range attachments {
+		if n.field == attachmentFieldName {
+			values = append(values, attachment.Filename)
+		} else {
+			values = append(values, attachment.MIMEType)
+		}
+	}
+
+	matched := slices.ContainsFunc(values, n.matchValue)
+	if n.op == compareNotContains {
+		return !matched, nil
+	}
+
+	return matched, nil
+}
+
+// attachmentSizeNode matches messages having attachment of specified size.
+type attachmentSizeNode struct {
+	op       rangeOp
+	size     int64
+	nodeSpan filterSpan
+}
+
+func (n *attachmentSizeNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *attachmentSizeNode) criteria() (*imap.SearchCriteria, bool) {
+	// Message containing large attachment is large itself.
+	switch n.op {
+	case rangeEq, rangeGreaterEq:
+		return &imap.SearchCriteria{Larger: max(n.size-1, 0)}, false
+	case rangeGreater:
+		return &imap.SearchCriteria{Larger: n.size}, false
+	}
+
+	return &imap.SearchCriteria{}, false
+}
+
+func (n *attachmentSizeNode) eval(m *criteriaMatcher) (bool, error) {
+	return slices.ContainsFunc(m.attachments(), func(attachment mailer.Attachment) bool {
+		return n.op.compare(cmp.Compare(attachment.Size, n.size))
+	}), nil
+}
+
+func (n *attachmentSizeNode) String() string {
+	return fmt.Sprintf("(%s %s %d)", attachmentFieldSize, n.op, n.size)
+}
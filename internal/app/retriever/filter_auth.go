@@ -0,0 +1,95 @@
+package retriever
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Sender authentication fields, compared with results
+// of corresponding methods, like "DKIM == 'pass'".
+const (
+	authFieldDKIM  = "DKIM"
+	authFieldSPF   = "SPF"
+	authFieldDMARC = "DMARC"
+)
+
+// authResults are results authentication fields can be compared with.
+var authResults = []mailer.AuthResult{
+	mailer.AuthNone,
+	mailer.AuthPass,
+	mailer.AuthFail,
+	mailer.AuthPolicy,
+	mailer.AuthNeutral,
+	mailer.AuthSoftFail,
+	mailer.AuthTempError,
+	mailer.AuthPermError,
+}
+
+// authNode matches messages by result of sender authentication method.
+// Unknown results, when message has no trusted 'Authentication-Results'
+// header and was not verified on retrieval, are compared as "none".
+type authNode struct {
+	field    string
+	result   mailer.AuthResult
+	negate   bool
+	nodeSpan filterSpan
+}
+
+func newAuthNode(field string, value filterToken, negate bool, span filterSpan) (*authNode, error) {
+	result := mailer.AuthResult(strings.ToLower(value.value))
+	if !slices.Contains(authResults, result) {
+		return nil, newFilterError(value.span.start, "unknown %s result %q, expected one of %s",
+			field, value.value, formatAuthResults())
+	}
+
+	return &authNode{field: field, result: result, negate: negate, nodeSpan: span}, nil
+}
+
+func (n *authNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *authNode) criteria() (*imap.SearchCriteria, bool) {
+	return &imap.SearchCriteria{}, false
+}
+
+func (n *authNode) eval(m *criteriaMatcher) (bool, error) {
+	var result mailer.AuthResult
+	switch n.field {
+	case authFieldDKIM:
+		result = m.message.Authentication.DKIM
+	case authFieldSPF:
+		result = m.message.Authentication.SPF
+	case authFieldDMARC:
+		result = m.message.Authentication.DMARC
+	}
+
+	if result == "" {
+		result = mailer.AuthNone
+	}
+
+	return (result == n.result) != n.negate, nil
+}
+
+func (n *authNode) String() string {
+	op := compareContains
+	if n.negate {
+		op = compareNotContains
+	}
+
+	return fmt.Sprintf("(%s %s %q)", n.field, op, n.result)
+}
+
+func formatAuthResults() string {
+	results := make([]string, 0, len(authResults))
+	for _, result := range authResults {
+		results = append(results, "'"+string(result)+"'")
+	}
+
+	return strings.Join(results, ", ")
+}
@@ -0,0 +1,137 @@
+package retriever
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Field and predicate matching arbitrary flags and keywords.
+const (
+	keywordField   = "KEYWORD"
+	hasFlagKeyword = "HASFLAG"
+)
+
+// Gmail IMAP extension fields, see https://developers.google.com/gmail/imap/imap-extensions.
+const (
+	gmailFieldRaw    = "X-GM-RAW"
+	gmailFieldLabels = "X-GM-LABELS"
+)
+
+// keywordNode matches messages having (or not having)
+// flag or keyword, like '\Seen', '$Label1' or '$MDNSent'.
+type keywordNode struct {
+	flag     imap.Flag
+	negate   bool
+	nodeSpan filterSpan
+}
+
+func newKeywordNode(value filterToken, negate bool, span filterSpan) (*keywordNode, error) {
+	if !isValidFlag(value.value) {
+		return nil, newFilterError(value.span.start, "invalid flag %q", value.value)
+	}
+
+	return &keywordNode{flag: imap.Flag(value.value), negate: negate, nodeSpan: span}, nil
+}
+
+func (n *keywordNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *keywordNode) criteria() (*imap.SearchCriteria, bool) {
+	if n.negate {
+		return &imap.SearchCriteria{NotFlag: []imap.Flag{n.flag}}, true
+	}
+
+	return &imap.SearchCriteria{Flag: []imap.Flag{n.flag}}, true
+}
+
+func (n *keywordNode) eval(m *criteriaMatcher) (bool, error) {
+	return m.message.HasFlag(string(n.flag)) != n.negate, nil
+}
+
+func (n *keywordNode) String() string {
+	op := compareContains
+	if n.negate {
+		op = compareNotContains
+	}
+
+	return fmt.Sprintf("(%s %s %q)", keywordField, op, n.flag)
+}
+
+// isValidFlag reports whether s is valid IMAP keyword,
+// or system flag if prefixed with backslash.
+func isValidFlag(s string) bool {
+	s = strings.TrimPrefix(s, `\`)
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`(){%*"\]`, c) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// gmailQuery is search key of Gmail IMAP extension along with its value.
+type gmailQuery struct {
+	key, value string
+}
+
+// gmailNode matches messages with Gmail search extensions: X-GM-LABELS
+// by label and X-GM-RAW by query in Gmail web interface syntax.
+//
+// Those search keys can not be sent along with regular criteria,
+// so they are searched for separately and matched on client side.
+type gmailNode struct {
+	query    gmailQuery
+	negate   bool
+	nodeSpan filterSpan
+}
+
+func (n *gmailNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *gmailNode) criteria() (*imap.SearchCriteria, bool) {
+	return &imap.SearchCriteria{}, false
+}
+
+func (n *gmailNode) eval(m *criteriaMatcher) (bool, error) {
+	uids, ok := m.gmail[n.query]
+	if !ok {
+		return false, fmt.Errorf("%s search results are not available", n.query.key)
+	}
+
+	return uids.Contains(imap.UID(m.message.UID)) != n.negate, nil
+}
+
+func (n *gmailNode) String() string {
+	op := compareContains
+	if n.negate {
+		op = compareNotContains
+	}
+
+	return fmt.Sprintf("(%s %s %q)", n.query.key, op, n.query.value)
+}
+
+// gmailQueries returns Gmail extension searches used by expression.
+func gmailQueries(node filterNode) []gmailQuery {
+	switch n := node.(type) {
+	case *orNode:
+		return append(gmailQueries(n.left), gmailQueries(n.right)...)
+	case *andNode:
+		return append(gmailQueries(n.left), gmailQueries(n.right)...)
+	case *notNode:
+		return gmailQueries(n.expr)
+	case *gmailNode:
+		return []gmailQuery{n.query}
+	}
+
+	return nil
+}
@@ -0,0 +1,596 @@
+package retriever
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+	Filter expression grammar, operators are listed in order of increasing precedence:
+
+	Expression:
+		Term { || Term }
+
+	Term:
+		Unary { && Unary }
+
+	Unary:
+		! Unary
+		Primary
+
+	Primary:
+		( Expression )
+		Flag
+		HEADER String Operator String
+		HAS ATTACHMENT
+		HASFLAG Value
+		FlagField FlagOperator Value
+		Field Operator String
+		RangeField RangeOperator Value
+		RangeKeyword Value
+
+	Operator:
+		==	contains substring
+		!=	does not contain substring
+		~=	matches regular expression
+		*=	matches glob pattern ('*' and '?' wildcards)
+		^=	starts with
+		$=	ends with
+
+	Field:
+		Header field name, BODY, TEXT,
+		ATTACHMENT.NAME (file name), ATTACHMENT.TYPE (media type)
+
+		Header fields named as keywords or other fields, like 'Date'
+		or 'Size', are compared after HEADER keyword only.
+
+	FlagField:
+		KEYWORD (flag or keyword, like '$Label1' or '\Seen'),
+		X-GM-LABELS (Gmail label), X-GM-RAW (Gmail search query),
+		DKIM, SPF, DMARC (sender authentication result, like 'pass')
+
+	FlagOperator:
+		==, != (whole value comparison)
+
+	RangeField:
+		SIZE, DATE (internal date), SENTDATE ('Date' header), AGE,
+		ATTACHMENT.SIZE
+
+	RangeOperator:
+		==, <, <=, >, >=
+
+	RangeKeyword:
+		LARGER, SMALLER, SINCE, BEFORE, SENTSINCE, SENTBEFORE
+
+	Value:
+		String or unquoted value, like 1M or 2h
+
+	String:
+		'...' or "...", optionally followed by modifiers:
+		's' for case-sensitive and 'i' for case-insensitive
+		(default) comparison, like 'ALERT's
+*/
+
+// FilterError describes syntax error of filter expression.
+type FilterError struct {
+	Column int // Column (in characters) of expression where error occurred.
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("col %d: %s", e.Column, e.Reason)
+}
+
+func newFilterError(pos int, format string, args ...any) *FilterError {
+	return &FilterError{Column: pos + 1, Reason: fmt.Sprintf(format, args...)}
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenIdent
+	filterTokenString
+	filterTokenEq
+	filterTokenNotEq
+	filterTokenRegexp
+	filterTokenGlob
+	filterTokenPrefix
+	filterTokenSuffix
+	filterTokenLess
+	filterTokenLessEq
+	filterTokenGreater
+	filterTokenGreaterEq
+	filterTokenAnd
+	filterTokenOr
+	filterTokenNot
+	filterTokenLParen
+	filterTokenRParen
+)
+
+func (k filterTokenKind) String() string {
+	switch k {
+	case filterTokenEOF:
+		return "end of expression"
+	case filterTokenIdent:
+		return "identifier"
+	case filterTokenString:
+		return "quoted string"
+	case filterTokenEq:
+		return "'=='"
+	case filterTokenNotEq:
+		return "'!='"
+	case filterTokenRegexp:
+		return "'~='"
+	case filterTokenGlob:
+		return "'*='"
+	case filterTokenPrefix:
+		return "'^='"
+	case filterTokenSuffix:
+		return "'$='"
+	case filterTokenLess:
+		return "'<'"
+	case filterTokenLessEq:
+		return "'<='"
+	case filterTokenGreater:
+		return "'>'"
+	case filterTokenGreaterEq:
+		return "'>='"
+	case filterTokenAnd:
+		return "'&&'"
+	case filterTokenOr:
+		return "'||'"
+	case filterTokenNot:
+		return "'!'"
+	case filterTokenLParen:
+		return "'('"
+	case filterTokenRParen:
+		return "')'"
+	}
+
+	return "unknown token"
+}
+
+type filterToken struct {
+	kind  filterTokenKind
+	value string
+	span  filterSpan
+	// Modifiers following quoted string.
+	modifiers string
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case filterTokenIdent:
+		return fmt.Sprintf("identifier %q", t.value)
+	case filterTokenString:
+		return fmt.Sprintf("quoted string %q", t.value)
+	}
+
+	return t.kind.String()
+}
+
+// Operators by their first character. Operators made of
+// single character are listed after two-character ones.
+var filterOperators = map[rune][]struct {
+	next rune
+	kind filterTokenKind
+}{
+	'=': {{next: '=', kind: filterTokenEq}},
+	'!': {{next: '=', kind: filterTokenNotEq}, {next: 0, kind: filterTokenNot}},
+	'~': {{next: '=', kind: filterTokenRegexp}},
+	'*': {{next: '=', kind: filterTokenGlob}},
+	'^': {{next: '=', kind: filterTokenPrefix}},
+	'$': {{next: '=', kind: filterTokenSuffix}},
+	'<': {{next: '=', kind: filterTokenLessEq}, {next: 0, kind: filterTokenLess}},
+	'>': {{next: '=', kind: filterTokenGreaterEq}, {next: 0, kind: filterTokenGreater}},
+	'&': {{next: '&', kind: filterTokenAnd}},
+	'|': {{next: '|', kind: filterTokenOr}},
+}
+
+// Comparison operators by their tokens.
+var filterCompareOps = map[filterTokenKind]compareOp{
+	filterTokenEq:     compareContains,
+	filterTokenNotEq:  compareNotContains,
+	filterTokenRegexp: compareRegexp,
+	filterTokenGlob:   compareGlob,
+	filterTokenPrefix: comparePrefix,
+	filterTokenSuffix: compareSuffix,
+}
+
+// lexFilter splits filter expression into tokens.
+func lexFilter(expr []rune) ([]filterToken, error) {
+	var tokens []filterToken
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(' || c == ')':
+			kind := filterTokenLParen
+			if c == ')' {
+				kind = filterTokenRParen
+			}
+
+			tokens = append(tokens, filterToken{kind: kind, span: filterSpan{i, i + 1}})
+			i++
+
+		case c == '\'' || c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != c {
+				end++
+			}
+			if end == len(expr) {
+				return nil, newFilterError(i, "missing closing quote")
+			}
+
+			token := filterToken{
+				kind:  filterTokenString,
+				value: string(expr[i+1 : end]),
+			}
+
+			modifiersEnd := end + 1
+			for modifiersEnd < len(expr) && unicode.IsLetter(expr[modifiersEnd]) {
+				modifiersEnd++
+			}
+			token.modifiers = string(expr[end+1 : modifiersEnd])
+			token.span = filterSpan{i, modifiersEnd}
+
+			tokens = append(tokens, token)
+			i = modifiersEnd
+
+		default:
+			// Operators are matched first, as '$' may start
+			// either suffix operator or keyword identifier.
+			if token, ok := lexFilterOperator(expr, i); ok {
+				tokens = append(tokens, token)
+				i = token.span.end
+				continue
+			}
+
+			if !isFilterIdentRune(c) {
+				return nil, newFilterError(i, "unexpected character '%c'", c)
+			}
+
+			end := i
+			for end < len(expr) && isFilterIdentRune(expr[end]) {
+				end++
+			}
+
+			tokens = append(tokens, filterToken{
+				kind:  filterTokenIdent,
+				value: string(expr[i:end]),
+				span:  filterSpan{i, end},
+			})
+			i = end
+		}
+	}
+
+	return append(tokens, filterToken{
+		kind: filterTokenEOF,
+		span: filterSpan{len(expr), len(expr)},
+	}), nil
+}
+
+func lexFilterOperator(expr []rune, i int) (filterToken, bool) {
+	for _, op := range filterOperators[expr[i]] {
+		switch {
+		case op.next == 0:
+			return filterToken{kind: op.kind, span: filterSpan{i, i + 1}}, true
+		case i+1 < len(expr) && expr[i+1] == op.next:
+			return filterToken{kind: op.kind, span: filterSpan{i, i + 2}}, true
+		}
+	}
+
+	return filterToken{}, false
+}
+
+func isFilterIdentRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-' || c == '_' || c == '.' || c == '$'
+}
+
+// filterParser is recursive descent parser
+// building filter expression syntax tree.
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilterExpression parses filter expression into syntax tree.
+func parseFilterExpression(expr string) (filterNode, error) {
+	tokens, err := lexFilter([]rune(expr))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	node, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := p.peek(); token.kind != filterTokenEOF {
+		return nil, newFilterError(token.span.start, "unexpected %s", token)
+	}
+
+	return node, nil
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != filterTokenEOF {
+		p.pos++
+	}
+
+	return token
+}
+
+func (p *filterParser) expect(kind filterTokenKind) (filterToken, error) {
+	token := p.next()
+	if token.kind != kind {
+		return token, newFilterError(token.span.start, "expected %s, got %s", kind, token)
+	}
+
+	return token, nil
+}
+
+func (p *filterParser) parseExpression() (filterNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == filterTokenOr {
+		p.next()
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &orNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseTerm() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == filterTokenAnd {
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &andNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.peek().kind != filterTokenNot {
+		return p.parsePrimary()
+	}
+
+	token := p.next()
+
+	expr, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &notNode{pos: token.span.start, expr: expr}, nil
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	token := p.next()
+
+	switch token.kind {
+	case filterTokenLParen:
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = p.expect(filterTokenRParen); err != nil {
+			return nil, err
+		}
+
+		return expr, nil
+
+	case filterTokenIdent:
+		return p.parseIdent(token)
+	}
+
+	return nil, newFilterError(token.span.start, "expected flag, field name or '(', got %s", token)
+}
+
+func (p *filterParser) parseIdent(ident filterToken) (filterNode, error) {
+	name := strings.ToUpper(ident.value)
+
+	next := p.peek()
+
+	// Header field named "Header" may be compared still.
+	if name == headerKeyword && next.kind == filterTokenString {
+		p.next()
+		return p.parseHeaderCompare(ident, next)
+	}
+
+	// Header field named "Has" may be compared still.
+	if _, ok := filterCompareOps[next.kind]; name == hasKeyword && !ok {
+		token, err := p.expect(filterTokenIdent)
+		if err != nil || !strings.EqualFold(token.value, attachmentKeyword) {
+			return nil, newFilterError(token.span.start, "expected %q after %q, got %s", attachmentKeyword, ident.value, token)
+		}
+
+		return &hasAttachmentNode{nodeSpan: filterSpan{ident.span.start, token.span.end}}, nil
+	}
+
+	if name == hasFlagKeyword {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return newKeywordNode(value, false, filterSpan{ident.span.start, value.span.end})
+	}
+
+	if keyword, ok := rangeKeywords[name]; ok {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		ident.value = keyword.field
+		return newRangeNode(ident, keyword.op, value)
+	}
+
+	switch name {
+	case keywordField, gmailFieldLabels, gmailFieldRaw, authFieldDKIM, authFieldSPF, authFieldDMARC:
+		return p.parseEquality(ident)
+
+	case rangeFieldSize, rangeFieldDate, rangeFieldSentDate, rangeFieldAge, attachmentFieldSize:
+		op, ok := filterRangeOps[next.kind]
+		if !ok {
+			return nil, newFilterError(next.span.start, "expected one of '==', '<', '<=', '>', '>=' after field %q, got %s", ident.value, next)
+		}
+		p.next()
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return newRangeNode(ident, op, value)
+	}
+
+	if strings.HasPrefix(name, attachmentKeyword+".") && name != attachmentFieldName && name != attachmentFieldType {
+		return nil, newFilterError(ident.span.start, "unknown attachment field %q", ident.value)
+	}
+
+	if op, ok := filterCompareOps[next.kind]; ok {
+		p.next()
+
+		value, err := p.expect(filterTokenString)
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := newCompareNode(ident, op, value)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == attachmentFieldName || name == attachmentFieldType {
+			return &attachmentCompareNode{node}, nil
+		}
+
+		return node, nil
+	}
+
+	if next.kind == filterTokenString {
+		return nil, newFilterError(next.span.start, "expected comparison operator after field %q, got %s", ident.value, next)
+	}
+
+	if _, ok := flagTokens[name]; !ok {
+		return nil, newFilterError(ident.span.start, "unknown flag %q", ident.value)
+	}
+
+	return &flagNode{name: name, nameSpan: ident.span}, nil
+}
+
+// parseHeaderCompare parses comparison of header field
+// named by quoted string following HEADER keyword.
+func (p *filterParser) parseHeaderCompare(keyword, name filterToken) (filterNode, error) {
+	if name.modifiers != "" {
+		return nil, newFilterError(name.span.start, "header field name does not support string modifiers")
+	}
+	if name.value == "" || strings.ContainsFunc(name.value, func(r rune) bool { return r <= ' ' || r == ':' || r > '~' }) {
+		return nil, newFilterError(name.span.start, "invalid header field name %q", name.value)
+	}
+
+	next := p.next()
+	op, ok := filterCompareOps[next.kind]
+	if !ok {
+		return nil, newFilterError(next.span.start, "expected comparison operator after header field %q, got %s", name.value, next)
+	}
+
+	value, err := p.expect(filterTokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	field := filterToken{kind: filterTokenIdent, value: name.value, span: filterSpan{keyword.span.start, name.span.end}}
+	node, err := newCompareNode(field, op, value)
+	if err != nil {
+		return nil, err
+	}
+	node.header = true
+
+	return node, nil
+}
+
+// parseEquality parses comparison of flag keyword, Gmail extension
+// or authentication field, which are compared by whole value only.
+func (p *filterParser) parseEquality(field filterToken) (filterNode, error) {
+	name := strings.ToUpper(field.value)
+
+	op := p.next()
+	if op.kind != filterTokenEq && op.kind != filterTokenNotEq {
+		return nil, newFilterError(op.span.start, "expected '==' or '!=' after field %q, got %s", field.value, op)
+	}
+	negate := op.kind == filterTokenNotEq
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if value.modifiers != "" {
+		return nil, newFilterError(value.span.start, "field %q does not support string modifiers", field.value)
+	}
+
+	span := filterSpan{field.span.start, value.span.end}
+	switch name {
+	case keywordField:
+		return newKeywordNode(value, negate, span)
+	case authFieldDKIM, authFieldSPF, authFieldDMARC:
+		return newAuthNode(name, value, negate, span)
+	}
+
+	if value.value == "" || len(value.value) > maxGmailLiteralSize {
+		return nil, newFilterError(value.span.start, "%s value must be from 1 to %d bytes long", name, maxGmailLiteralSize)
+	}
+
+	return &gmailNode{
+		query:    gmailQuery{key: name, value: value.value},
+		negate:   negate,
+		nodeSpan: span,
+	}, nil
+}
+
+// parseValue parses value of range or flag comparison, which may
+// be either quoted or not, like "1M", "2h", '2025-01-01' or $Label1.
+func (p *filterParser) parseValue() (filterToken, error) {
+	token := p.next()
+	if token.kind != filterTokenIdent && token.kind != filterTokenString {
+		return token, newFilterError(token.span.start, "expected value, got %s", token)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,319 @@
+package retriever
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/pkg/units"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// timeNow returns current time, replaced in tests.
+var timeNow = time.Now
+
+type rangeOp int
+
+const (
+	rangeEq rangeOp = iota
+	rangeLess
+	rangeLessEq
+	rangeGreater
+	rangeGreaterEq
+)
+
+func (op rangeOp) String() string {
+	switch op {
+	case rangeEq:
+		return "=="
+	case rangeLess:
+		return "<"
+	case rangeLessEq:
+		return "<="
+	case rangeGreater:
+		return ">"
+	case rangeGreaterEq:
+		return ">="
+	}
+
+	return "?"
+}
+
+// compare reports whether result of values comparison,
+// like one returned by [cmp.Compare], satisfies operator.
+func (op rangeOp) compare(result int) bool {
+	switch op {
+	case rangeEq:
+		return result == 0
+	case rangeLess:
+		return result < 0
+	case rangeLessEq:
+		return result <= 0
+	case rangeGreater:
+		return result > 0
+	case rangeGreaterEq:
+		return result >= 0
+	}
+
+	return false
+}
+
+// Range comparison operators by their tokens.
+var filterRangeOps = map[filterTokenKind]rangeOp{
+	filterTokenEq:        rangeEq,
+	filterTokenLess:      rangeLess,
+	filterTokenLessEq:    rangeLessEq,
+	filterTokenGreater:   rangeGreater,
+	filterTokenGreaterEq: rangeGreaterEq,
+}
+
+// Fields compared with range comparison operators.
+const (
+	rangeFieldSize     = "SIZE"
+	rangeFieldDate     = "DATE"
+	rangeFieldSentDate = "SENTDATE"
+	rangeFieldAge      = "AGE"
+)
+
+// Shorthand predicates, like IMAP SEARCH keys,
+// equivalent to range comparisons.
+var rangeKeywords = map[string]struct {
+	field string
+	op    rangeOp
+}{
+	"LARGER":     {field: rangeFieldSize, op: rangeGreater},
+	"SMALLER":    {field: rangeFieldSize, op: rangeLess},
+	"SINCE":      {field: rangeFieldDate, op: rangeGreaterEq},
+	"BEFORE":     {field: rangeFieldDate, op: rangeLess},
+	"SENTSINCE":  {field: rangeFieldSentDate, op: rangeGreaterEq},
+	"SENTBEFORE": {field: rangeFieldSentDate, op: rangeLess},
+}
+
+func newRangeNode(field filterToken, op rangeOp, value filterToken) (filterNode, error) {
+	name := strings.ToUpper(field.value)
+	span := filterSpan{field.span.start, value.span.end}
+
+	switch name {
+	case rangeFieldSize, attachmentFieldSize:
+		size, err := units.FromHumanSize(value.value)
+		if err != nil {
+			return nil, newFilterError(value.span.start, "invalid size %q", value.value)
+		}
+		// IMAP SEARCH has no key for sizes below 1 byte.
+		if size < 1 {
+			return nil, newFilterError(value.span.start, "size %q must be at least 1 byte", value.value)
+		}
+
+		if name == attachmentFieldSize {
+			return &attachmentSizeNode{op: op, size: size, nodeSpan: span}, nil
+		}
+
+		return &sizeNode{op: op, size: size, nodeSpan: span}, nil
+
+	case rangeFieldDate, rangeFieldSentDate:
+		date, dateOnly, err := parseFilterDate(value.value)
+		if err != nil {
+			return nil, newFilterError(value.span.start, "invalid date %q, expected format like '2006-01-02' or '2006-01-02T15:04:05Z07:00'", value.value)
+		}
+
+		return &dateNode{field: name, op: op, date: date, dateOnly: dateOnly, nodeSpan: span}, nil
+
+	case rangeFieldAge:
+		age, err := parseFilterAge(value.value)
+		if err != nil {
+			return nil, newFilterError(value.span.start, "invalid age %q, expected duration like '30m', '2h' or '7d'", value.value)
+		}
+
+		return &ageNode{op: op, age: age, nodeSpan: span}, nil
+	}
+
+	return nil, newFilterError(field.span.start, "field %q does not support range comparison", field.value)
+}
+
+func parseFilterDate(s string) (time.Time, bool, error) {
+	for _, layout := range []string{time.DateOnly, "02-Jan-2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true, nil
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+// parseFilterAge parses duration, additionally supporting
+// days and weeks units, like "7d" or "2w".
+func parseFilterAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, err
+			}
+
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = errors.New("negative duration")
+	}
+
+	return d, err
+}
+
+// sizeNode compares message size.
+type sizeNode struct {
+	op       rangeOp
+	size     int64
+	nodeSpan filterSpan
+}
+
+func (n *sizeNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *sizeNode) criteria() (*imap.SearchCriteria, bool) {
+	var c imap.SearchCriteria
+
+	// IMAP LARGER and SMALLER keys are strict.
+	switch n.op {
+	case rangeEq:
+		c.Larger, c.Smaller = n.size-1, n.size+1
+	case rangeLess:
+		c.Smaller = n.size
+	case rangeLessEq:
+		c.Smaller = n.size + 1
+	case rangeGreater:
+		c.Larger = n.size
+	case rangeGreaterEq:
+		c.Larger = n.size - 1
+	}
+
+	c.Larger = max(c.Larger, 0)
+
+	return &c, true
+}
+
+func (n *sizeNode) eval(m *criteriaMatcher) (bool, error) {
+	return n.op.compare(cmp.Compare(m.message.Size, n.size)), nil
+}
+
+func (n *sizeNode) String() string {
+	return fmt.Sprintf("(SIZE %s %d)", n.op, n.size)
+}
+
+// dateNode compares message internal date or date from its header.
+type dateNode struct {
+	field    string
+	op       rangeOp
+	date     time.Time
+	dateOnly bool
+	nodeSpan filterSpan
+}
+
+func (n *dateNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *dateNode) criteria() (*imap.SearchCriteria, bool) {
+	since, before := dateRangeCriteria(n.op, n.date, n.dateOnly)
+
+	c := &imap.SearchCriteria{}
+	if n.field == rangeFieldSentDate {
+		c.SentSince, c.SentBefore = since, before
+	} else {
+		c.Since, c.Before = since, before
+	}
+
+	// IMAP compares dates only, so time
+	// has to be compared on client side.
+	return c, n.dateOnly
+}
+
+func (n *dateNode) eval(m *criteriaMatcher) (bool, error) {
+	t := m.message.InternalDate
+	if n.field == rangeFieldSentDate {
+		t = m.message.Date
+	}
+
+	if n.dateOnly {
+		return n.op.compare(truncateDate(t).Compare(truncateDate(n.date))), nil
+	}
+
+	return n.op.compare(t.Compare(n.date)), nil
+}
+
+func (n *dateNode) String() string {
+	value := n.date.Format(time.RFC3339)
+	if n.dateOnly {
+		value = n.date.Format(time.DateOnly)
+	}
+
+	return fmt.Sprintf("(%s %s %s)", n.field, n.op, value)
+}
+
+// dateRangeCriteria returns IMAP SINCE and BEFORE dates
+// covering all dates matching comparison with date.
+func dateRangeCriteria(op rangeOp, date time.Time, dateOnly bool) (since, before time.Time) {
+	day := truncateDate(date)
+	nextDay := day.AddDate(0, 0, 1)
+
+	switch {
+	case op == rangeEq:
+		return day, nextDay
+	case op == rangeLess && dateOnly:
+		return time.Time{}, day
+	case op == rangeLess, op == rangeLessEq:
+		return time.Time{}, nextDay
+	case op == rangeGreater && dateOnly:
+		return nextDay, time.Time{}
+	}
+
+	return day, time.Time{}
+}
+
+// ageNode compares time elapsed since message was received.
+type ageNode struct {
+	op       rangeOp
+	age      time.Duration
+	nodeSpan filterSpan
+}
+
+func (n *ageNode) span() filterSpan {
+	return n.nodeSpan
+}
+
+func (n *ageNode) criteria() (*imap.SearchCriteria, bool) {
+	// Greater age stands for earlier date.
+	op := n.op
+	switch op {
+	case rangeLess:
+		op = rangeGreater
+	case rangeLessEq:
+		op = rangeGreaterEq
+	case rangeGreater:
+		op = rangeLess
+	case rangeGreaterEq:
+		op = rangeLessEq
+	}
+
+	c := &imap.SearchCriteria{}
+	c.Since, c.Before = dateRangeCriteria(op, timeNow().Add(-n.age), false)
+
+	return c, false
+}
+
+func (n *ageNode) eval(m *criteriaMatcher) (bool, error) {
+	age := timeNow().Sub(m.message.InternalDate)
+	return n.op.compare(cmp.Compare(age, n.age)), nil
+}
+
+func (n *ageNode) String() string {
+	return fmt.Sprintf("(AGE %s %s)", n.op, n.age)
+}
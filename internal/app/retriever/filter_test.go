@@ -3,36 +3,42 @@ package retriever
 import (
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+	"github.com/hickar/chatmailer/internal/pkg/units"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseFilterExpression(t *testing.T) {
 	tests := []struct {
-		filterExpr     []rune
+		filterExpr     string
 		expectedOutput *imap.SearchCriteria
 	}{
 		{
-			filterExpr: []rune("SEEN"),
+			filterExpr: "SEEN",
 			expectedOutput: &imap.SearchCriteria{
 				Flag: []imap.Flag{imap.FlagSeen},
 			},
 		},
 		{
-			filterExpr: []rune("!SEEN"),
+			filterExpr: "!SEEN",
 			expectedOutput: &imap.SearchCriteria{
 				Not: []imap.SearchCriteria{{Flag: []imap.Flag{imap.FlagSeen}}},
 			},
 		},
 		{
-			filterExpr: []rune("SEEN && JUNK && IMPORTANT"),
+			filterExpr: "SEEN && JUNK && IMPORTANT",
 			expectedOutput: &imap.SearchCriteria{
 				Flag: []imap.Flag{imap.FlagSeen, imap.FlagJunk, imap.FlagImportant},
 			},
 		},
 		{
-			filterExpr: []rune("SEEN || JUNK || IMPORTANT"),
+			filterExpr: "SEEN || JUNK || IMPORTANT",
 			expectedOutput: &imap.SearchCriteria{
 				Or: [][2]imap.SearchCriteria{{
 					{
@@ -46,13 +52,13 @@ func TestParseFilterExpression(t *testing.T) {
 			},
 		},
 		{
-			filterExpr: []rune("(((SEEN)))"),
+			filterExpr: "(((SEEN)))",
 			expectedOutput: &imap.SearchCriteria{
 				Flag: []imap.Flag{imap.FlagSeen},
 			},
 		},
 		{
-			filterExpr: []rune("FROM == 'test@test.com'"),
+			filterExpr: "FROM == 'test@test.com'",
 			expectedOutput: &imap.SearchCriteria{
 				Header: []imap.SearchCriteriaHeaderField{{
 					Key:   "FROM",
@@ -61,7 +67,7 @@ func TestParseFilterExpression(t *testing.T) {
 			},
 		},
 		{
-			filterExpr: []rune("FROM == 'test@test.com' && SEEN"),
+			filterExpr: "FROM == 'test@test.com' && SEEN",
 			expectedOutput: &imap.SearchCriteria{
 				Header: []imap.SearchCriteriaHeaderField{{
 					Key:   "FROM",
@@ -71,7 +77,7 @@ func TestParseFilterExpression(t *testing.T) {
 			},
 		},
 		{
-			filterExpr: []rune("((IMPORTANT || FORWARDED) && !JUNK)"),
+			filterExpr: "((IMPORTANT || FORWARDED) && !JUNK)",
 			expectedOutput: &imap.SearchCriteria{
 				Not: []imap.SearchCriteria{{Flag: []imap.Flag{imap.FlagJunk}}},
 				Or: [][2]imap.SearchCriteria{{
@@ -81,7 +87,7 @@ func TestParseFilterExpression(t *testing.T) {
 			},
 		},
 		{
-			filterExpr: []rune("!JUNK || FROM == 'very.important@contact.com'"),
+			filterExpr: "!JUNK || FROM == 'very.important@contact.com'",
 			expectedOutput: &imap.SearchCriteria{
 				Or: [][2]imap.SearchCriteria{{
 					{Not: []imap.SearchCriteria{{Flag: []imap.Flag{imap.FlagJunk}}}},
@@ -93,7 +99,7 @@ func TestParseFilterExpression(t *testing.T) {
 			},
 		},
 		{
-			filterExpr: []rune("!(!JUNK || FROM == 'very.important@contact.com')"),
+			filterExpr: "!(!JUNK || FROM == 'very.important@contact.com')",
 			expectedOutput: &imap.SearchCriteria{
 				Not: []imap.SearchCriteria{{
 					Or: [][2]imap.SearchCriteria{{
@@ -107,19 +113,19 @@ func TestParseFilterExpression(t *testing.T) {
 			},
 		},
 		{
-			filterExpr: []rune("UNSEEN && UNDELETED"),
+			filterExpr: "UNSEEN && UNDELETED",
 			expectedOutput: &imap.SearchCriteria{
 				NotFlag: []imap.Flag{imap.FlagSeen, imap.FlagDeleted},
 			},
 		},
 		{
-			filterExpr: []rune("    SEEN    && ( IMPORTANT )       "),
+			filterExpr: "    SEEN    && ( IMPORTANT )       ",
 			expectedOutput: &imap.SearchCriteria{
 				Flag: []imap.Flag{imap.FlagSeen, imap.FlagImportant},
 			},
 		},
 		{
-			filterExpr: []rune(" (   (UNSEEN ||  !(DELETED && FROM == 'test@test.com' )) )"),
+			filterExpr: " (   (UNSEEN ||  !(DELETED && FROM == 'test@test.com' )) )",
 			expectedOutput: &imap.SearchCriteria{
 				Or: [][2]imap.SearchCriteria{{
 					{NotFlag: []imap.Flag{imap.FlagSeen}},
@@ -136,7 +142,7 @@ func TestParseFilterExpression(t *testing.T) {
 			},
 		},
 		{
-			filterExpr: []rune("BODY == 'sample body' && TEXT == 'sample text'"),
+			filterExpr: "BODY == 'sample body' && TEXT == 'sample text'",
 			expectedOutput: &imap.SearchCriteria{
 				Body: []string{"sample body"},
 				Text: []string{"sample text"},
@@ -146,9 +152,620 @@ func TestParseFilterExpression(t *testing.T) {
 
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
-			actual, _, err := parseFilterExpression(tt.filterExpr, 0)
+			actual, err := ParseFilter(tt.filterExpr)
 			assert.NoError(t, err)
-			assert.Equal(t, tt.expectedOutput, actual, "failed to parse %q", string(tt.filterExpr))
+			assert.Equal(t, tt.expectedOutput, actual, "failed to parse %q", tt.filterExpr)
+		})
+	}
+}
+
+func TestParseFilterPrecedence(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		want       string
+	}{
+		{
+			filterExpr: "SEEN && JUNK || IMPORTANT",
+			want:       "(OR (AND (FLAG SEEN) (FLAG JUNK)) (FLAG IMPORTANT))",
+		},
+		{
+			filterExpr: "SEEN || JUNK && IMPORTANT",
+			want:       "(OR (FLAG SEEN) (AND (FLAG JUNK) (FLAG IMPORTANT)))",
+		},
+		{
+			filterExpr: "!SEEN && !!JUNK",
+			want:       "(AND (NOT (FLAG SEEN)) (NOT (NOT (FLAG JUNK))))",
+		},
+		{
+			filterExpr: `subject != "[ALERT]" && (seen || List-Id == 'x')`,
+			want:       `(AND (SUBJECT != "[ALERT]") (OR (FLAG SEEN) (LIST-ID == "x")))`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, filter.String())
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		wantErr    string
+	}{
+		{filterExpr: "", wantErr: "col 1: expected flag, field name or '(', got end of expression"},
+		{filterExpr: "SUBJECT == alert", wantErr: `col 12: expected quoted string, got identifier "alert"`},
+		{filterExpr: "SUBJECT = 'alert'", wantErr: "col 9: unexpected character '='"},
+		{filterExpr: "SUBJECT 'alert'", wantErr: `col 9: expected comparison operator after field "SUBJECT", got quoted string "alert"`},
+		{filterExpr: "SEEN && UNKNOWN", wantErr: `col 9: unknown flag "UNKNOWN"`},
+		{filterExpr: "(SEEN || JUNK", wantErr: "col 14: expected ')', got end of expression"},
+		{filterExpr: "SEEN JUNK", wantErr: `col 6: unexpected identifier "JUNK"`},
+		{filterExpr: "SEEN & JUNK", wantErr: "col 6: unexpected character '&'"},
+		{filterExpr: "FROM == 'test@test.com", wantErr: "col 9: missing closing quote"},
+		{filterExpr: "SEEN && ", wantErr: "col 9: expected flag, field name or '(', got end of expression"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := ParseFilter(tt.filterExpr)
+			require.Error(t, err)
+			assert.EqualError(t, err, tt.wantErr)
+
+			var filterErr *FilterError
+			assert.ErrorAs(t, err, &filterErr)
+		})
+	}
+}
+
+func TestParseFilterOperators(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		criteria   *imap.SearchCriteria
+		exact      bool
+	}{
+		{
+			filterExpr: `SUBJECT ~= '^\[ALERT\] (prod|stage)'s`,
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "SUBJECT", Value: "[ALERT] "}},
+			},
+		},
+		{
+			filterExpr: `SUBJECT ~= '(?:critical|warning): disk (full|degraded)'`,
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "SUBJECT", Value: ": disk "}},
+			},
+		},
+		{
+			filterExpr: `FROM *= '*@monitoring.example.*'`,
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "FROM", Value: "@monitoring.example."}},
+			},
+		},
+		{
+			filterExpr: `SUBJECT ^= 'Re:' && BODY $= 'regards'`,
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "SUBJECT", Value: "Re:"}},
+				Body:   []string{"regards"},
+			},
+		},
+		{
+			filterExpr: `SUBJECT == 'ALERT'i`,
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "SUBJECT", Value: "ALERT"}},
+			},
+			exact: true,
+		},
+		{
+			filterExpr: `SEEN || !(SUBJECT ^= 'Re:')`,
+			criteria: &imap.SearchCriteria{
+				Or: [][2]imap.SearchCriteria{{{Flag: []imap.Flag{imap.FlagSeen}}, {}}},
+			},
+		},
+		{
+			filterExpr: `BODY ~= '.*'`,
+			criteria:   &imap.SearchCriteria{},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.criteria, filter.Criteria())
+			assert.Equal(t, tt.exact, filter.Exact())
+		})
+	}
+}
+
+func TestParseFilterOperatorErrors(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		wantErr    string
+	}{
+		{filterExpr: "SUBJECT ~= '(unclosed'", wantErr: "col 12: invalid pattern: error parsing regexp: missing closing ): `(?i)(unclosed`"},
+		{filterExpr: "SUBJECT ^= 'Re:'x", wantErr: "col 12: unknown string modifier 'x'"},
+		{filterExpr: "SUBJECT ~ 'Re:'", wantErr: "col 9: unexpected character '~'"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := CompileFilter(tt.filterExpr)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseFilterRangePredicates(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2025, time.March, 10, 1, 0, 0, 0, time.UTC)
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	day := func(d int) time.Time {
+		return time.Date(2025, time.March, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		filterExpr string
+		criteria   *imap.SearchCriteria
+		exact      bool
+	}{
+		{
+			filterExpr: "SIZE > 1M",
+			criteria:   &imap.SearchCriteria{Larger: 1000000},
+			exact:      true,
+		},
+		{
+			filterExpr: "size <= '1.5kB' && SIZE >= 10",
+			criteria:   &imap.SearchCriteria{Smaller: 1501, Larger: 9},
+			exact:      true,
+		},
+		{
+			filterExpr: "LARGER 1M || SMALLER 100",
+			criteria: &imap.SearchCriteria{
+				Or: [][2]imap.SearchCriteria{{{Larger: 1000000}, {Smaller: 100}}},
+			},
+			exact: true,
+		},
+		{
+			filterExpr: "DATE >= '2025-03-01' && DATE < '2025-03-05'",
+			criteria:   &imap.SearchCriteria{Since: day(1), Before: day(5)},
+			exact:      true,
+		},
+		{
+			filterExpr: "DATE > '2025-03-01' && SENTDATE <= '2025-03-05'",
+			criteria:   &imap.SearchCriteria{Since: day(2), SentBefore: day(6)},
+			exact:      true,
+		},
+		{
+			filterExpr: "SENTDATE == '07-Mar-2025'",
+			criteria:   &imap.SearchCriteria{SentSince: day(7), SentBefore: day(8)},
+			exact:      true,
+		},
+		{
+			filterExpr: "SINCE '2025-03-01' && SENTBEFORE '2025-03-05'",
+			criteria:   &imap.SearchCriteria{Since: day(1), SentBefore: day(5)},
+			exact:      true,
+		},
+		{
+			filterExpr: "DATE < '2025-03-05T12:00:00Z'",
+			criteria:   &imap.SearchCriteria{Before: day(6)},
+		},
+		{
+			filterExpr: "AGE < 2h",
+			criteria:   &imap.SearchCriteria{Since: day(9)},
+		},
+		{
+			filterExpr: "AGE > 7d",
+			criteria:   &imap.SearchCriteria{Before: day(4)},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.criteria, filter.Criteria())
+			assert.Equal(t, tt.exact, filter.Exact())
+		})
+	}
+}
+
+func TestParseFilterRangePredicateErrors(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		wantErr    string
+	}{
+		{filterExpr: "SIZE > huge", wantErr: `col 8: invalid size "huge"`},
+		{filterExpr: "SIZE ~= '1M'", wantErr: `col 6: expected one of '==', '<', '<=', '>', '>=' after field "SIZE", got '~='`},
+		{filterExpr: "DATE >= 'yesterday'", wantErr: `col 9: invalid date "yesterday", expected format like '2006-01-02' or '2006-01-02T15:04:05Z07:00'`},
+		{filterExpr: "AGE < 2x", wantErr: `col 7: invalid age "2x", expected duration like '30m', '2h' or '7d'`},
+		{filterExpr: "SINCE", wantErr: "col 6: expected value, got end of expression"},
+		{filterExpr: "SIZE < 0", wantErr: `col 8: size "0" must be at least 1 byte`},
+		{filterExpr: "LARGER 0", wantErr: `col 8: size "0" must be at least 1 byte`},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := CompileFilter(tt.filterExpr)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseFilterHeaderKeyword(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		criteria   *imap.SearchCriteria
+		exact      bool
+		ast        string
+	}{
+		{
+			// Header fields named as range fields and keywords are compared as usual.
+			filterExpr: "HEADER 'Date' == 'Mon' && HEADER \"Keyword\" != 'urgent'",
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "DATE", Value: "Mon"}},
+				Not:    []imap.SearchCriteria{{Header: []imap.SearchCriteriaHeaderField{{Key: "KEYWORD", Value: "urgent"}}}},
+			},
+			exact: true,
+			ast:   `(AND (HEADER "DATE" == "Mon") (HEADER "KEYWORD" != "urgent"))`,
+		},
+		{
+			filterExpr: "HEADER 'Body' ^= 'x'",
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "BODY", Value: "x"}},
+			},
+			ast: `(HEADER "BODY" ^= "x")`,
+		},
+		{
+			// Header field named "Header" is compared without keyword still.
+			filterExpr: "HEADER == 'x'",
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "HEADER", Value: "x"}},
+			},
+			exact: true,
+			ast:   `(HEADER == "x")`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.criteria, filter.Criteria())
+			assert.Equal(t, tt.exact, filter.Exact())
+			assert.Equal(t, tt.ast, filter.String())
+		})
+	}
+}
+
+func TestParseFilterHeaderKeywordErrors(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		wantErr    string
+	}{
+		{filterExpr: "HEADER 'Date's == 'Mon'", wantErr: "col 8: header field name does not support string modifiers"},
+		{filterExpr: "HEADER 'X Date' == 'Mon'", wantErr: `col 8: invalid header field name "X Date"`},
+		{filterExpr: "HEADER '' == 'Mon'", wantErr: `col 8: invalid header field name ""`},
+		{filterExpr: "HEADER 'Date' < 'Mon'", wantErr: `col 15: expected comparison operator after header field "Date", got '<'`},
+		{filterExpr: "HEADER 'Date' == Mon", wantErr: `col 18: expected quoted string, got identifier "Mon"`},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := CompileFilter(tt.filterExpr)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseFilterKeywords(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		criteria   *imap.SearchCriteria
+		exact      bool
+		gmail      []gmailQuery
+	}{
+		{
+			filterExpr: "KEYWORD == '$Label1'",
+			criteria:   &imap.SearchCriteria{Flag: []imap.Flag{"$Label1"}},
+			exact:      true,
+		},
+		{
+			filterExpr: `HASFLAG $MDNSent && keyword != '\Seen'`,
+			criteria: &imap.SearchCriteria{
+				Flag:    []imap.Flag{"$MDNSent"},
+				NotFlag: []imap.Flag{imap.FlagSeen},
+			},
+			exact: true,
+		},
+		{
+			filterExpr: "X-GM-LABELS == 'Alerts' && UNSEEN",
+			criteria:   &imap.SearchCriteria{NotFlag: []imap.Flag{imap.FlagSeen}},
+			gmail:      []gmailQuery{{key: gmailFieldLabels, value: "Alerts"}},
+		},
+		{
+			filterExpr: "!(X-GM-RAW == 'has:attachment' || X-GM-LABELS != 'Alerts')",
+			criteria:   &imap.SearchCriteria{},
+			gmail: []gmailQuery{
+				{key: gmailFieldRaw, value: "has:attachment"},
+				{key: gmailFieldLabels, value: "Alerts"},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.criteria, filter.Criteria())
+			assert.Equal(t, tt.exact, filter.Exact())
+			assert.Equal(t, tt.gmail, filter.gmailQueries())
+		})
+	}
+}
+
+func TestParseFilterKeywordErrors(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		wantErr    string
+	}{
+		{filterExpr: "KEYWORD ~= 'Label'", wantErr: `col 9: expected '==' or '!=' after field "KEYWORD", got '~='`},
+		{filterExpr: "KEYWORD == 'two words'", wantErr: `col 12: invalid flag "two words"`},
+		{filterExpr: "HASFLAG", wantErr: "col 8: expected value, got end of expression"},
+		{filterExpr: "X-GM-LABELS == 'Alerts's", wantErr: `col 16: field "X-GM-LABELS" does not support string modifiers`},
+		{filterExpr: "X-GM-RAW == ''", wantErr: "col 13: X-GM-RAW value must be from 1 to 4096 bytes long"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := CompileFilter(tt.filterExpr)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNewFilterMatcher(t *testing.T) {
+	clients := func(filters ...string) []config.ClientConfig {
+		return []config.ClientConfig{{
+			Login:         "user@example.com",
+			ContactPoints: []config.ContactPointConfiguration{{Filters: filters}},
+		}}
+	}
+
+	tests := []struct {
+		filters []string
+		wantErr string
+	}{
+		{filters: []string{"SUBJECT == 'prod'", "!SEEN"}},
+		{
+			filters: []string{"SUBJECT == 'prod"},
+			wantErr: `client "user@example.com": parse filter expression "SUBJECT == 'prod": col 12: missing closing quote`,
+		},
+		{
+			filters: []string{"X-GM-LABELS == 'Alerts'"},
+			wantErr: `client "user@example.com": filter expression "X-GM-LABELS == 'Alerts'": Gmail extensions can not be evaluated on retrieved messages`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := NewFilterMatcher(clients(tt.filters...))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+
+	// Digest urgent filters are compiled as well.
+	_, err := NewFilterMatcher([]config.ClientConfig{{
+		Login: "user@example.com",
+		ContactPoints: []config.ContactPointConfiguration{{
+			Digest: config.DigestConfiguration{UrgentFilters: []string{"SUBJECT ~= '('"}},
+		}},
+	}})
+	assert.ErrorContains(t, err, `client "user@example.com": parse filter expression "SUBJECT ~= '('"`)
+}
+
+func TestParseFilterAttachmentPredicates(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		criteria   *imap.SearchCriteria
+		ast        string
+	}{
+		{
+			filterExpr: "has attachment && UNSEEN",
+			criteria:   &imap.SearchCriteria{NotFlag: []imap.Flag{imap.FlagSeen}},
+			ast:        "(AND (HAS ATTACHMENT) (FLAG UNSEEN))",
+		},
+		{
+			filterExpr: `ATTACHMENT.NAME ~= '\.pdf$' || attachment.type == 'application/pdf'`,
+			criteria: &imap.SearchCriteria{
+				Or: [][2]imap.SearchCriteria{{{}, {}}},
+			},
+			ast: `(OR (ATTACHMENT.NAME ~= "\\.pdf$") (ATTACHMENT.TYPE == "application/pdf"))`,
+		},
+		{
+			filterExpr: "ATTACHMENT.SIZE > 5M",
+			criteria:   &imap.SearchCriteria{Larger: 5000000},
+			ast:        "(ATTACHMENT.SIZE > 5000000)",
+		},
+		{
+			filterExpr: "ATTACHMENT.SIZE <= 1k",
+			criteria:   &imap.SearchCriteria{},
+			ast:        "(ATTACHMENT.SIZE <= 1000)",
+		},
+		{
+			filterExpr: "HAS == 'value'",
+			criteria: &imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "HAS", Value: "value"}},
+			},
+			ast: `(HAS == "value")`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.criteria, filter.Criteria())
+			assert.Equal(t, tt.ast, filter.String())
+		})
+	}
+}
+
+func TestParseFilterAttachmentPredicateErrors(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		wantErr    string
+	}{
+		{filterExpr: "HAS", wantErr: `col 4: expected "ATTACHMENT" after "HAS", got end of expression`},
+		{filterExpr: "HAS ATTACHMENTS", wantErr: `col 5: expected "ATTACHMENT" after "HAS", got identifier "ATTACHMENTS"`},
+		{filterExpr: "ATTACHMENT.DATE > '2025-01-01'", wantErr: `col 1: unknown attachment field "ATTACHMENT.DATE"`},
+		{filterExpr: "ATTACHMENT.SIZE == 'big'", wantErr: `col 20: invalid size "big"`},
+		{filterExpr: "ATTACHMENT.NAME ~= '('", wantErr: "col 20: invalid pattern: error parsing regexp: missing closing ): `(?i)(`"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := CompileFilter(tt.filterExpr)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestFilterMatchAttachments(t *testing.T) {
+	msg := &mailer.Message{
+		Attachments: []mailer.Attachment{{
+			BodySegment: mailer.BodySegment{MIMEType: "application/pdf", Size: 200 * units.KB},
+			Filename:    "Report.PDF",
+		}},
+		OmittedAttachments: []mailer.Attachment{{
+			BodySegment: mailer.BodySegment{MIMEType: "application/zip", Size: 20 * units.MB},
+			Filename:    "dump.zip",
+		}},
+	}
+
+	tests := []struct {
+		filterExpr string
+		message    *mailer.Message
+		want       bool
+	}{
+		{filterExpr: "HAS ATTACHMENT", message: msg, want: true},
+		{filterExpr: "HAS ATTACHMENT", message: &mailer.Message{}, want: false},
+		{filterExpr: `ATTACHMENT.NAME ~= '\.pdf$'`, message: msg, want: true},
+		{filterExpr: `ATTACHMENT.NAME ~= '\.pdf$'s`, message: msg, want: false},
+		{filterExpr: "ATTACHMENT.NAME *= '*.zip' && ATTACHMENT.TYPE == 'application/zip'", message: msg, want: true},
+		{filterExpr: "ATTACHMENT.TYPE != 'application/'", message: msg, want: false},
+		{filterExpr: "ATTACHMENT.TYPE != 'image/'", message: msg, want: true},
+		{filterExpr: "ATTACHMENT.TYPE ^= 'image/'", message: msg, want: false},
+		{filterExpr: "ATTACHMENT.SIZE > 5M", message: msg, want: true},
+		{filterExpr: "ATTACHMENT.SIZE < 100kB", message: msg, want: false},
+		{filterExpr: "ATTACHMENT.SIZE > 5M", message: &mailer.Message{}, want: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+
+			got, err := filter.Match(tt.message)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got, "filter %q", tt.filterExpr)
+		})
+	}
+}
+
+func TestParseFilterAuthPredicates(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		ast        string
+	}{
+		{filterExpr: "DKIM == 'pass'", ast: `(DKIM == "pass")`},
+		{filterExpr: "dmarc != FAIL && SUBJECT == 'prod'", ast: `(AND (DMARC != "fail") (SUBJECT == "prod"))`},
+		{filterExpr: "SPF == softfail || SPF == 'none'", ast: `(OR (SPF == "softfail") (SPF == "none"))`},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.False(t, filter.Exact())
+			assert.Equal(t, tt.ast, filter.String())
+		})
+	}
+}
+
+func TestParseFilterAuthPredicateErrors(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		wantErr    string
+	}{
+		{filterExpr: "DKIM ^= 'pass'", wantErr: `col 6: expected '==' or '!=' after field "DKIM", got '^='`},
+		{filterExpr: "DMARC == 'passed'", wantErr: `col 10: unknown DMARC result "passed", expected one of 'none', 'pass', 'fail', 'policy', 'neutral', 'softfail', 'temperror', 'permerror'`},
+		{filterExpr: "SPF == 'pass'i", wantErr: `col 8: field "SPF" does not support string modifiers`},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := CompileFilter(tt.filterExpr)
+			assert.EqualError(t, err, tt.wantErr)
 		})
 	}
 }
+
+func TestFilterMatchAuth(t *testing.T) {
+	msg := &mailer.Message{Authentication: mailer.Authentication{
+		DKIM:        mailer.AuthPass,
+		DKIMDomains: []string{"example.com"},
+		SPF:         mailer.AuthSoftFail,
+	}}
+
+	tests := []struct {
+		filterExpr string
+		message    *mailer.Message
+		want       bool
+	}{
+		{filterExpr: "DKIM == 'pass'", message: msg, want: true},
+		{filterExpr: "DKIM != 'pass'", message: msg, want: false},
+		{filterExpr: "SPF == 'softfail'", message: msg, want: true},
+		{filterExpr: "DMARC == 'none'", message: msg, want: true},
+		{filterExpr: "DMARC != 'fail'", message: msg, want: true},
+		{filterExpr: "DKIM == 'pass'", message: &mailer.Message{}, want: false},
+		{filterExpr: "DKIM == 'none'", message: &mailer.Message{}, want: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+
+			got, err := filter.Match(tt.message)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got, "filter %q", tt.filterExpr)
+		})
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	err := ValidateFilters([]config.ClientConfig{{
+		Login:   "user@example.com",
+		Filters: []string{"!SEEN", "FROM == 'a", "X-GM-LABELS == 'Alerts'"},
+		ContactPoints: []config.ContactPointConfiguration{{
+			Filters: []string{"SUBJECT == 'prod'", "X-GM-LABELS == 'Alerts'"},
+			Digest:  config.DigestConfiguration{UrgentFilters: []string{"SUBJECT =="}},
+		}},
+	}})
+
+	assert.EqualError(t, err, `client "user@example.com": parse filter expression "FROM == 'a": col 9: missing closing quote`+"\n"+
+		`client "user@example.com": filter expression "X-GM-LABELS == 'Alerts'": Gmail extensions can not be evaluated on retrieved messages`+"\n"+
+		`client "user@example.com": parse filter expression "SUBJECT ==": col 11: expected quoted string, got end of expression`)
+}
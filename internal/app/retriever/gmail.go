@@ -0,0 +1,273 @@
+package retriever
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// capGmailExt is advertised by servers supporting Gmail IMAP extensions.
+const capGmailExt imap.Cap = "X-GM-EXT-1"
+
+// maxGmailLiteralSize is maximum size of non-synchronizing
+// literal accepted by servers supporting LITERAL- extension.
+const maxGmailLiteralSize = 4096
+
+// ConnDialer establishes raw connections to IMAP servers. ImapDialer
+// implementing it is used for searches with Gmail extensions as well.
+type ConnDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// defaultGmailDialer connects to server over TLS, if
+// injected ImapDialer is not able to dial raw connections.
+var defaultGmailDialer ConnDialer = &tls.Dialer{}
+
+// searchGmail searches for messages among uids matching Gmail extension
+// search keys used by filters. Searches are performed over separate
+// connection, as IMAP client is not able to send such keys.
+func (r *imapRetriever) searchGmail(
+	ctx context.Context,
+	capabilities imap.CapSet,
+	cfg config.ClientConfig,
+	uids imap.UIDSet,
+	filters []*Filter,
+) (map[gmailQuery]imap.UIDSet, error) {
+	var queries []gmailQuery
+	for _, filter := range filters {
+		queries = append(queries, filter.gmailQueries()...)
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	if !capabilities.Has(capGmailExt) {
+		return nil, fmt.Errorf("server does not support %s extension used by filters", capGmailExt)
+	}
+
+	conn, err := r.gmailDialer.DialContext(ctx, "tcp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	return newGmailConn(conn, capabilities).search(ctx, cfg, uids, queries)
+}
+
+// gmailConn is minimal IMAP client sending commands
+// with Gmail extension search keys.
+type gmailConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	// capabilities advertised by server, determining
+	// whether non-synchronizing literals may be sent.
+	capabilities imap.CapSet
+}
+
+func newGmailConn(conn net.Conn, capabilities imap.CapSet) *gmailConn {
+	return &gmailConn{conn: conn, r: bufio.NewReader(conn), capabilities: capabilities}
+}
+
+func (c *gmailConn) search(
+	ctx context.Context,
+	cfg config.ClientConfig,
+	uids imap.UIDSet,
+	queries []gmailQuery,
+) (map[gmailQuery]imap.UIDSet, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("set deadline: %w", err)
+		}
+	}
+	// Blocked reads and writes are interrupted on cancellation.
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	greeting, err := c.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("read greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return nil, fmt.Errorf("unexpected greeting %q", greeting)
+	}
+
+	if _, err = c.command("LOGIN", c.string(cfg.Login), c.string(string(cfg.Password))); err != nil {
+		return nil, err
+	}
+	if _, err = c.command("EXAMINE", "INBOX"); err != nil {
+		return nil, err
+	}
+
+	results := make(map[gmailQuery]imap.UIDSet, len(queries))
+	for _, query := range queries {
+		if _, ok := results[query]; ok {
+			continue
+		}
+
+		args := []string{"SEARCH"}
+		if !isASCII(query.value) {
+			args = append(args, "CHARSET", "UTF-8")
+		}
+		args = append(args, "UID", uids.String(), query.key, c.string(query.value))
+
+		lines, err := c.command("UID", args...)
+		if err != nil {
+			return nil, err
+		}
+
+		results[query], err = parseSearchResponse(lines)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s search response: %w", query.key, err)
+		}
+	}
+
+	_, _ = c.command("LOGOUT")
+
+	return results, nil
+}
+
+// command sends command and returns untagged
+// responses received until its completion.
+func (c *gmailConn) command(name string, args ...string) ([]string, error) {
+	c.tag++
+	tag := "G" + strconv.Itoa(c.tag)
+
+	cmd := strings.Join(append([]string{tag, name}, args...), " ") + "\r\n"
+	for {
+		// Quoted strings and atoms can not contain line breaks,
+		// so every one but the last follows literal size.
+		end := strings.Index(cmd, "\r\n") + len("\r\n")
+		line := cmd[:end]
+		if _, err := c.conn.Write([]byte(line)); err != nil {
+			return nil, fmt.Errorf("send %s command: %w", name, err)
+		}
+		if end == len(cmd) {
+			break
+		}
+
+		sizeStart := strings.LastIndexByte(line, '{') + 1
+		size, err := strconv.Atoi(strings.TrimSuffix(line[sizeStart:len(line)-len("}\r\n")], "+"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s command literal size: %w", name, err)
+		}
+
+		// Server has to confirm it is ready to
+		// receive synchronizing literal data.
+		if !strings.HasSuffix(line, "+}\r\n") {
+			if err = c.waitContinuation(name, tag); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err = c.conn.Write([]byte(cmd[end : end+size])); err != nil {
+			return nil, fmt.Errorf("send %s command: %w", name, err)
+		}
+		cmd = cmd[end+size:]
+	}
+
+	var untagged []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("read %s response: %w", name, err)
+		}
+
+		status, ok := strings.CutPrefix(line, tag+" ")
+		if !ok {
+			untagged = append(untagged, line)
+			continue
+		}
+
+		if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+			return nil, fmt.Errorf("%s command failed: %s", name, status)
+		}
+
+		return untagged, nil
+	}
+}
+
+// waitContinuation reads responses until continuation request
+// for literal data or command completion, which is its rejection.
+func (c *gmailConn) waitContinuation(name, tag string) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return fmt.Errorf("read %s continuation request: %w", name, err)
+		}
+
+		if strings.HasPrefix(line, "+") {
+			return nil
+		}
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			return fmt.Errorf("%s command failed: %s", name, status)
+		}
+	}
+}
+
+func (c *gmailConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseSearchResponse returns UIDs listed by untagged SEARCH responses.
+func parseSearchResponse(lines []string) (imap.UIDSet, error) {
+	var uids []imap.UID
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+
+		for _, field := range fields[2:] {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UID %q", field)
+			}
+
+			uids = append(uids, imap.UID(uid))
+		}
+	}
+
+	return imap.UIDSetNum(uids...), nil
+}
+
+// string encodes s as IMAP quoted string or, if it contains characters
+// quoted strings can not, literal. Literals are non-synchronizing, if
+// server supports LITERAL+ extension or LITERAL- one and they are small.
+func (c *gmailConn) string(s string) string {
+	if isASCII(s) && !strings.ContainsAny(s, "\r\n") {
+		return quoteSearchString(s)
+	}
+
+	if c.capabilities.Has(imap.CapLiteralPlus) ||
+		(c.capabilities.Has(imap.CapLiteralMinus) && len(s) <= maxGmailLiteralSize) {
+		return fmt.Sprintf("{%d+}\r\n%s", len(s), s)
+	}
+
+	return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
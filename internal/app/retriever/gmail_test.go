@@ -0,0 +1,220 @@
+package retriever
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type gmailExchange struct {
+	request  []string
+	response []string
+}
+
+// serveGmail replays exchanges on server side of connection
+// and returns requests received from client.
+func serveGmail(t *testing.T, conn net.Conn, exchanges []gmailExchange) <-chan []string {
+	t.Helper()
+
+	received := make(chan []string, 1)
+
+	go func() {
+		defer conn.Close()
+
+		var requests []string
+		defer func() { received <- requests }()
+
+		r := bufio.NewReader(conn)
+		if _, err := conn.Write([]byte("* OK Gimap ready\r\n")); err != nil {
+			return
+		}
+
+		for _, exchange := range exchanges {
+			for range exchange.request {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				requests = append(requests, strings.TrimRight(line, "\r\n"))
+			}
+
+			for _, line := range exchange.response {
+				if _, err := conn.Write([]byte(line + "\r\n")); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return received
+}
+
+func TestSearchGmail(t *testing.T) {
+	cfg := config.ClientConfig{Login: "user@gmail.com", Password: `pa"ss`}
+	uids := imap.UIDSet{imap.UIDRange{Start: 10, Stop: 20}}
+
+	tests := []struct {
+		queries      []gmailQuery
+		capabilities imap.CapSet
+		exchanges    []gmailExchange
+		want         map[gmailQuery]imap.UIDSet
+		wantErr      string
+	}{
+		{
+			queries: []gmailQuery{
+				{key: gmailFieldLabels, value: "Alerts"},
+				{key: gmailFieldRaw, value: "subject:отчёт"},
+				{key: gmailFieldLabels, value: "Alerts"},
+			},
+			capabilities: imap.CapSet{imap.CapLiteralPlus: {}},
+			exchanges: []gmailExchange{
+				{
+					request:  []string{`G1 LOGIN "user@gmail.com" "pa\"ss"`},
+					response: []string{"G1 OK user@gmail.com authenticated (Success)"},
+				},
+				{
+					request:  []string{"G2 EXAMINE INBOX"},
+					response: []string{"* 20 EXISTS", "G2 OK [READ-ONLY] INBOX selected. (Success)"},
+				},
+				{
+					request:  []string{`G3 UID SEARCH UID 10:20 X-GM-LABELS "Alerts"`},
+					response: []string{"* SEARCH 12 15 16", "G3 OK SEARCH completed (Success)"},
+				},
+				{
+					request:  []string{"G4 UID SEARCH CHARSET UTF-8 UID 10:20 X-GM-RAW {18+}", "subject:отчёт"},
+					response: []string{"* SEARCH", "G4 OK SEARCH completed (Success)"},
+				},
+				{
+					request:  []string{"G5 LOGOUT"},
+					response: []string{"* BYE LOGOUT Requested", "G5 OK 73 good day (Success)"},
+				},
+			},
+			want: map[gmailQuery]imap.UIDSet{
+				{key: gmailFieldLabels, value: "Alerts"}:     imap.UIDSetNum(12, 15, 16),
+				{key: gmailFieldRaw, value: "subject:отчёт"}: imap.UIDSetNum(),
+			},
+		},
+		{
+			// Synchronizing literals are sent without LITERAL+ and LITERAL- support.
+			queries: []gmailQuery{{key: gmailFieldRaw, value: "subject:отчёт"}},
+			exchanges: []gmailExchange{
+				{
+					request:  []string{`G1 LOGIN "user@gmail.com" "pa\"ss"`},
+					response: []string{"G1 OK user@gmail.com authenticated (Success)"},
+				},
+				{
+					request:  []string{"G2 EXAMINE INBOX"},
+					response: []string{"G2 OK [READ-ONLY] INBOX selected. (Success)"},
+				},
+				{
+					request:  []string{"G3 UID SEARCH CHARSET UTF-8 UID 10:20 X-GM-RAW {18}"},
+					response: []string{"+ go ahead"},
+				},
+				{
+					request:  []string{"subject:отчёт"},
+					response: []string{"* SEARCH 11", "G3 OK SEARCH completed (Success)"},
+				},
+				{
+					request:  []string{"G4 LOGOUT"},
+					response: []string{"G4 OK 73 good day (Success)"},
+				},
+			},
+			want: map[gmailQuery]imap.UIDSet{
+				{key: gmailFieldRaw, value: "subject:отчёт"}: imap.UIDSetNum(11),
+			},
+		},
+		{
+			queries: []gmailQuery{{key: gmailFieldRaw, value: "subject:отчёт"}},
+			exchanges: []gmailExchange{
+				{
+					request:  []string{`G1 LOGIN "user@gmail.com" "pa\"ss"`},
+					response: []string{"G1 OK user@gmail.com authenticated (Success)"},
+				},
+				{
+					request:  []string{"G2 EXAMINE INBOX"},
+					response: []string{"G2 OK [READ-ONLY] INBOX selected. (Success)"},
+				},
+				{
+					request:  []string{"G3 UID SEARCH CHARSET UTF-8 UID 10:20 X-GM-RAW {18}"},
+					response: []string{"G3 BAD Could not parse command"},
+				},
+			},
+			wantErr: "UID command failed: BAD Could not parse command",
+		},
+		{
+			queries: []gmailQuery{{key: gmailFieldLabels, value: "Alerts"}},
+			exchanges: []gmailExchange{{
+				request:  []string{`G1 LOGIN "user@gmail.com" "pa\"ss"`},
+				response: []string{"G1 NO [AUTHENTICATIONFAILED] Invalid credentials (Failure)"},
+			}},
+			wantErr: "LOGIN command failed: NO [AUTHENTICATIONFAILED] Invalid credentials (Failure)",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			client, server := net.Pipe()
+			received := serveGmail(t, server, tt.exchanges)
+
+			got, err := newGmailConn(client, tt.capabilities).search(context.Background(), cfg, uids, tt.queries)
+			_ = client.Close()
+
+			var wantRequests []string
+			for _, exchange := range tt.exchanges {
+				wantRequests = append(wantRequests, exchange.request...)
+			}
+			assert.Equal(t, wantRequests, <-received)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSearchGmailDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// Server never sends greeting.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := newGmailConn(client, nil).search(ctx, config.ClientConfig{}, imap.UIDSet{}, nil)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestMatchGmailFilters(t *testing.T) {
+	filters, err := compileFilters([]string{"X-GM-LABELS == 'Alerts' || X-GM-RAW == 'is:important'"})
+	require.NoError(t, err)
+
+	gmail := map[gmailQuery]imap.UIDSet{
+		{key: gmailFieldLabels, value: "Alerts"}:    imap.UIDSetNum(12),
+		{key: gmailFieldRaw, value: "is:important"}: imap.UIDSetNum(15),
+	}
+
+	for uid, want := range map[uint32]bool{12: true, 14: false, 15: true} {
+		ok, err := matchFilters(filters, &mailer.Message{UID: uid}, gmail)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, "UID %d", uid)
+	}
+
+	_, err = matchFilters(filters, &mailer.Message{UID: 12}, nil)
+	assert.EqualError(t, err, "X-GM-LABELS search results are not available")
+}
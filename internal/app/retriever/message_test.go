@@ -0,0 +1,126 @@
+package retriever
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/app/mailer"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessageHeader(t *testing.T) {
+	raw := "From: Monitoring <alerts@example.com>\r\n" +
+		"To: oncall@example.com\r\n" +
+		"Reply-To: noc@example.com\r\n" +
+		"Subject: =?utf-8?q?Disk_usage_=E2=80=94_97%?=\r\n" +
+		"Date: Mon, 10 Mar 2025 22:30:00 +0000\r\n" +
+		"Message-ID: <abc.123@example.com>\r\n" +
+		"In-Reply-To: <parent@example.com>\r\n" +
+		"References: <root@example.com> <parent@example.com>\r\n" +
+		"List-Id: Alerts list <alerts.example.com>\r\n" +
+		"X-Priority: 1 (Highest)\r\n" +
+		"Authentication-Results: mx.example.com; dkim=pass header.d=example.com\r\n" +
+		"X-Custom: first\r\n" +
+		"X-Custom: second\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"body\r\n"
+
+	mr, err := mail.CreateReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var msg mailer.Message
+	parseMessageHeader(&msg, mr.Header)
+
+	assert.Equal(t, []mailer.Address{{Name: "Monitoring", Address: "alerts@example.com"}}, msg.From)
+	assert.Equal(t, []mailer.Address{{Address: "noc@example.com"}}, msg.ReplyTo)
+	assert.Equal(t, "Disk usage — 97%", msg.Subject)
+	assert.True(t, time.Date(2025, time.March, 10, 22, 30, 0, 0, time.UTC).Equal(msg.Date))
+	assert.Equal(t, "abc.123@example.com", msg.MessageID)
+	assert.Equal(t, []string{"parent@example.com"}, msg.InReplyTo)
+	assert.Equal(t, []string{"root@example.com", "parent@example.com"}, msg.References)
+	assert.Equal(t, "alerts.example.com", msg.ListID)
+	assert.Equal(t, mailer.PriorityHigh, msg.Priority)
+	assert.Equal(t, []string{"mx.example.com; dkim=pass header.d=example.com"}, msg.AuthenticationResults)
+	assert.Equal(t, []string{"first", "second"}, msg.Header.Values("x-custom"))
+	assert.Equal(t, "Disk usage — 97%", msg.Header.Get("Subject"))
+}
+
+func TestParseEmbeddedMessage(t *testing.T) {
+	raw := "From: oncall@example.com\r\n" +
+		"Subject: Fwd: Disk usage\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See below\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"From: alerts@example.com\r\n" +
+		"Subject: Disk usage\r\n" +
+		"Content-Type: multipart/related; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>97%</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer--\r\n"
+
+	var msg mailer.Message
+	err := parseMessageBody(&msg, strings.NewReader(raw), config.ClientConfig{}, newSpooler(config.SpoolConfiguration{}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Fwd: Disk usage", msg.Subject)
+	require.Len(t, msg.BodyParts, 1)
+	require.Len(t, msg.Embedded, 1)
+
+	embedded := msg.Embedded[0]
+	assert.Equal(t, "Disk usage", embedded.Subject)
+	assert.Equal(t, []mailer.Address{{Address: "alerts@example.com"}}, embedded.From)
+	require.Len(t, embedded.BodyParts, 1)
+	assert.Equal(t, "text/html", embedded.BodyParts[0].MIMEType)
+
+	b, err := io.ReadAll(embedded.BodyParts[0].Body)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>97%</p>", string(b))
+}
+
+func TestReadMessage(t *testing.T) {
+	raw := "Authentication-Results: mx.example.org; dmarc=fail header.from=example.com\r\n" +
+		"From: alerts@example.com\r\n" +
+		"Subject: Report\r\n" +
+		"Date: Mon, 10 Mar 2025 22:30:00 +0000\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See attached.\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=report.pdf\r\n" +
+		"\r\n" +
+		"%PDF-1.7\r\n" +
+		"--b--\r\n"
+
+	msg, err := ReadMessage(strings.NewReader(raw), config.SpoolConfiguration{}, []string{"mx.example.org"})
+	require.NoError(t, err)
+	defer msg.Close()
+
+	assert.Equal(t, "Report", msg.Subject)
+	assert.Equal(t, int64(len(raw)), msg.Size)
+	assert.Equal(t, msg.Date, msg.InternalDate)
+	assert.Equal(t, mailer.Authentication{DMARC: mailer.AuthFail}, msg.Authentication)
+	require.Len(t, msg.BodyParts, 1)
+	require.Len(t, msg.Attachments, 1)
+	assert.Equal(t, "report.pdf", msg.Attachments[0].Filename)
+}
@@ -1,13 +1,15 @@
 package retriever
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"mime"
+	"net"
+	"net/textproto"
+	"slices"
 	"strings"
 	"time"
 
@@ -33,13 +35,27 @@ func (f ImapDialerFunc) DialTLS(address string, options *imapclient.Options) (*i
 
 type imapRetriever struct {
 	dialer ImapDialer
-	logger *slog.Logger
+	// gmailDialer connects to server for searches with
+	// Gmail extensions, not supported by IMAP client.
+	gmailDialer ConnDialer
+	// resolver looks up public keys of DKIM signatures.
+	resolver TXTResolver
+	spooler  spooler
+	logger   *slog.Logger
 }
 
-func NewIMAPRetriever(dialer ImapDialer, logger *slog.Logger) *imapRetriever {
+func NewIMAPRetriever(dialer ImapDialer, spoolCfg config.SpoolConfiguration, logger *slog.Logger) *imapRetriever {
+	gmailDialer := defaultGmailDialer
+	if connDialer, ok := dialer.(ConnDialer); ok {
+		gmailDialer = connDialer
+	}
+
 	return &imapRetriever{
-		dialer: dialer,
-		logger: logger,
+		dialer:      dialer,
+		gmailDialer: gmailDialer,
+		resolver:    net.DefaultResolver,
+		spooler:     newSpooler(spoolCfg),
+		logger:      logger,
 	}
 }
 
@@ -56,18 +72,19 @@ func NewIMAPRetriever(dialer ImapDialer, logger *slog.Logger) *imapRetriever {
 //   - Build a search criteria based on the filters and the client's LastUIDNext.
 //   - Perform a search on the server to get the UIDs of matching messages.
 //   - Otherwise, fetch all messages since the client's LastUIDNext (inclusive).
+//   - If filters use Gmail extensions, search for messages matching them separately.
 //
-// 6. For each message:
-//   - Extract the UID, sender, recipients, CC recipients, date, and subject.
-//   - Process each part of the message (text body or attachment):
-//   - For text parts:
-//   - Read the content and determine MIME type and character set.
-//   - Add the body segment to the message.
-//   - For attachments (if inclusion is enabled):
-//   - Parse the attachment information (not yet implemented).
-//   - Add the attachment to the message (not yet implemented).
+// 6. Fetch metadata of every message: flags, size, header and BODYSTRUCTURE.
+// 7. For each message:
+//   - Extract the UID, sender, recipients, CC recipients, date, subject and other headers.
+//   - Select parts to be fetched using message structure:
+//   - text/plain and text/html parts used for notification rendering;
+//   - attachments (if inclusion is enabled) not exceeding maximum attachments size.
+//   - Fetch selected parts only, decode and store them in the body segments and attachments.
+//   - Parse trusted 'Authentication-Results' headers and, if enabled, verify DKIM signatures
+//     of the whole message.
 //
-// 7. Return the retrieved messages and any encountered errors.
+// 8. Return the retrieved messages and any encountered errors.
 // Lacks of appropriate error and behaviour handling, need to handle such cases:
 //   - Dial TLS failure
 //   - Login failure
@@ -94,7 +111,7 @@ func (r *imapRetriever) GetMail(ctx context.Context, cfg config.ClientConfig) (m
 		return mail, fmt.Errorf("dial TLS: %w", err)
 	}
 
-	if err = client.Login(cfg.Login, cfg.Password).Wait(); err != nil {
+	if err = client.Login(cfg.Login, string(cfg.Password)).Wait(); err != nil {
 		return mail, fmt.Errorf("login: %w", err)
 	}
 
@@ -123,35 +140,66 @@ func (r *imapRetriever) GetMail(ctx context.Context, cfg config.ClientConfig) (m
 		Start: imap.UID(cfg.LastUIDNext),
 		Stop:  imap.UID(mail.LastUID),
 	}}
-	if len(cfg.Filters) > 0 && capabilities.Has(imap.CapESearch) {
-		uids, err = getUIDsByCriteria(client, cfg)
+
+	filters, err := compileFilters(cfg.Filters)
+	if err != nil {
+		return mail, fmt.Errorf("compile filters: %w", err)
+	}
+
+	gmailUIDs, err := r.searchGmail(ctx, capabilities, cfg, uids, filters)
+	if err != nil {
+		return mail, fmt.Errorf("search with Gmail extensions: %w", err)
+	}
+
+	// Servers without ESEARCH support are not trusted to filter messages,
+	// so the same filters are evaluated on client side. The same applies
+	// to filters IMAP SEARCH is able to narrow messages down only.
+	filterLocally := len(filters) > 0 &&
+		(!capabilities.Has(imap.CapESearch) || slices.ContainsFunc(filters, isInexactFilter))
+	if len(filters) > 0 && capabilities.Has(imap.CapESearch) {
+		uids, err = getUIDsByCriteria(client, buildSearchCriteria(filters, cfg.LastUIDNext))
 		if err != nil {
 			return mail, fmt.Errorf("get UID set by search criteria: %w", err)
 		}
 	}
 
-	fetchCmd := client.Fetch(uids, fetchOptions)
-	defer func() {
-		_ = fetchCmd.Close()
-	}()
-
-	for {
-		msg := fetchCmd.Next()
-		if msg == nil {
-			break
-		}
+	// Message structure is fetched first to
+	// retrieve only required parts afterwards.
+	buffers, err := client.Fetch(uids, metadataFetchOptions).Collect()
+	if err != nil {
+		return mail, fmt.Errorf("fetch messages metadata: %w", err)
+	}
 
+	for _, buf := range buffers {
 		var message *mailer.Message
-		message, err = parseMessage(msg, cfg)
+		message, err = r.fetchMessage(client, buf, cfg)
 		if err != nil {
+			_ = mail.Close()
 			return mail, fmt.Errorf("process message: %w", err)
 		}
-		// TODO(hickar): handle message filtering in case of remote IMAP server inability
-		// to filter messages based on sent search criteria
-		//
-		// if !capabilities.Has(imap.CapESearch) {
-		// 	...
-		// }
+
+		if cfg.VerifyDKIM {
+			if err = r.verifyMessageDKIM(ctx, client, message); err != nil {
+				_ = message.Close()
+				_ = mail.Close()
+				return mail, fmt.Errorf("verify DKIM signatures of message %d: %w", message.UID, err)
+			}
+		}
+
+		if filterLocally {
+			var ok bool
+			ok, err = matchFilters(filters, message, gmailUIDs)
+			if err != nil {
+				_ = message.Close()
+				_ = mail.Close()
+				return mail, fmt.Errorf("match message against filters: %w", err)
+			}
+			if !ok {
+				r.logger.DebugContext(ctx, "message skipped by filters", slog.Any("uid", message.UID))
+				_ = message.Close()
+				continue
+			}
+		}
 
 		mail.Messages = append(mail.Messages, message)
 	}
@@ -159,12 +207,7 @@ func (r *imapRetriever) GetMail(ctx context.Context, cfg config.ClientConfig) (m
 	return mail, err
 }
 
-func getUIDsByCriteria(c *imapclient.Client, client config.ClientConfig) (imap.UIDSet, error) {
-	criteria, err := buildSearchCriteria(client.Filters, client.LastUIDNext)
-	if err != nil {
-		return nil, fmt.Errorf("build search criteria: %w", err)
-	}
-
+func getUIDsByCriteria(c *imapclient.Client, criteria *imap.SearchCriteria) (imap.UIDSet, error) {
 	cmd, err := c.UIDSearch(criteria, nil).Wait()
 	if err != nil {
 		return nil, fmt.Errorf("search: %w", err)
@@ -174,54 +217,81 @@ func getUIDsByCriteria(c *imapclient.Client, client config.ClientConfig) (imap.U
 	return uids, nil
 }
 
-func parseMessage(msg *imapclient.FetchMessageData, client config.ClientConfig) (*mailer.Message, error) {
+func parseMessage(msg *imapclient.FetchMessageData, client config.ClientConfig, sp spooler) (*mailer.Message, error) {
 	var (
-		uidSection  imapclient.FetchItemDataUID
-		bodySection imapclient.FetchItemDataBodySection
-		ok          bool
+		message = &mailer.Message{}
+		hasUID  bool
+		hasBody bool
 	)
 
-	item := msg.Next()
-	if item == nil {
-		return nil, errors.New("first message part is nil")
-	}
-	uidSection, ok = item.(imapclient.FetchItemDataUID)
-	if !ok {
-		return nil, errors.New("first message part is not UID section")
-	}
-
+	// Data items order is not defined by IMAP protocol,
+	// and body section literal has to be consumed
+	// before proceeding to the next item.
 	for {
 		item := msg.Next()
 		if item == nil {
 			break
 		}
 
-		bodySection, ok = item.(imapclient.FetchItemDataBodySection)
-		if ok {
-			break
+		switch item := item.(type) {
+		case imapclient.FetchItemDataUID:
+			message.UID = uint32(item.UID)
+			hasUID = true
+
+		case imapclient.FetchItemDataFlags:
+			message.Flags = make([]string, 0, len(item.Flags))
+			for _, flag := range item.Flags {
+				message.Flags = append(message.Flags, string(flag))
+			}
+
+		case imapclient.FetchItemDataInternalDate:
+			message.InternalDate = item.Time
+
+		case imapclient.FetchItemDataRFC822Size:
+			message.Size = item.Size
+
+		case imapclient.FetchItemDataModSeq:
+			message.ModSeq = item.ModSeq
+
+		case imapclient.FetchItemDataBodySection:
+			if err := parseMessageBody(message, item.Literal, client, sp); err != nil {
+				_ = message.Close()
+				return nil, fmt.Errorf("parse body: %w", err)
+			}
+			hasBody = true
 		}
 	}
-	if !ok {
+
+	if !hasUID {
+		return nil, errors.New("message UID is missing")
+	}
+	if !hasBody {
 		return nil, errors.New("message body section is nil")
 	}
 
-	mr, err := mail.CreateReader(bodySection.Literal)
-	if err != nil {
-		return nil, fmt.Errorf("create reader: %w", err)
+	return message, nil
+}
+
+// parseMessageBody parses message header and its parts. Parts content is
+// streamed into spool, attachments exceeding configured size limit are
+// skipped without being read into memory. Messages embedded into
+// 'message/rfc822' parts are parsed recursively.
+func parseMessageBody(message *mailer.Message, literal io.Reader, client config.ClientConfig, sp spooler) error {
+	return parseEmbeddedMessageBody(message, literal, client, sp, 0)
+}
+
+func parseEmbeddedMessageBody(msg *mailer.Message, literal io.Reader, client config.ClientConfig, sp spooler, depth int) error {
+	// Content in unknown charsets is decoded
+	// later along with undeclared ones.
+	mr, err := mail.CreateReader(literal)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return fmt.Errorf("create reader: %w", err)
 	}
 	defer func() {
 		_ = mr.Close()
 	}()
 
-	message := &mailer.Message{
-		UID:  uint32(uidSection.UID),
-		From: parseAddress(mr.Header, "From"),
-		To:   parseAddress(mr.Header, "To"),
-		CC:   parseAddress(mr.Header, "CC"),
-		BCC:  parseAddress(mr.Header, "BCC"),
-	}
-	message.Date, _ = mr.Header.Date()
-	message.Subject, _ = mr.Header.Text("Subject")
+	parseMessageHeader(msg, mr.Header)
 
 	// Process the message's parts
 	for {
@@ -229,49 +299,73 @@ func parseMessage(msg *imapclient.FetchMessageData, client config.ClientConfig)
 		if errors.Is(err, io.EOF) {
 			break
 		}
-		if err != nil {
-			return nil, fmt.Errorf("read message part: %w", err)
+		if err != nil && !message.IsUnknownCharset(err) {
+			return fmt.Errorf("read message part: %w", err)
+		}
+
+		if mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); mediaType == "message/rfc822" {
+			if depth >= maxEmbeddedDepth {
+				continue
+			}
+
+			embedded := &mailer.Message{}
+			msg.Embedded = append(msg.Embedded, embedded)
+
+			if err = parseEmbeddedMessageBody(embedded, part.Body, client, sp, depth+1); err != nil {
+				return fmt.Errorf("embedded message parsing: %w", err)
+			}
+
+			continue
 		}
 
 		switch header := part.Header.(type) {
 		case *mail.InlineHeader:
-			bodyPart, err := parseBodyPart(part, header.Header)
+			bodyPart, err := parseBodyPart(part, header.Header, sp, -1)
 			if err != nil {
-				return nil, fmt.Errorf("body segment parsing: %w", err)
+				return fmt.Errorf("body segment parsing: %w", err)
 			}
 
-			message.BodyParts = append(message.BodyParts, bodyPart)
+			msg.BodyParts = append(msg.BodyParts, bodyPart)
 		case *mail.AttachmentHeader:
 			if !client.IncludeAttachments {
+				if depth == 0 {
+					msg.OmittedAttachments = append(msg.OmittedAttachments, omitAttachment(part, header, 0))
+				}
 				break
 			}
 
-			attachment, err := parseAttachment(part, header)
-			if err != nil {
-				return nil, fmt.Errorf("attachment parsing: %w", err)
-			}
-
-			if attachment.Size > int64(client.MaximumAttachmentsSize) {
+			attachment, err := parseAttachment(part, header, sp, int64(client.MaximumAttachmentsSize))
+			if errors.Is(err, errPartTooLarge) {
+				if depth == 0 {
+					msg.OmittedAttachments = append(msg.OmittedAttachments, omitAttachment(part, header, attachment.Size))
+				}
 				break
 			}
+			if err != nil {
+				return fmt.Errorf("attachment parsing: %w", err)
+			}
 
-			message.Attachments = append(message.Attachments, attachment)
+			msg.Attachments = append(msg.Attachments, attachment)
 		}
 	}
 
-	return message, nil
+	return nil
 }
 
-func parseAttachment(part *mail.Part, header *mail.AttachmentHeader) (mailer.Attachment, error) {
+func parseAttachment(part *mail.Part, header *mail.AttachmentHeader, sp spooler, maxSize int64) (mailer.Attachment, error) {
 	var attachment mailer.Attachment
 	var err error
 
-	attachment.BodySegment, err = parseBodyPart(part, header.Header)
+	attachment.Filename, err = header.Filename()
 	if err != nil {
-		return attachment, fmt.Errorf("parse body part: %w", err)
+		return attachment, fmt.Errorf("get filename: %w", err)
+	}
+
+	_, params, err := header.ContentType()
+	if err != nil {
+		return attachment, fmt.Errorf("get 'Content-Type': %w", err)
 	}
 
-	params := attachment.MIMETypeParams
 	if v, ok := params["creation-date"]; ok {
 		attachment.CreationDate, err = time.Parse(time.RFC822, v)
 		if err != nil {
@@ -293,33 +387,127 @@ func parseAttachment(part *mail.Part, header *mail.AttachmentHeader) (mailer.Att
 		}
 	}
 
-	attachment.Filename, err = header.Filename()
+	// Content is read last, so it is not left
+	// unclosed in case of any previous error.
+	attachment.BodySegment, err = parseBodyPart(part, header.Header, sp, maxSize)
 	if err != nil {
-		return attachment, fmt.Errorf("get filename: %w", err)
+		return attachment, fmt.Errorf("parse body part: %w", err)
 	}
 
 	return attachment, nil
 }
 
-func parseBodyPart(part *mail.Part, header message.Header) (mailer.BodySegment, error) {
+// omitAttachment returns metadata of attachment, which content is not
+// retained. Size is counted by reading the rest of attachment content,
+// read bytes of which were already consumed.
+func omitAttachment(part *mail.Part, header *mail.AttachmentHeader, read int64) mailer.Attachment {
+	var attachment mailer.Attachment
+
+	attachment.Filename, _ = header.Filename()
+	attachment.MIMEType, attachment.MIMETypeParams, _ = header.ContentType()
+
+	n, _ := io.Copy(io.Discard, part.Body)
+	attachment.Size = read + n
+
+	return attachment
+}
+
+func parseBodyPart(part *mail.Part, header message.Header, sp spooler, maxSize int64) (mailer.BodySegment, error) {
 	var segment mailer.BodySegment
-	var buf bytes.Buffer
 	var err error
 
-	segment.Size, err = buf.ReadFrom(part.Body)
+	segment.MIMEType, segment.MIMETypeParams, err = header.ContentType()
 	if err != nil {
-		return segment, fmt.Errorf("read from: %w", err)
+		return segment, fmt.Errorf("get 'Content-Type': %w", err)
 	}
-	segment.Body = &buf
 
-	segment.MIMEType, segment.MIMETypeParams, err = header.ContentType()
+	body := part.Body
+	if strings.HasPrefix(segment.MIMEType, "text/") {
+		body, segment.Charset = decodeText(body, segment.MIMETypeParams["charset"])
+	}
+
+	segment.Body, segment.Size, err = sp.spool(body, maxSize)
 	if err != nil {
-		return segment, fmt.Errorf("get 'Content-Type': %w", err)
+		return segment, fmt.Errorf("spool: %w", err)
 	}
 
 	return segment, nil
 }
 
+// parseMessageHeader fills message fields taken from its header.
+// Malformed optional fields are ignored.
+func parseMessageHeader(message *mailer.Message, header mail.Header) {
+	message.From = parseAddress(header, "From")
+	message.To = parseAddress(header, "To")
+	message.CC = parseAddress(header, "CC")
+	message.BCC = parseAddress(header, "BCC")
+	message.ReplyTo = parseAddress(header, "Reply-To")
+	message.Date, _ = header.Date()
+	message.Subject, _ = header.Text("Subject")
+	message.Header = parseHeader(header)
+
+	message.MessageID, _ = header.MessageID()
+	message.InReplyTo, _ = header.MsgIDList("In-Reply-To")
+	message.References, _ = header.MsgIDList("References")
+	message.ListID = parseListID(message.Header.Get("List-Id"))
+	message.Priority = parsePriority(message.Header)
+	message.AuthenticationResults = message.Header.Values("Authentication-Results")
+}
+
+// parseListID extracts list identifier from 'List-Id' header value,
+// which consists of optional description and identifier in angle brackets,
+// for example: "Announcements <announce.example.com>".
+func parseListID(v string) string {
+	start, end := strings.LastIndexByte(v, '<'), strings.LastIndexByte(v, '>')
+	if start == -1 || end < start {
+		return strings.TrimSpace(v)
+	}
+
+	return strings.TrimSpace(v[start+1 : end])
+}
+
+// parsePriority derives message priority from non-standard, but
+// widely used headers: 'X-Priority' (1-5, where 1 is the highest),
+// 'Priority' (RFC 2156) and 'Importance'.
+func parsePriority(header textproto.MIMEHeader) mailer.Priority {
+	if v := strings.TrimSpace(header.Get("X-Priority")); v != "" {
+		switch v[0] {
+		case '1', '2':
+			return mailer.PriorityHigh
+		case '4', '5':
+			return mailer.PriorityLow
+		}
+	}
+
+	for _, key := range []string{"Priority", "Importance"} {
+		switch strings.ToLower(strings.TrimSpace(header.Get(key))) {
+		case "urgent", "high":
+			return mailer.PriorityHigh
+		case "non-urgent", "low":
+			return mailer.PriorityLow
+		}
+	}
+
+	return mailer.PriorityNormal
+}
+
+// parseHeader returns all header fields with decoded values.
+func parseHeader(header mail.Header) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader, header.Len())
+
+	fields := header.Fields()
+	for fields.Next() {
+		value, err := fields.Text()
+		if err != nil {
+			value = fields.Value()
+		}
+
+		h.Add(fields.Key(), value)
+	}
+
+	return h
+}
+
 func parseAddress(header mail.Header, addressListName string) []mailer.Address {
 	addrList, _ := header.AddressList(addressListName)
 	addrs := make([]mailer.Address, 0, len(addrList))
@@ -339,26 +527,58 @@ func areNoNewMessages(mailbox *imap.SelectData, client config.ClientConfig) bool
 		client.LastUIDNext == uint32(mailbox.UIDNext)
 }
 
-func buildSearchCriteria(filters []string, lastClientUIDNext uint32) (*imap.SearchCriteria, error) {
-	uids := []imap.UIDSet{{imap.UIDRange{
-		Start: imap.UID(lastClientUIDNext),
-	}}}
-	criteria := imap.SearchCriteria{UID: uids}
+// compileFilters parses filter expressions, skipping blank ones.
+func compileFilters(exprs []string) ([]*Filter, error) {
+	var filters []*Filter
 
-	for _, filterExpr := range filters {
-		if strings.TrimSpace(filterExpr) == "" {
+	for _, expr := range exprs {
+		if strings.TrimSpace(expr) == "" {
 			continue
 		}
 
-		newCriteria, err := ParseFilter(filterExpr)
+		filter, err := CompileFilter(expr)
 		if err != nil {
-			return nil, fmt.Errorf("parse filter expression %q: %w", filterExpr, err)
+			return nil, fmt.Errorf("parse filter expression %q: %w", expr, err)
 		}
 
-		criteria.And(newCriteria)
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// buildSearchCriteria intersects criteria of all filters
+// and restricts them to messages not retrieved yet.
+func buildSearchCriteria(filters []*Filter, lastClientUIDNext uint32) *imap.SearchCriteria {
+	uids := []imap.UIDSet{{imap.UIDRange{
+		Start: imap.UID(lastClientUIDNext),
+	}}}
+	criteria := &imap.SearchCriteria{UID: uids}
+
+	for _, filter := range filters {
+		criteria = addAndCriteria(criteria, filter.Criteria())
+	}
+
+	return criteria
+}
+
+func isInexactFilter(filter *Filter) bool {
+	return !filter.Exact()
+}
+
+// matchFilters reports whether message satisfies all filters,
+// using results of Gmail extension searches if any.
+func matchFilters(filters []*Filter, message *mailer.Message, gmail map[gmailQuery]imap.UIDSet) (bool, error) {
+	m := &criteriaMatcher{message: message, gmail: gmail}
+
+	for _, filter := range filters {
+		ok, err := filter.root.eval(m)
+		if err != nil || !ok {
+			return false, err
+		}
 	}
 
-	return &criteria, nil
+	return true, nil
 }
 
 func setUIDs(criteria *imap.SearchCriteria, uids []imap.UIDSet) {
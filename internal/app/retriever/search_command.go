@@ -0,0 +1,123 @@
+package retriever
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Date format of IMAP SEARCH keys.
+const searchDateLayout = "2-Jan-2006"
+
+// FormatSearchCriteria returns search criteria in IMAP SEARCH command
+// syntax (RFC 9051, section 6.4.4), the same way they are sent to server,
+// like `SEARCH UNSEEN FROM "alerts@example.com"`.
+func FormatSearchCriteria(criteria *imap.SearchCriteria) string {
+	if criteria == nil {
+		return "SEARCH ALL"
+	}
+
+	return "SEARCH " + joinSearchKeys(criteria)
+}
+
+// joinSearchKeys returns space separated search keys of criteria,
+// or ALL key matching every message, if there are none of them.
+func joinSearchKeys(c *imap.SearchCriteria) string {
+	keys := searchKeys(c)
+	if len(keys) == 0 {
+		return "ALL"
+	}
+
+	return strings.Join(keys, " ")
+}
+
+func searchKeys(c *imap.SearchCriteria) []string {
+	var keys []string
+
+	for _, seqSet := range c.SeqNum {
+		keys = append(keys, seqSet.String())
+	}
+	for _, uidSet := range c.UID {
+		keys = append(keys, "UID "+uidSet.String())
+	}
+
+	keys = append(keys, dateSearchKeys("", c.Since, c.Before)...)
+	keys = append(keys, dateSearchKeys("SENT", c.SentSince, c.SentBefore)...)
+
+	for _, field := range c.Header {
+		switch k := strings.ToUpper(field.Key); k {
+		case "BCC", "CC", "FROM", "SUBJECT", "TO":
+			keys = append(keys, k+" "+quoteSearchString(field.Value))
+		default:
+			keys = append(keys, "HEADER "+quoteSearchString(field.Key)+" "+quoteSearchString(field.Value))
+		}
+	}
+
+	for _, s := range c.Body {
+		keys = append(keys, "BODY "+quoteSearchString(s))
+	}
+	for _, s := range c.Text {
+		keys = append(keys, "TEXT "+quoteSearchString(s))
+	}
+
+	for _, flag := range c.Flag {
+		keys = append(keys, flagSearchKey(flag, ""))
+	}
+	for _, flag := range c.NotFlag {
+		keys = append(keys, flagSearchKey(flag, "UN"))
+	}
+
+	if c.Larger > 0 {
+		keys = append(keys, "LARGER "+strconv.FormatInt(c.Larger, 10))
+	}
+	if c.Smaller > 0 {
+		keys = append(keys, "SMALLER "+strconv.FormatInt(c.Smaller, 10))
+	}
+	if c.ModSeq != nil {
+		keys = append(keys, "MODSEQ "+strconv.FormatUint(c.ModSeq.ModSeq, 10))
+	}
+
+	for i := range c.Not {
+		keys = append(keys, "NOT ("+joinSearchKeys(&c.Not[i])+")")
+	}
+	for i := range c.Or {
+		keys = append(keys, "OR ("+joinSearchKeys(&c.Or[i][0])+") ("+joinSearchKeys(&c.Or[i][1])+")")
+	}
+
+	return keys
+}
+
+// dateSearchKeys returns SINCE and BEFORE keys, or single ON key
+// for range of one day, optionally prefixed like SENTSINCE.
+func dateSearchKeys(prefix string, since, before time.Time) []string {
+	if !since.IsZero() && !before.IsZero() && before.Sub(since) == 24*time.Hour {
+		return []string{prefix + "ON " + since.Format(searchDateLayout)}
+	}
+
+	var keys []string
+	if !since.IsZero() {
+		keys = append(keys, prefix+"SINCE "+since.Format(searchDateLayout))
+	}
+	if !before.IsZero() {
+		keys = append(keys, prefix+"BEFORE "+before.Format(searchDateLayout))
+	}
+
+	return keys
+}
+
+// flagSearchKey returns search key of system flag, like SEEN or
+// UNSEEN for "UN" prefix, or KEYWORD (UNKEYWORD) key for others.
+func flagSearchKey(flag imap.Flag, prefix string) string {
+	switch flag {
+	case imap.FlagAnswered, imap.FlagDeleted, imap.FlagDraft, imap.FlagFlagged, imap.FlagSeen:
+		return prefix + strings.ToUpper(strings.TrimPrefix(string(flag), `\`))
+	}
+
+	return prefix + "KEYWORD " + string(flag)
+}
+
+func quoteSearchString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
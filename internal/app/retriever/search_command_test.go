@@ -0,0 +1,41 @@
+package retriever
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSearchCriteria(t *testing.T) {
+	tests := []struct {
+		filterExpr string
+		want       string
+	}{
+		{filterExpr: "!SEEN && !JUNK", want: "SEARCH NOT (SEEN) NOT (KEYWORD $Junk)"},
+		{filterExpr: "UNSEEN && KEYWORD != '$Junk'", want: "SEARCH UNSEEN UNKEYWORD $Junk"},
+		{
+			filterExpr: `FROM == 'alerts@example.com' || LIST-ID == 'say "hi"'`,
+			want:       `SEARCH OR (FROM "alerts@example.com") (HEADER "LIST-ID" "say \"hi\"")`,
+		},
+		{
+			filterExpr: "DATE == '2025-03-07' && SENTDATE >= '2025-03-01' && LARGER 1k",
+			want:       "SEARCH ON 7-Mar-2025 SENTSINCE 1-Mar-2025 LARGER 1000",
+		},
+		{filterExpr: "!(BODY == 'x' || TEXT == 'y')", want: `SEARCH NOT (OR (BODY "x") (TEXT "y"))`},
+		{filterExpr: "HAS ATTACHMENT", want: "SEARCH ALL"},
+		{
+			filterExpr: "HAS ATTACHMENT || ATTACHMENT.SIZE > 1M || ATTACHMENT.TYPE == 'application/pdf'",
+			want:       "SEARCH OR (OR (ALL) (LARGER 1000000)) (ALL)",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			filter, err := CompileFilter(tt.filterExpr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, FormatSearchCriteria(filter.Criteria()))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package retriever
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+	"github.com/hickar/chatmailer/internal/pkg/units"
+)
+
+const defaultSpoolMemoryLimit = 1 * units.MiB
+
+var errPartTooLarge = errors.New("message part exceeds size limit")
+
+// spooler stores message parts content keeping small ones in memory
+// and moving larger ones into temporary files, so memory consumption
+// does not depend on size of received emails.
+type spooler struct {
+	dir         string
+	memoryLimit int64
+}
+
+func newSpooler(cfg config.SpoolConfiguration) spooler {
+	s := spooler{
+		dir:         cfg.Directory,
+		memoryLimit: int64(cfg.MemoryLimit),
+	}
+	if s.memoryLimit <= 0 {
+		s.memoryLimit = defaultSpoolMemoryLimit
+	}
+
+	return s
+}
+
+// spool reads content from r. If content is larger than maxSize bytes,
+// reading is stopped and errPartTooLarge is returned. Negative
+// maxSize stands for no limit.
+//
+// Returned body is positioned at the start of content and
+// must be closed by caller, if it implements [io.Closer].
+func (s spooler) spool(r io.Reader, maxSize int64) (io.ReadSeeker, int64, error) {
+	readLimit := s.memoryLimit + 1
+	if maxSize >= 0 && maxSize < s.memoryLimit {
+		readLimit = maxSize + 1
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, readLimit)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, n, fmt.Errorf("read: %w", err)
+	}
+	if maxSize >= 0 && n > maxSize {
+		return nil, n, errPartTooLarge
+	}
+	if n <= s.memoryLimit {
+		return bytes.NewReader(buf.Bytes()), n, nil
+	}
+
+	f, err := os.CreateTemp(s.dir, "chatmailer-part-*")
+	if err != nil {
+		return nil, n, fmt.Errorf("create temporary file: %w", err)
+	}
+	file := &spoolFile{File: f}
+
+	rest := r
+	if maxSize >= 0 {
+		rest = io.LimitReader(r, maxSize-n+1)
+	}
+
+	written, err := io.Copy(f, io.MultiReader(&buf, rest))
+	if err != nil {
+		_ = file.Close()
+		return nil, written, fmt.Errorf("write temporary file: %w", err)
+	}
+	if maxSize >= 0 && written > maxSize {
+		_ = file.Close()
+		return nil, written, errPartTooLarge
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, written, fmt.Errorf("seek temporary file: %w", err)
+	}
+
+	return file, written, nil
+}
+
+// spoolFile is a temporary file removed on close.
+type spoolFile struct {
+	*os.File
+}
+
+func (f *spoolFile) Close() error {
+	return errors.Join(f.File.Close(), os.Remove(f.Name()))
+}
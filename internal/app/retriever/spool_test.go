@@ -0,0 +1,53 @@
+package retriever
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hickar/chatmailer/internal/app/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	sp := newSpooler(config.SpoolConfiguration{Directory: dir, MemoryLimit: 8})
+
+	// Small content is kept in memory.
+	body, size, err := sp.spool(strings.NewReader("small"), -1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+	assert.IsType(t, &bytes.Reader{}, body)
+
+	// Large content is moved to temporary file, removed on close.
+	content := strings.Repeat("large content ", 10)
+	body, size, err = sp.spool(strings.NewReader(content), 1000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+
+	file, ok := body.(*spoolFile)
+	require.True(t, ok)
+
+	b, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(b))
+
+	require.NoError(t, file.Close())
+	_, err = os.Stat(file.Name())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Content exceeding limit is rejected both in memory and on disk.
+	_, _, err = sp.spool(strings.NewReader("1234567"), 4)
+	assert.ErrorIs(t, err, errPartTooLarge)
+
+	_, _, err = sp.spool(strings.NewReader(content), 20)
+	assert.ErrorIs(t, err, errPartTooLarge)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
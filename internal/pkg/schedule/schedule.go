@@ -0,0 +1,216 @@
+// Package schedule implements weekly recurring time ranges,
+// like "Mon-Fri 19:00-09:00" or "Sat,Sun".
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// Schedule is a set of weekly recurring time ranges in specific time zone.
+type Schedule struct {
+	ranges   []timeRange
+	location *time.Location
+}
+
+// timeRange covers minutes of day from start (inclusive) to end (exclusive)
+// on every specified weekday. Range with end not after start continues
+// until end of the next day, like "22:00-07:00".
+type timeRange struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// Parse parses time ranges of schedule in time zone, which is either
+// IANA time zone name, like "Europe/Berlin", or empty for local one.
+//
+// Every range consists of optional weekdays and optional time of day range,
+// at least one of which has to be specified:
+//
+//   - Weekdays are comma-separated days or day ranges, like "Mon-Fri" or "Sat,Sun".
+//     Days are named by their English names or first three letters of them.
+//     Every day is implied if weekdays are omitted.
+//   - Time of day range is start and end times in 24-hour format separated by dash,
+//     like "09:00-18:00". Range with end before start continues on the next day,
+//     like "22:00-07:00". Whole day is implied if time range is omitted.
+func Parse(specs []string, timezone string) (*Schedule, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("load time zone: %w", err)
+		}
+	}
+
+	schedule := &Schedule{location: location}
+	for _, spec := range specs {
+		r, err := parseRange(spec)
+		if err != nil {
+			return nil, fmt.Errorf("parse range %q: %w", spec, err)
+		}
+
+		schedule.ranges = append(schedule.ranges, r)
+	}
+
+	return schedule, nil
+}
+
+func parseRange(spec string) (timeRange, error) {
+	var r timeRange
+
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return r, fmt.Errorf("expected weekdays and/or time range")
+	}
+
+	// Time range is the only field containing colon.
+	days, times := fields[0], fields[len(fields)-1]
+	if strings.Contains(days, ":") {
+		if len(fields) == 2 {
+			return r, fmt.Errorf("weekdays have to precede time range")
+		}
+		days = ""
+	}
+	if !strings.Contains(times, ":") {
+		if len(fields) == 2 {
+			return r, fmt.Errorf("invalid time range %q", times)
+		}
+		times = ""
+	}
+
+	var err error
+	if r.days, err = parseDays(days); err != nil {
+		return r, err
+	}
+
+	r.start, r.end = 0, minutesPerDay
+	if times != "" {
+		start, end, ok := strings.Cut(times, "-")
+		if !ok {
+			return r, fmt.Errorf("invalid time range %q", times)
+		}
+
+		if r.start, err = parseTime(start); err != nil {
+			return r, err
+		}
+		if r.start == minutesPerDay {
+			return r, fmt.Errorf("time range %q starts at the end of day", times)
+		}
+		if r.end, err = parseTime(end); err != nil {
+			return r, err
+		}
+		if r.start == r.end {
+			return r, fmt.Errorf("time range %q is empty", times)
+		}
+	}
+
+	return r, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+
+	if spec == "" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+
+		from, err := parseDay(first)
+		if err != nil {
+			return days, err
+		}
+
+		to := from
+		if isRange {
+			if to, err = parseDay(last); err != nil {
+				return days, err
+			}
+		}
+
+		// Ranges may wrap around week end, like "Fri-Mon".
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parseDay(s string) (time.Weekday, error) {
+	name := strings.ToLower(s)
+	if len(name) >= 3 {
+		if day, ok := weekdays[name[:3]]; ok && strings.HasPrefix(strings.ToLower(day.String()), name) {
+			return day, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
+
+// parseTime returns minute of day of time in "HH:MM" format,
+// "24:00" denotes end of day.
+func parseTime(s string) (int, error) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	if ok && len(minutes) == 2 {
+		h, hErr := strconv.Atoi(hours)
+		m, mErr := strconv.Atoi(minutes)
+
+		if hErr == nil && mErr == nil && h >= 0 && m >= 0 && m < 60 && (h < 24 || h == 24 && m == 0) {
+			return h*60 + m, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+}
+
+// Contains reports whether time falls into any of schedule ranges.
+// Empty schedule contains no time.
+func (s *Schedule) Contains(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+
+	t = t.In(s.location)
+	day, minute := t.Weekday(), t.Hour()*60+t.Minute()
+	previous := (day + 6) % 7
+
+	for _, r := range s.ranges {
+		if r.start < r.end {
+			if r.days[day] && minute >= r.start && minute < r.end {
+				return true
+			}
+			continue
+		}
+
+		// Range continues on the next day.
+		if r.days[day] && minute >= r.start || r.days[previous] && minute < r.end {
+			return true
+		}
+	}
+
+	return false
+}
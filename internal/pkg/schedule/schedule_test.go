@@ -0,0 +1,82 @@
+package schedule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleContains(t *testing.T) {
+	// March 10, 2025 is Monday.
+	at := func(day int, hour, minute int) time.Time {
+		return time.Date(2025, time.March, 9+day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		specs []string
+		time  time.Time
+		want  bool
+	}{
+		{specs: []string{"Mon-Fri 09:00-18:00"}, time: at(1, 9, 0), want: true},
+		{specs: []string{"Mon-Fri 09:00-18:00"}, time: at(1, 18, 0), want: false},
+		{specs: []string{"Mon-Fri 09:00-18:00"}, time: at(6, 12, 0), want: false},
+		{specs: []string{"Mon-Fri 19:00-09:00"}, time: at(5, 23, 30), want: true},
+		{specs: []string{"Mon-Fri 19:00-09:00"}, time: at(6, 8, 59), want: true},
+		{specs: []string{"Mon-Fri 19:00-09:00"}, time: at(6, 9, 0), want: false},
+		{specs: []string{"Mon-Fri 19:00-09:00"}, time: at(1, 8, 0), want: false},
+		{specs: []string{"22:00-07:00"}, time: at(1, 6, 0), want: true},
+		{specs: []string{"sat,SUNDAY"}, time: at(0, 12, 0), want: true},
+		{specs: []string{"Fri-Mon"}, time: at(1, 23, 59), want: true},
+		{specs: []string{"Fri-Mon"}, time: at(2, 0, 0), want: false},
+		{specs: []string{"Mon 00:00-24:00"}, time: at(1, 23, 59), want: true},
+		{specs: []string{"Mon-Fri 19:00-09:00", "Sat,Sun"}, time: at(7, 0, 30), want: true},
+		{specs: nil, time: at(1, 12, 0), want: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			schedule, err := Parse(tt.specs, "UTC")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, schedule.Contains(tt.time))
+		})
+	}
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	schedule, err := Parse([]string{"Mon-Fri 09:00-18:00"}, "Asia/Tokyo")
+	require.NoError(t, err)
+
+	// 09:30 in Tokyo.
+	assert.True(t, schedule.Contains(time.Date(2025, time.March, 10, 0, 30, 0, 0, time.UTC)))
+	assert.False(t, schedule.Contains(time.Date(2025, time.March, 10, 9, 30, 0, 0, time.UTC)))
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec     string
+		timezone string
+		wantErr  string
+	}{
+		{spec: "", wantErr: `parse range "": expected weekdays and/or time range`},
+		{spec: "Mon-Fri 09:00-18:00 UTC", wantErr: `parse range "Mon-Fri 09:00-18:00 UTC": expected weekdays and/or time range`},
+		{spec: "Mun-Fri", wantErr: `parse range "Mun-Fri": unknown weekday "Mun"`},
+		{spec: "Mo", wantErr: `parse range "Mo": unknown weekday "Mo"`},
+		{spec: "09:00-18:00 Mon", wantErr: `parse range "09:00-18:00 Mon": weekdays have to precede time range`},
+		{spec: "Mon Fri", wantErr: `parse range "Mon Fri": invalid time range "Fri"`},
+		{spec: "09:00", wantErr: `parse range "09:00": invalid time range "09:00"`},
+		{spec: "9:0-18:00", wantErr: `parse range "9:0-18:00": invalid time "9:0", expected HH:MM`},
+		{spec: "09:00-24:30", wantErr: `parse range "09:00-24:30": invalid time "24:30", expected HH:MM`},
+		{spec: "09:00-09:00", wantErr: `parse range "09:00-09:00": time range "09:00-09:00" is empty`},
+		{spec: "Sat", timezone: "Mars/Olympus", wantErr: "load time zone: unknown time zone Mars/Olympus"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
+			_, err := Parse([]string{tt.spec}, tt.timezone)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}